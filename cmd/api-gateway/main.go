@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -10,11 +11,16 @@ import (
 	"time"
 
 	"image-processor/internal/config"
+	"image-processor/internal/events"
 	"image-processor/internal/handler"
 	"image-processor/internal/queue/rabbitmq"
-	minioclient "image-processor/internal/storage/minio"
+	pgrepo "image-processor/internal/repository/postgres"
+	"image-processor/internal/storage"
+	"image-processor/internal/storage/backend"
+	"image-processor/internal/transform"
 	"image-processor/pkg/database/postgres"
 	redisclient "image-processor/pkg/database/redis"
+	"image-processor/pkg/security"
 
 	"github.com/gin-gonic/gin"
 )
@@ -39,16 +45,36 @@ func main() {
 	}
 	defer pgPool.Close()
 
-	// Run migrations
-	if err := postgres.RunMigrations(ctx, pgPool); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	// Apply pending migrations, or check that someone else has
+	migrator, err := postgres.NewMigrator(pgPool)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	if cfg.DBAutoMigrate {
+		// Migrations may rewrite large tables, so they get no deadline
+		if err := migrator.Up(context.Background()); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+	} else if err := migrator.CheckSchema(ctx); err != nil {
+		if cfg.DBRequireCurrentSchema {
+			log.Fatalf("Refusing to start: %v", err)
+		}
+		log.Printf("Warning: %v", err)
 	}
 
-	// Initialize Minio
-	log.Println("Connecting to Minio...")
-	minioClient, err := minioclient.NewClient(cfg.MinioEndpoint, cfg.MinioAccessKey, cfg.MinioSecretKey, false)
+	// Initialize object storage
+	layout, err := storage.NewLayout(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to Minio: %v", err)
+		log.Fatalf("Invalid object key layout: %v", err)
+	}
+	pipeline, err := transform.NewPipeline(cfg)
+	if err != nil {
+		log.Fatalf("Invalid processing pipeline: %v", err)
+	}
+	log.Printf("Initializing %s object storage...", cfg.StorageBackend)
+	store, err := backend.New(cfg, layout.Buckets())
+	if err != nil {
+		log.Fatalf("Failed to initialize object storage: %v", err)
 	}
 
 	// Initialize RabbitMQ
@@ -69,8 +95,26 @@ func main() {
 
 	log.Println("✓ Successfully connected to all services")
 
+	// Initialize URL signer
+	var urlSigner *security.URLSigner
+	if len(cfg.URLSigningKeys) > 0 {
+		urlSigner, err = security.NewURLSigner(cfg.URLSigningKeys)
+		if err != nil {
+			log.Fatalf("Failed to load URL signing keys: %v", err)
+		}
+	} else {
+		log.Println("URL_SIGNING_KEYS not set, signed URLs are disabled")
+	}
+
+	// Relay status events from Redis to clients connected to this instance
+	eventHub := events.NewHub()
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+	go eventHub.Run(eventsCtx, redisClient)
+
 	// Initialize handler
-	h := handler.NewHandler(pgPool, minioClient, rabbitClient, redisClient)
+	h := handler.NewHandler(cfg, pgrepo.NewImageRepository(pgPool), pgrepo.NewUploadRepository(pgPool), pgrepo.NewWebhookRepository(pgPool),
+		store, layout, pipeline, rabbitClient, redisClient, urlSigner, eventHub)
 
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode)
@@ -81,12 +125,30 @@ func main() {
 		c.String(http.StatusOK, "# API Gateway Metrics\n# This is a placeholder metrics endpoint\n")
 	})
 
-	// API routes
-	v1 := router.Group("/api/v1")
-	{
-		v1.POST("/upload", h.UploadImage)
-		v1.GET("/images/:id", h.GetImage)
-	}
+	// API routes; bearer tokens are verified against the Keycloak realm
+	jwksURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/certs", cfg.KeycloakURL, cfg.KeycloakRealm)
+	h.Register(router, security.AuthMiddleware(jwksURL, cfg.KeycloakClientID))
+
+	// Periodically discard abandoned resumable uploads
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go func() {
+		ticker := time.NewTicker(cfg.UploadSessionSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sweepCtx.Done():
+				return
+			case <-ticker.C:
+				n, err := h.ExpireUploadSessions(sweepCtx)
+				if err != nil {
+					log.Printf("Failed to expire upload sessions: %v", err)
+				} else if n > 0 {
+					log.Printf("Expired %d abandoned upload sessions", n)
+				}
+			}
+		}
+	}()
 
 	// Start HTTP server in a goroutine
 	srv := &http.Server{
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"image-processor/internal/config"
+	pgrepo "image-processor/internal/repository/postgres"
+	"image-processor/internal/retention"
+	"image-processor/internal/storage"
+	"image-processor/internal/storage/backend"
+	"image-processor/pkg/database/postgres"
+	redisclient "image-processor/pkg/database/redis"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would be deleted without changing anything")
+	interval := flag.Duration("interval", 0, "run repeatedly at this interval instead of once")
+	metricsFile := flag.String("metrics-file", "", "write Prometheus metrics to this file after each run")
+	pushGateway := flag.String("pushgateway", "", "push Prometheus metrics to this Pushgateway URL after each run")
+	flag.Parse()
+
+	log.Println("Starting retention janitor...")
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log.Println("Connecting to PostgreSQL...")
+	pgPool, err := postgres.NewClient(ctx, cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer pgPool.Close()
+
+	layout, err := storage.NewLayout(cfg)
+	if err != nil {
+		log.Fatalf("Invalid object key layout: %v", err)
+	}
+	log.Printf("Initializing %s object storage...", cfg.StorageBackend)
+	store, err := backend.New(cfg, layout.Buckets())
+	if err != nil {
+		log.Fatalf("Failed to initialize object storage: %v", err)
+	}
+
+	// Redis only holds cached image metadata, so the janitor can run without it
+	redisClient, err := redisclient.NewClient(cfg.RedisURL)
+	if err != nil {
+		log.Printf("Warning: failed to connect to Redis, cached image metadata will expire on its own: %v", err)
+		redisClient = nil
+	} else {
+		defer redisClient.Close()
+	}
+
+	policy := retention.PolicyFromConfig(cfg)
+	log.Printf("Retention policy: originals after %s, processed inactive after %s, deleted grace %s (0 = disabled)",
+		policy.OriginalsAfter, policy.ProcessedInactiveAfter, policy.DeletedGrace)
+	janitor := retention.NewJanitor(pgrepo.NewRetentionRepository(pgPool), store, layout, redisClient, policy, *dryRun)
+
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		failed := !runOnce(runCtx, janitor, *metricsFile, *pushGateway)
+		if *interval <= 0 {
+			if failed {
+				os.Exit(1)
+			}
+			return
+		}
+
+		select {
+		case <-runCtx.Done():
+			log.Println("Retention janitor stopped")
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// runOnce runs the janitor and publishes its metrics. It reports false if
+// the run did not complete.
+func runOnce(ctx context.Context, janitor *retention.Janitor, metricsFile, pushGateway string) bool {
+	report, err := janitor.Run(ctx)
+	if err != nil {
+		log.Printf("Retention run failed: %v", err)
+	}
+
+	mode := ""
+	if report.DryRun {
+		mode = " (dry run)"
+	}
+	for _, rule := range []string{retention.RuleDeleted, retention.RuleOriginals, retention.RuleProcessed} {
+		stats := report.Rules[rule]
+		if stats == nil {
+			continue
+		}
+		log.Printf("Retention %s%s: %d images, %d objects, %d bytes, %d errors",
+			rule, mode, stats.Images, stats.Objects, stats.Bytes, stats.Errors)
+	}
+	log.Printf("Retention run finished in %s", report.Duration.Round(time.Millisecond))
+
+	if metricsFile != "" {
+		if err := report.WriteMetricsFile(metricsFile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	if pushGateway != "" {
+		if err := report.PushMetrics(ctx, pushGateway, "image-janitor"); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	return err == nil
+}
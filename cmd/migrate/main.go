@@ -2,39 +2,111 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"time"
 
 	"image-processor/internal/config"
 	"image-processor/pkg/database/postgres"
 )
 
+const usage = `Usage: migrate [command]
+
+Commands:
+  up            apply every pending migration (default)
+  down [n]      revert the newest n applied migrations (default 1)
+  to <version>  migrate up or down to version; 0 reverts everything
+  status        list migrations and whether they are applied
+`
+
 func main() {
-	log.Println("Starting migration runner...")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	command, args := "up", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
-	log.Printf("Loaded URL: %s", cfg.PostgresURL)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	connectCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	log.Printf("Connecting to Postgres at %s", cfg.PostgresURL)
-	pool, err := postgres.NewClient(ctx, cfg.PostgresURL)
+	pool, err := postgres.NewClient(connectCtx, cfg.PostgresURL)
 	if err != nil {
-		// Fallback for docker internal networking vs localhost
-		// In docker-compose, internal service sees 'postgres', but localhost sees 'localhost'.
-		// The default config uses localhost, which should work for this runner running on host.
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer pool.Close()
 
-	log.Println("Connected to database. Running migrations...")
-	if err := postgres.RunMigrations(ctx, pool); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	migrator, err := postgres.NewMigrator(pool)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	// Migrations may rewrite large tables, so they get no deadline
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q", args[0])
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "to":
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		version, parseErr := strconv.ParseInt(args[0], 10, 64)
+		if parseErr != nil || version < 0 {
+			log.Fatalf("Invalid version %q", args[0])
+		}
+		err = migrator.To(ctx, version)
+	case "status":
+		err = printStatus(ctx, migrator)
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
+	if err != nil {
+		log.Fatalf("Migration %s failed: %v", command, err)
+	}
+	if command != "status" {
+		log.Printf("Migration %s finished successfully", command)
+	}
+}
 
-	log.Println("Migration runner finished successfully.")
+func printStatus(ctx context.Context, migrator *postgres.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-8s %-30s %-10s %s\n", "VERSION", "NAME", "STATE", "APPLIED AT")
+	for _, s := range statuses {
+		state, appliedAt := "pending", ""
+		if s.Applied {
+			state = "applied"
+			appliedAt = s.AppliedAt.Local().Format(time.RFC3339)
+		}
+		switch {
+		case s.Modified:
+			state = "modified"
+		case s.Unknown:
+			state = "unknown"
+		}
+		fmt.Printf("%-8d %-30s %-10s %s\n", s.Version, s.Name, state, appliedAt)
+	}
+	return nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"image-processor/internal/config"
+	"image-processor/internal/queue/rabbitmq"
+	"image-processor/internal/reconcile"
+	pgrepo "image-processor/internal/repository/postgres"
+	"image-processor/internal/storage"
+	"image-processor/internal/storage/backend"
+	"image-processor/internal/webhook"
+	"image-processor/pkg/database/postgres"
+	redisclient "image-processor/pkg/database/redis"
+	"image-processor/pkg/security"
+)
+
+func main() {
+	minAge := flag.Duration("min-age", time.Hour, "ignore objects and images changed more recently than this")
+	deleteOrphans := flag.Bool("delete-orphans", false, "delete objects that no image references")
+	repair := flag.Bool("repair", false, "re-enqueue completed images whose processed image is missing and mark images whose original is missing failed")
+	requeuePending := flag.Bool("requeue-pending", false, "with -repair, also re-enqueue pending and processing images; only use once the queue has drained")
+	flag.Parse()
+
+	log.Println("Starting reconciliation...")
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log.Println("Connecting to PostgreSQL...")
+	pgPool, err := postgres.NewClient(ctx, cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer pgPool.Close()
+
+	layout, err := storage.NewLayout(cfg)
+	if err != nil {
+		log.Fatalf("Invalid object key layout: %v", err)
+	}
+	log.Printf("Initializing %s object storage...", cfg.StorageBackend)
+	store, err := backend.New(cfg, layout.Buckets())
+	if err != nil {
+		log.Fatalf("Failed to initialize object storage: %v", err)
+	}
+
+	// Repairs change statuses, which clients learn about through Redis,
+	// and requeue work through RabbitMQ
+	var redisClient *redisclient.Client
+	images := pgrepo.NewImageRepository(pgPool)
+	var rabbitClient *rabbitmq.Client
+	var notifier *webhook.Notifier
+	if *repair {
+		log.Println("Connecting to Redis...")
+		redisClient, err = redisclient.NewClient(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		defer redisClient.Close()
+
+		log.Println("Connecting to RabbitMQ...")
+		rabbitClient, err = rabbitmq.NewClient(cfg.RabbitMQURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		}
+		defer rabbitClient.Close()
+
+		var urlSigner *security.URLSigner
+		if len(cfg.URLSigningKeys) > 0 {
+			urlSigner, err = security.NewURLSigner(cfg.URLSigningKeys)
+			if err != nil {
+				log.Fatalf("Invalid URL signing keys: %v", err)
+			}
+		}
+		notifier = webhook.NewNotifier(images, pgrepo.NewWebhookRepository(pgPool), urlSigner, cfg.PublicBaseURL, cfg.SignedURLMaxTTL)
+	}
+
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reconciler := reconcile.NewReconciler(images, pgrepo.NewUploadRepository(pgPool), store, layout, redisClient, rabbitClient, notifier, reconcile.Options{
+		MinAge:         *minAge,
+		DeleteOrphans:  *deleteOrphans,
+		Repair:         *repair,
+		RequeuePending: *requeuePending,
+	})
+	report, err := reconciler.Run(runCtx)
+	if err != nil {
+		log.Fatalf("Reconciliation failed: %v", err)
+	}
+
+	var orphanBytes int64
+	for _, orphan := range report.Orphans {
+		orphanBytes += orphan.Size
+		fmt.Printf("orphan\t%s/%s\t%d bytes\t%s\n", orphan.Bucket, orphan.Key, orphan.Size, orphan.Reason)
+	}
+	for _, problem := range report.Problems {
+		action := problem.Action
+		if action == "" {
+			action = "none"
+		}
+		fmt.Printf("image\t%s\t%s\t%s\taction: %s\n", problem.ImageID, problem.Status, problem.Reason, action)
+	}
+
+	log.Printf("Checked %d objects and %d images: %d orphans (%d bytes), %d images with missing objects",
+		report.Objects, report.Images, len(report.Orphans), orphanBytes, len(report.Problems))
+	if *deleteOrphans {
+		log.Printf("Deleted %d of %d orphans", report.Deleted, len(report.Orphans))
+	}
+	if *repair {
+		log.Printf("Repaired %d images", report.Repaired)
+	}
+	if !*deleteOrphans && !*repair && (len(report.Orphans) > 0 || len(report.Problems) > 0) {
+		log.Println("Nothing was changed; use -delete-orphans and -repair to fix these")
+	}
+}
@@ -11,23 +11,23 @@ import (
 	"time"
 
 	"image-processor/internal/config"
+	"image-processor/internal/queue"
 	"image-processor/internal/queue/rabbitmq"
-	minioclient "image-processor/internal/storage/minio"
+	pgrepo "image-processor/internal/repository/postgres"
+	"image-processor/internal/storage"
+	"image-processor/internal/storage/backend"
+	"image-processor/internal/transform"
+	"image-processor/internal/webhook"
 	"image-processor/internal/worker"
 	"image-processor/pkg/database/postgres"
 	redisclient "image-processor/pkg/database/redis"
+	"image-processor/pkg/security"
 
 	"github.com/google/uuid"
 )
 
 const WorkerPoolSize = 5
 
-type TaskMessage struct {
-	ImageID    string `json:"image_id"`
-	BucketName string `json:"bucket_name"`
-	ObjectName string `json:"object_name"`
-}
-
 func main() {
 	log.Println("Starting Worker Service...")
 
@@ -48,11 +48,19 @@ func main() {
 	}
 	defer pgPool.Close()
 
-	// Initialize Minio
-	log.Println("Connecting to Minio...")
-	minioClient, err := minioclient.NewClient(cfg.MinioEndpoint, cfg.MinioAccessKey, cfg.MinioSecretKey, false)
+	// Initialize object storage
+	layout, err := storage.NewLayout(cfg)
+	if err != nil {
+		log.Fatalf("Invalid object key layout: %v", err)
+	}
+	pipeline, err := transform.NewPipeline(cfg)
+	if err != nil {
+		log.Fatalf("Invalid processing pipeline: %v", err)
+	}
+	log.Printf("Initializing %s object storage...", cfg.StorageBackend)
+	store, err := backend.New(cfg, layout.Buckets())
 	if err != nil {
-		log.Fatalf("Failed to connect to Minio: %v", err)
+		log.Fatalf("Failed to initialize object storage: %v", err)
 	}
 
 	// Initialize RabbitMQ
@@ -73,8 +81,25 @@ func main() {
 
 	log.Println("✓ Successfully connected to all services")
 
+	// Webhook payloads link to signed URLs when signing keys are configured
+	var urlSigner *security.URLSigner
+	if len(cfg.URLSigningKeys) > 0 {
+		urlSigner, err = security.NewURLSigner(cfg.URLSigningKeys)
+		if err != nil {
+			log.Fatalf("Invalid URL signing keys: %v", err)
+		}
+	}
+	images := pgrepo.NewImageRepository(pgPool)
+	webhooks := pgrepo.NewWebhookRepository(pgPool)
+	notifier := webhook.NewNotifier(images, webhooks, urlSigner, cfg.PublicBaseURL, cfg.SignedURLMaxTTL)
+
+	// Deliver webhooks in the background
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+	defer stopDispatch()
+	go webhook.NewDispatcher(cfg, webhooks).Run(dispatchCtx)
+
 	// Create processor
-	processor := worker.NewProcessor(pgPool, minioClient, redisClient)
+	processor := worker.NewProcessor(cfg, images, store, layout, pipeline, redisClient, notifier)
 
 	// Start consuming messages
 	msgs, err := rabbitClient.Consume()
@@ -84,7 +109,7 @@ func main() {
 
 	// Create worker pool
 	var wg sync.WaitGroup
-	taskChan := make(chan TaskMessage, WorkerPoolSize)
+	taskChan := make(chan queue.TaskMessage, WorkerPoolSize)
 
 	// Start worker goroutines
 	for i := 0; i < WorkerPoolSize; i++ {
@@ -103,7 +128,11 @@ func main() {
 				}
 
 				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-				err = processor.ProcessImage(ctx, imageID, task.BucketName, task.ObjectName)
+				if task.SourceURL != "" {
+					err = processor.ImportImage(ctx, imageID, task.BucketName, task.SourceURL)
+				} else {
+					err = processor.ProcessImage(ctx, imageID, task.BucketName, task.ObjectName)
+				}
 				cancel()
 
 				if err != nil {
@@ -126,7 +155,7 @@ func main() {
 	// Message consumer loop
 	go func() {
 		for msg := range msgs {
-			var task TaskMessage
+			var task queue.TaskMessage
 			if err := json.Unmarshal(msg.Body, &task); err != nil {
 				log.Printf("Failed to unmarshal message: %v", err)
 				msg.Nack(false, false) // discard invalid message
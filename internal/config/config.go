@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -14,6 +16,126 @@ type Config struct {
 	KeycloakURL      string `envconfig:"KEYCLOAK_URL" default:"http://localhost:8080"`
 	KeycloakRealm    string `envconfig:"KEYCLOAK_REALM" default:"ImageProcessor"`
 	KeycloakClientID string `envconfig:"KEYCLOAK_CLIENT_ID" default:"api-gateway-client"`
+
+	// Schema migrations. The gateway applies pending migrations at startup
+	// unless DBAutoMigrate is off; then it only checks the schema, and
+	// refuses to start when it is behind if DBRequireCurrentSchema is set.
+	DBAutoMigrate          bool `envconfig:"DB_AUTO_MIGRATE" default:"true"`
+	DBRequireCurrentSchema bool `envconfig:"DB_REQUIRE_CURRENT_SCHEMA" default:"false"`
+
+	// Object storage backend: minio (also any S3-compatible service), local
+	// (files under StorageLocalPath) or memory (single process only)
+	StorageBackend   string `envconfig:"STORAGE_BACKEND" default:"minio"`
+	StorageLocalPath string `envconfig:"STORAGE_LOCAL_PATH" default:"./data/objects"`
+
+	// S3 connection. MinioCredentials is the ordered provider chain: static
+	// (MINIO_ACCESS_KEY/MINIO_SECRET_KEY), env, file, iam and web-identity.
+	MinioUseSSL               bool     `envconfig:"MINIO_USE_SSL" default:"false"`
+	MinioCAFile               string   `envconfig:"MINIO_CA_FILE"`
+	MinioRegion               string   `envconfig:"MINIO_REGION"`
+	MinioBucketLookup         string   `envconfig:"MINIO_BUCKET_LOOKUP" default:"auto"` // auto, path or virtual
+	MinioCredentials          []string `envconfig:"MINIO_CREDENTIALS" default:"static"`
+	MinioCredentialsFile      string   `envconfig:"MINIO_CREDENTIALS_FILE"`
+	MinioCredentialsProfile   string   `envconfig:"MINIO_CREDENTIALS_PROFILE"`
+	MinioSTSEndpoint          string   `envconfig:"MINIO_STS_ENDPOINT"`
+	MinioWebIdentityTokenFile string   `envconfig:"MINIO_WEB_IDENTITY_TOKEN_FILE"`
+	MinioRoleARN              string   `envconfig:"MINIO_ROLE_ARN"`
+
+	// Server-side encryption of stored objects: none, sse-s3 or sse-c. SSE-C
+	// keys are derived per object from "id:secret" master keys; the first
+	// encrypts new objects, all are tried for reads.
+	StorageEncryption     string   `envconfig:"STORAGE_ENCRYPTION" default:"none"`
+	StorageEncryptionKeys []string `envconfig:"STORAGE_ENCRYPTION_KEYS"`
+
+	// Buckets and object key templates. Templates may use {id}, {tenant},
+	// {yyyy}, {mm}, {dd}, {variant} and {ext}. Both buckets may be the same
+	// as long as the templates keep keys apart, e.g. with a prefix.
+	RawBucket            string `envconfig:"RAW_BUCKET" default:"raw-images"`
+	ProcessedBucket      string `envconfig:"PROCESSED_BUCKET" default:"processed-images"`
+	OriginalKeyTemplate  string `envconfig:"ORIGINAL_KEY_TEMPLATE" default:"{id}.{ext}"`
+	ProcessedKeyTemplate string `envconfig:"PROCESSED_KEY_TEMPLATE" default:"{id}.{ext}"`
+	RenderKeyTemplate    string `envconfig:"RENDER_KEY_TEMPLATE" default:"renders/{id}/{variant}.{ext}"`
+
+	// Image metadata cache TTLs for terminal (completed/failed) states,
+	// in-flight states and unknown IDs
+	ImageCacheTTL         time.Duration `envconfig:"IMAGE_CACHE_TTL" default:"10m"`
+	ImageCacheInFlightTTL time.Duration `envconfig:"IMAGE_CACHE_IN_FLIGHT_TTL" default:"5s"`
+	ImageCacheNegativeTTL time.Duration `envconfig:"IMAGE_CACHE_NEGATIVE_TTL" default:"30s"`
+
+	// Uploads. With deduplication, an upload identical to an image already
+	// processed by the same pipeline shares its objects instead of being
+	// processed again.
+	DedupEnabled  bool          `envconfig:"DEDUP_ENABLED" default:"true"`
+	MaxUploadSize int64         `envconfig:"MAX_UPLOAD_SIZE" default:"209715200"` // 200MB
+	UploadTimeout time.Duration `envconfig:"UPLOAD_TIMEOUT" default:"10m"`
+	PresignExpiry time.Duration `envconfig:"PRESIGN_EXPIRY" default:"15m"`
+	// MaxImagePixels bounds the width times height of every image the
+	// worker or gateway decodes, checked from its header; 0 allows any size
+	MaxImagePixels int64 `envconfig:"MAX_IMAGE_PIXELS" default:"50000000"`
+
+	// Resumable uploads
+	UploadSessionTTL           time.Duration `envconfig:"UPLOAD_SESSION_TTL" default:"24h"`
+	UploadSessionSweepInterval time.Duration `envconfig:"UPLOAD_SESSION_SWEEP_INTERVAL" default:"10m"`
+
+	// Remote URL imports (fetched by the worker)
+	ImportMaxSize      int64         `envconfig:"IMPORT_MAX_SIZE" default:"209715200"` // 200MB
+	ImportTimeout      time.Duration `envconfig:"IMPORT_TIMEOUT" default:"60s"`
+	ImportMaxRedirects int           `envconfig:"IMPORT_MAX_REDIRECTS" default:"5"`
+	ImportAllowPrivate bool          `envconfig:"IMPORT_ALLOW_PRIVATE" default:"false"`
+
+	// Retention rules enforced by the janitor; zero disables a rule. Originals
+	// are deleted that long after processing completed, processed outputs and
+	// renders of images whose content was not served for that long, and
+	// deleted images are purged after the grace period.
+	RetentionOriginalsAfter         time.Duration `envconfig:"RETENTION_ORIGINALS_AFTER" default:"0"`
+	RetentionProcessedInactiveAfter time.Duration `envconfig:"RETENTION_PROCESSED_INACTIVE_AFTER" default:"0"`
+	RetentionDeletedGrace           time.Duration `envconfig:"RETENTION_DELETED_GRACE" default:"168h"`
+	RetentionBatchSize              int           `envconfig:"RETENTION_BATCH_SIZE" default:"100"`
+
+	// Watermark composited onto every processed image by the worker: the
+	// original of image WatermarkLogoID, or WatermarkText. It is off while
+	// both are empty. It is part of the pipeline key, so uploads processed
+	// before it changed are not reused as duplicates.
+	WatermarkLogoID   string  `envconfig:"WATERMARK_LOGO_ID"`
+	WatermarkText     string  `envconfig:"WATERMARK_TEXT"`
+	WatermarkPosition string  `envconfig:"WATERMARK_POSITION" default:"bottom-right"`
+	WatermarkOpacity  float64 `envconfig:"WATERMARK_OPACITY" default:"0.5"`
+	WatermarkScale    float64 `envconfig:"WATERMARK_SCALE" default:"0.25"` // width relative to the image
+	WatermarkTile     bool    `envconfig:"WATERMARK_TILE" default:"false"`
+
+	// Cache-Control max-age for image content served by the gateway
+	ContentCacheMaxAge time.Duration `envconfig:"CONTENT_CACHE_MAX_AGE" default:"24h"`
+
+	// On-the-fly rendering. Empty allow-lists accept any value within range.
+	RenderMaxDimension     int   `envconfig:"RENDER_MAX_DIMENSION" default:"4096"`
+	RenderAllowedSizes     []int `envconfig:"RENDER_ALLOWED_SIZES"`
+	RenderAllowedQualities []int `envconfig:"RENDER_ALLOWED_QUALITIES"`
+	RenderConcurrency      int   `envconfig:"RENDER_CONCURRENCY" default:"4"`
+
+	// ModerationRole is the Keycloak realm role required to search similar
+	// images across all owners
+	ModerationRole string `envconfig:"MODERATION_ROLE" default:"moderator"`
+
+	// Signed URLs. Keys are "id:secret" pairs; the first signs, all verify.
+	URLSigningKeys      []string      `envconfig:"URL_SIGNING_KEYS"`
+	SignedURLDefaultTTL time.Duration `envconfig:"SIGNED_URL_DEFAULT_TTL" default:"1h"`
+	SignedURLMaxTTL     time.Duration `envconfig:"SIGNED_URL_MAX_TTL" default:"168h"`
+	PublicBaseURL       string        `envconfig:"PUBLIC_BASE_URL"`
+
+	// Status event streams. Signed event URLs only need to be valid when
+	// the stream is opened. WebSocket upgrades from browsers are accepted
+	// from the gateway's own origin and the listed ones ("*" for any).
+	EventsURLTTL         time.Duration `envconfig:"EVENTS_URL_TTL" default:"5m"`
+	EventsAllowedOrigins []string      `envconfig:"EVENTS_ALLOWED_ORIGINS"`
+
+	// Outbound webhooks. Subscriptions and per-upload callbacks each have
+	// their own signing secret.
+	WebhookMaxAttempts  int           `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	WebhookBackoffBase  time.Duration `envconfig:"WEBHOOK_BACKOFF_BASE" default:"30s"`
+	WebhookBackoffMax   time.Duration `envconfig:"WEBHOOK_BACKOFF_MAX" default:"1h"`
+	WebhookTimeout      time.Duration `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+	WebhookPollInterval time.Duration `envconfig:"WEBHOOK_POLL_INTERVAL" default:"5s"`
+	WebhookAllowPrivate bool          `envconfig:"WEBHOOK_ALLOW_PRIVATE" default:"false"`
 }
 
 func LoadConfig() (*Config, error) {
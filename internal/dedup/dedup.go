@@ -0,0 +1,49 @@
+package dedup
+
+import (
+	"context"
+	"log"
+
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// Deduplicator lets images with identical sources share the objects of an
+// image that was already processed with the same pipeline. The repository
+// reference counts shared objects, so they are only deleted once no image
+// references them.
+type Deduplicator struct {
+	images repository.ImageRepository
+	store  storage.ObjectStore
+}
+
+func NewDeduplicator(images repository.ImageRepository, store storage.ObjectStore) *Deduplicator {
+	return &Deduplicator{images: images, store: store}
+}
+
+// Link points imageID at the original and processed objects of a completed
+// image with the same checksum and pipeline and marks it completed. It
+// returns false, changing nothing, when there is no such image. Objects the
+// image referenced before, such as its own copy of the original, are
+// deleted unless other images share them.
+func (d *Deduplicator) Link(ctx context.Context, imageID uuid.UUID, checksum, pipeline string) (bool, error) {
+	if checksum == "" {
+		return false, nil
+	}
+
+	duplicate, err := d.images.LinkDuplicate(ctx, imageID, checksum, pipeline)
+	if err != nil || duplicate == nil {
+		return false, err
+	}
+
+	for _, obj := range duplicate.Unreferenced {
+		if err := d.store.DeleteFile(ctx, obj.Bucket, obj.Key); err != nil {
+			log.Printf("Warning: failed to delete %s/%s: %v", obj.Bucket, obj.Key, err)
+		}
+	}
+
+	log.Printf("Image %s is a duplicate of %s; sharing its objects", imageID, duplicate.SourceID)
+	return true, nil
+}
@@ -0,0 +1,115 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	redisclient "image-processor/pkg/database/redis"
+)
+
+// StatusChannel is the Redis pub/sub channel carrying image status transitions
+const StatusChannel = "image-status"
+
+// subscriberBuffer is the number of events queued per subscriber before new
+// events are dropped for it
+const subscriberBuffer = 16
+
+// StatusEvent describes an image moving to a new status
+type StatusEvent struct {
+	ImageID   string    `json:"image_id"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PublishStatus announces a status transition to every gateway instance
+func PublishStatus(ctx context.Context, redis *redisclient.Client, event StatusEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode status event: %w", err)
+	}
+	return redis.Publish(ctx, StatusChannel, payload)
+}
+
+// Hub fans status events received from Redis out to local subscribers, so
+// each gateway instance needs a single Redis subscription however many
+// clients are listening
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan StatusEvent]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[string]map[chan StatusEvent]struct{})}
+}
+
+// Subscribe returns a channel of events for one image and a function that
+// must be called to stop receiving them
+func (h *Hub) Subscribe(imageID string) (<-chan StatusEvent, func()) {
+	ch := make(chan StatusEvent, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subscribers[imageID] == nil {
+		h.subscribers[imageID] = make(map[chan StatusEvent]struct{})
+	}
+	h.subscribers[imageID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers[imageID], ch)
+		if len(h.subscribers[imageID]) == 0 {
+			delete(h.subscribers, imageID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Broadcast delivers an event to the subscribers of its image without blocking
+func (h *Hub) Broadcast(event StatusEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subscribers[event.ImageID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Warning: dropping status event for slow subscriber of image %s", event.ImageID)
+		}
+	}
+}
+
+// Run relays events from Redis to local subscribers until ctx is cancelled.
+// The initial subscription is retried until it succeeds; after that the
+// Redis client re-establishes dropped connections itself.
+func (h *Hub) Run(ctx context.Context, redis *redisclient.Client) {
+	var messages <-chan string
+	for {
+		var err error
+		messages, err = redis.Subscribe(ctx, StatusChannel)
+		if err == nil {
+			break
+		}
+		log.Printf("Failed to subscribe to status events, retrying: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	log.Printf("Relaying status events from Redis channel: %s", StatusChannel)
+	for payload := range messages {
+		var event StatusEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Printf("Failed to decode status event: %v", err)
+			continue
+		}
+		h.Broadcast(event)
+	}
+}
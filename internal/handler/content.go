@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"image-processor/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// VariantProcessed is the worker output and the default variant
+	VariantProcessed = "processed"
+	// VariantOriginal is the file as it was uploaded
+	VariantOriginal = "original"
+
+	// accessTouchInterval is how stale accessed_at may get before serving
+	// content updates it
+	accessTouchInterval = time.Hour
+)
+
+// GetImageContent streams an image variant from Minio through the gateway.
+// Responses carry ETag, Last-Modified and Cache-Control headers and honour
+// conditional and Range requests, so the signed public route can sit behind
+// a CDN without exposing Minio to clients.
+func (h *Handler) GetImageContent(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	// The object is read while the response is written, so don't cut it off early
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.UploadTimeout)
+	defer cancel()
+
+	image, err := h.images.Get(ctx, imageID)
+	if err != nil || !canRead(c, image.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	variant := c.DefaultQuery("variant", VariantProcessed)
+	bucketName, objectName, status, errMsg := h.resolveVariant(image, variant)
+	if errMsg != "" {
+		c.JSON(status, gin.H{"error": errMsg})
+		return
+	}
+	h.touchImage(ctx, image)
+
+	object, info, err := h.store.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image content not found"})
+		return
+	}
+	defer object.Close()
+
+	h.setContentHeaders(c, info.ETag, info.ContentType, downloadName(image.Filename, objectName))
+	http.ServeContent(c.Writer, c.Request, objectName, info.LastModified, object)
+}
+
+// setContentHeaders sets the caching and presentation headers shared by every
+// endpoint that serves image bytes. http.ServeContent uses the ETag for
+// conditional requests. Only signed URLs may be cached by shared caches;
+// bearer requests return images only their owner may read.
+func (h *Handler) setContentHeaders(c *gin.Context, etag, contentType, filename string) {
+	header := c.Writer.Header()
+	if etag != "" {
+		header.Set("ETag", fmt.Sprintf("%q", etag))
+	}
+	scope := "private"
+	if c.GetBool("signed") {
+		scope = "public"
+	}
+	header.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(h.cfg.ContentCacheMaxAge.Seconds())))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+}
+
+// resolveVariant maps a variant name to its Minio location. On failure it
+// returns the HTTP status and message to send instead.
+func (h *Handler) resolveVariant(image *models.Image, variant string) (string, string, int, string) {
+	switch variant {
+	case VariantOriginal:
+		if image.Status == models.ImageStatusUploading {
+			return "", "", http.StatusNotFound, "Original has not been uploaded yet"
+		}
+		if originalExpired(image) {
+			return "", "", http.StatusGone, "Original has expired"
+		}
+		if image.OriginalKey == "" {
+			return "", "", http.StatusNotFound, "Original has not been stored yet"
+		}
+		return image.BucketName, image.OriginalKey, 0, ""
+	case VariantProcessed:
+		if image.Status == models.ImageStatusExpired {
+			return "", "", http.StatusGone, "Processed image has expired"
+		}
+		if image.Status != models.ImageStatusCompleted {
+			return "", "", http.StatusConflict, fmt.Sprintf("Image is not processed yet (status: %s)", image.Status)
+		}
+		return image.ProcessedBucket, image.ProcessedKey, 0, ""
+	}
+	return "", "", http.StatusBadRequest, fmt.Sprintf("Unknown variant %q", variant)
+}
+
+// originalExpired reports whether the retention janitor deleted the original
+// of a processed image
+func originalExpired(image *models.Image) bool {
+	processed := image.Status == models.ImageStatusCompleted || image.Status == models.ImageStatusExpired
+	return processed && image.OriginalKey == ""
+}
+
+// touchImage records that the content of an image was served, which keeps
+// its processed outputs from expiring. Writes are throttled to one per
+// accessTouchInterval.
+func (h *Handler) touchImage(ctx context.Context, image *models.Image) {
+	if image.AccessedAt != nil && time.Since(*image.AccessedAt) < accessTouchInterval {
+		return
+	}
+	if err := h.images.Touch(ctx, image.ID); err != nil {
+		log.Printf("Warning: failed to record access to image %s: %v", image.ID, err)
+	}
+}
+
+// contentURL is the gateway path that serves the processed image
+func contentURL(imageID string) string {
+	return fmt.Sprintf("/api/v1/images/%s/content", imageID)
+}
+
+// downloadName builds the filename suggested to clients from the original
+// name and the extension of the stored object
+func downloadName(filename, objectName string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if base == "" {
+		base = strings.TrimSuffix(objectName, filepath.Ext(objectName))
+	}
+	return base + filepath.Ext(objectName)
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"image-processor/internal/events"
+	"image-processor/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+// heartbeatInterval keeps idle event streams from being closed by proxies
+const heartbeatInterval = 15 * time.Second
+
+// ImageEvents streams status transitions of an image as Server-Sent Events.
+// The current status is sent first and the stream ends after a terminal
+// status (completed or failed).
+func (h *Handler) ImageEvents(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	updates, initial, ok := h.subscribeImageEvents(c, imageID)
+	if !ok {
+		return
+	}
+	defer updates.unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("status", initial)
+	c.Writer.Flush()
+	if isTerminal(initial.Status) {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case event := <-updates.events:
+			c.SSEvent("status", event)
+			return !isTerminal(event.Status)
+		}
+	})
+}
+
+// ImageEventsWebSocket pushes the same status events as ImageEvents over a
+// WebSocket, one JSON message per event
+func (h *Handler) ImageEventsWebSocket(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	updates, initial, ok := h.subscribeImageEvents(c, imageID)
+	if !ok {
+		return
+	}
+	defer updates.unsubscribe()
+
+	server := websocket.Server{
+		// Browsers send cookies and signed URLs along with cross-site
+		// upgrades, so only trusted pages may open the socket
+		Handshake: func(_ *websocket.Config, r *http.Request) error { return h.checkOrigin(r) },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			// Reading is the only way to notice the client going away
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				io.Copy(io.Discard, ws)
+			}()
+
+			if err := websocket.JSON.Send(ws, initial); err != nil || isTerminal(initial.Status) {
+				return
+			}
+
+			heartbeat := time.NewTicker(heartbeatInterval)
+			defer heartbeat.Stop()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case <-heartbeat.C:
+					if err := websocket.Message.Send(ws, `{"type":"ping"}`); err != nil {
+						return
+					}
+				case event := <-updates.events:
+					if err := websocket.JSON.Send(ws, event); err != nil || isTerminal(event.Status) {
+						return
+					}
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkOrigin accepts upgrades without an Origin, which are not sent by
+// browsers, and from the gateway's own origin or EVENTS_ALLOWED_ORIGINS
+func (h *Handler) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(h.cfg.EventsAllowedOrigins, "*") || slices.Contains(h.cfg.EventsAllowedOrigins, origin) {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return nil
+	}
+	return errors.New("origin not allowed")
+}
+
+// EventsURLResponse links to the status events of an image without a bearer token
+type EventsURLResponse struct {
+	EventsURL    string    `json:"events_url"`
+	WebSocketURL string    `json:"ws_url"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// CreateEventsURL issues short-lived signed URLs of an image's event
+// streams, for browsers whose EventSource and WebSocket cannot send an
+// Authorization header. The URLs must be opened before EVENTS_URL_TTL
+// passes; an open stream is not cut off when they expire.
+func (h *Handler) CreateEventsURL(c *gin.Context) {
+	if h.urlSigner == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Signed URLs are not configured"})
+		return
+	}
+
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if image, err := h.images.Get(ctx, imageID); err != nil || !canRead(c, image.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	expiresAt := time.Now().Add(h.cfg.EventsURLTTL).UTC().Truncate(time.Second)
+	base := strings.TrimSuffix(h.cfg.PublicBaseURL, "/")
+	sign := func(path string) string {
+		return path + "?" + h.urlSigner.Sign(path, url.Values{}, expiresAt).Encode()
+	}
+	wsBase := base
+	if rest, ok := strings.CutPrefix(base, "http"); ok {
+		wsBase = "ws" + rest
+	}
+
+	c.JSON(http.StatusCreated, EventsURLResponse{
+		EventsURL:    base + sign(fmt.Sprintf("/public/images/%s/events", imageID)),
+		WebSocketURL: wsBase + sign(fmt.Sprintf("/public/images/%s/ws", imageID)),
+		ExpiresAt:    expiresAt,
+	})
+}
+
+type imageSubscription struct {
+	events      <-chan events.StatusEvent
+	unsubscribe func()
+}
+
+// subscribeImageEvents subscribes to an image's events and then reads its
+// current status, in that order so no transition can be missed in between.
+// It writes an error response and returns false if the image does not exist.
+func (h *Handler) subscribeImageEvents(c *gin.Context, imageID uuid.UUID) (imageSubscription, events.StatusEvent, bool) {
+	ch, unsubscribe := h.eventHub.Subscribe(imageID.String())
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	image, err := h.images.Get(ctx, imageID)
+	if err != nil || !canRead(c, image.Owner) {
+		unsubscribe()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return imageSubscription{}, events.StatusEvent{}, false
+	}
+
+	initial := events.StatusEvent{
+		ImageID:   image.ID.String(),
+		Status:    string(image.Status),
+		Timestamp: image.UpdatedAt,
+	}
+	return imageSubscription{events: ch, unsubscribe: unsubscribe}, initial, true
+}
+
+func isTerminal(status string) bool {
+	switch models.ImageStatus(status) {
+	case models.ImageStatusCompleted, models.ImageStatusFailed, models.ImageStatusExpired:
+		return true
+	}
+	return false
+}
@@ -1,25 +1,57 @@
 package handler
 
 import (
-	"image-processor/internal/queue/rabbitmq"
-	minioclient "image-processor/internal/storage/minio"
+	"image-processor/internal/config"
+	"image-processor/internal/dedup"
+	"image-processor/internal/events"
+	"image-processor/internal/logo"
+	"image-processor/internal/queue"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+	"image-processor/internal/transform"
+	"image-processor/internal/webhook"
 	redisclient "image-processor/pkg/database/redis"
-
-	"github.com/jackc/pgx/v5/pgxpool"
+	"image-processor/pkg/security"
 )
 
 type Handler struct {
-	pgPool       *pgxpool.Pool
-	minioClient  *minioclient.Client
-	rabbitClient *rabbitmq.Client
-	redisClient  *redisclient.Client
+	cfg         *config.Config
+	images      repository.ImageRepository
+	uploads     repository.UploadRepository
+	webhooks    repository.WebhookRepository
+	store       storage.ObjectStore
+	layout      *storage.Layout
+	pipeline    transform.Options // applied by the worker; its key matches duplicates
+	logos       *logo.Loader
+	queue       queue.Publisher
+	redisClient *redisclient.Client
+	urlSigner   *security.URLSigner // nil when signed URLs are disabled
+	imageCache  *redisclient.Cache
+	eventHub    *events.Hub
+	notifier    *webhook.Notifier
+	dedup       *dedup.Deduplicator
+
+	// renderSlots bounds the number of concurrent on-the-fly renders
+	renderSlots chan struct{}
 }
 
-func NewHandler(pg *pgxpool.Pool, minio *minioclient.Client, rabbit *rabbitmq.Client, redis *redisclient.Client) *Handler {
+func NewHandler(cfg *config.Config, images repository.ImageRepository, uploads repository.UploadRepository, webhooks repository.WebhookRepository, store storage.ObjectStore, layout *storage.Layout, pipeline transform.Options, publisher queue.Publisher, redis *redisclient.Client, signer *security.URLSigner, hub *events.Hub) *Handler {
 	return &Handler{
-		pgPool:       pg,
-		minioClient:  minio,
-		rabbitClient: rabbit,
-		redisClient:  redis,
+		cfg:         cfg,
+		images:      images,
+		uploads:     uploads,
+		webhooks:    webhooks,
+		store:       store,
+		layout:      layout,
+		pipeline:    pipeline,
+		logos:       logo.NewLoader(images, store, cfg.MaxImagePixels),
+		queue:       publisher,
+		redisClient: redis,
+		urlSigner:   signer,
+		imageCache:  redisclient.NewCache(redis, cfg.ImageCacheNegativeTTL),
+		eventHub:    hub,
+		notifier:    webhook.NewNotifier(images, webhooks, signer, cfg.PublicBaseURL, cfg.SignedURLMaxTTL),
+		dedup:       dedup.NewDeduplicator(images, store),
+		renderSlots: make(chan struct{}, max(cfg.RenderConcurrency, 1)),
 	}
 }
@@ -0,0 +1,884 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image-processor/internal/config"
+	"image-processor/internal/events"
+	"image-processor/internal/models"
+	"image-processor/internal/queue"
+	"image-processor/internal/repository"
+	"image-processor/internal/repository/memory"
+	"image-processor/internal/storage"
+	memstore "image-processor/internal/storage/memory"
+	"image-processor/internal/transform"
+	redisclient "image-processor/pkg/database/redis"
+	"image-processor/pkg/database/redis/redistest"
+	"image-processor/pkg/security"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+// fakeQueue records published task messages
+type fakeQueue struct {
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+func (q *fakeQueue) Publish(body []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, body)
+	return nil
+}
+
+func (q *fakeQueue) tasks(t *testing.T) []queue.TaskMessage {
+	t.Helper()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tasks := make([]queue.TaskMessage, len(q.messages))
+	for i, body := range q.messages {
+		if err := json.Unmarshal(body, &tasks[i]); err != nil {
+			t.Fatalf("invalid task message %s: %v", body, err)
+		}
+	}
+	return tasks
+}
+
+type testEnv struct {
+	handler  *Handler
+	router   *gin.Engine
+	images   *memory.ImageRepository
+	uploads  *memory.UploadRepository
+	webhooks *memory.WebhookRepository
+	store    *memstore.Store
+	queue    *fakeQueue
+}
+
+// presignStore hands out upload links, which the memory store does not;
+// tests write the object themselves instead of following the link
+type presignStore struct {
+	*memstore.Store
+}
+
+func (s presignStore) GetUploadLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error) {
+	return "http://storage.test/" + bucketName + "/" + objectName, nil
+}
+
+// testAuth stands in for security.AuthMiddleware: the user is taken from
+// the X-Test-User header and requests without one are rejected. Realm roles
+// are taken from the comma-separated X-Test-Roles header.
+func testAuth(c *gin.Context) {
+	user := c.GetHeader("X-Test-User")
+	if user == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		return
+	}
+	claims := &security.KeycloakClaims{}
+	if roles := c.GetHeader("X-Test-Roles"); roles != "" {
+		claims.RealmAccess.Roles = strings.Split(roles, ",")
+	}
+	c.Set("user", user)
+	c.Set("claims", claims)
+	c.Next()
+}
+
+// newTestEnv creates a handler backed by in-memory fakes. configure may
+// adjust the default configuration first.
+func newTestEnv(t *testing.T, configure ...func(*config.Config)) *testEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	layout, err := storage.NewLayout(cfg)
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+	pipeline, err := transform.NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("failed to create pipeline: %v", err)
+	}
+	redis, _ := redistest.NewClient(t)
+	signer, err := security.NewURLSigner([]string{"test:" + strings.Repeat("k", 32)})
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	env := &testEnv{
+		images:   memory.NewImageRepository(),
+		webhooks: memory.NewWebhookRepository(),
+		store:    memstore.NewStore(layout.Buckets()),
+		queue:    &fakeQueue{},
+	}
+	env.uploads = memory.NewUploadRepository(env.images)
+	env.handler = NewHandler(cfg, env.images, env.uploads, env.webhooks,
+		presignStore{env.store}, layout, pipeline, env.queue, redis, signer, events.NewHub())
+
+	env.router = gin.New()
+	env.handler.Register(env.router, testAuth)
+	return env
+}
+
+func (e *testEnv) do(t *testing.T, method, target, user string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	rec := httptest.NewRecorder()
+	e.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// upload sends a PNG as user and returns the ID of the new image
+func (e *testEnv) upload(t *testing.T, user string) uuid.UUID {
+	t.Helper()
+	rec := e.do(t, http.MethodPut, "/api/v1/images?filename=photo.png", user, testPNG(t))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	var response UploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid upload response: %v", err)
+	}
+	return uuid.MustParse(response.ID)
+}
+
+func TestUploadRecordsOwnerAndQueuesTask(t *testing.T) {
+	env := newTestEnv(t)
+	id := env.upload(t, "alice")
+
+	image, err := env.images.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("image was not recorded: %v", err)
+	}
+	if image.Owner != "alice" || image.Status != models.ImageStatusPending {
+		t.Errorf("got owner %q and status %s, want alice and pending", image.Owner, image.Status)
+	}
+	if _, err := env.store.StatFile(context.Background(), image.BucketName, image.OriginalKey); err != nil {
+		t.Errorf("original was not stored: %v", err)
+	}
+
+	tasks := env.queue.tasks(t)
+	if len(tasks) != 1 || tasks[0].ImageID != id.String() || tasks[0].ObjectName != image.OriginalKey {
+		t.Errorf("got tasks %+v, want one for %s", tasks, id)
+	}
+}
+
+func TestUploadCallbacksHaveTheirOwnSecret(t *testing.T) {
+	env := newTestEnv(t)
+	var secrets []string
+	for range 2 {
+		rec := env.do(t, http.MethodPut, "/api/v1/images?filename=photo.png&callback_url=https://example.com/hook", "alice", testPNG(t))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+		}
+		var response UploadResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("invalid upload response: %v", err)
+		}
+		image, err := env.images.Get(context.Background(), uuid.MustParse(response.ID))
+		if err != nil {
+			t.Fatalf("image was not recorded: %v", err)
+		}
+		if response.CallbackSecret == "" || image.CallbackSecret != response.CallbackSecret {
+			t.Fatalf("got secret %q in the response and %q on the image, want the same one", response.CallbackSecret, image.CallbackSecret)
+		}
+		secrets = append(secrets, response.CallbackSecret)
+	}
+	if secrets[0] == secrets[1] {
+		t.Error("two uploads share a callback secret")
+	}
+
+	var response UploadResponse
+	rec := env.do(t, http.MethodPut, "/api/v1/images?filename=photo.png", "alice", testPNG(t))
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.CallbackSecret != "" {
+		t.Errorf("upload without a callback returned secret %q (%v)", response.CallbackSecret, err)
+	}
+}
+
+func TestUploadRequiresUser(t *testing.T) {
+	env := newTestEnv(t)
+	rec := env.do(t, http.MethodPut, "/api/v1/images?filename=photo.png", "", testPNG(t))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got %d, want 401", rec.Code)
+	}
+	if tasks := env.queue.tasks(t); len(tasks) != 0 {
+		t.Errorf("got %d tasks, want none", len(tasks))
+	}
+}
+
+func TestUploadNotifiesAccountWebhooks(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	subscription := models.WebhookSubscription{ID: uuid.New(), Owner: "alice", URL: "https://example.com/hook"}
+	if err := env.webhooks.CreateSubscription(ctx, &subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+
+	// A processed copy of the upload completes it in the gateway, which
+	// notifies the uploader's webhooks right away
+	data := testPNG(t)
+	checksum := sha256.Sum256(data)
+	now := time.Now().UTC()
+	source := &models.Image{
+		ID:              uuid.New(),
+		Filename:        "source.png",
+		Status:          models.ImageStatusCompleted,
+		BucketName:      env.handler.layout.RawBucket,
+		OriginalKey:     "source.png",
+		ProcessedBucket: env.handler.layout.ProcessedBucket,
+		ProcessedKey:    "source.png",
+		Checksum:        hex.EncodeToString(checksum[:]),
+		Pipeline:        env.handler.pipeline.Key(),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if err := env.images.Create(ctx, source); err != nil {
+		t.Fatalf("failed to create source image: %v", err)
+	}
+
+	id := env.upload(t, "alice")
+
+	deliveries, err := env.webhooks.ListDeliveries(ctx, repository.DeliveryListOptions{Owner: "alice", ImageID: id})
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].SubscriptionID == nil || *deliveries[0].SubscriptionID != subscription.ID {
+		t.Errorf("got deliveries %+v, want one for subscription %s", deliveries, subscription.ID)
+	}
+}
+
+func TestListImagesIsScopedToOwner(t *testing.T) {
+	env := newTestEnv(t)
+	id := env.upload(t, "alice")
+	env.upload(t, "bob")
+
+	rec := env.do(t, http.MethodGet, "/api/v1/images", "alice", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list returned %d: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Images []ImageResponse `json:"images"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid list response: %v", err)
+	}
+	if len(response.Images) != 1 || response.Images[0].ID != id.String() {
+		t.Errorf("got %+v, want only %s", response.Images, id)
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	env := newTestEnv(t)
+	id := env.upload(t, "alice")
+
+	rec := env.do(t, http.MethodGet, "/api/v1/images/"+id.String(), "alice", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get returned %d: %s", rec.Code, rec.Body)
+	}
+	var response ImageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid image response: %v", err)
+	}
+	if response.ID != id.String() || response.Status != string(models.ImageStatusPending) {
+		t.Errorf("got %+v, want pending image %s", response, id)
+	}
+
+	rec = env.do(t, http.MethodGet, "/api/v1/images/"+uuid.NewString(), "alice", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got %d for a missing image, want 404", rec.Code)
+	}
+}
+
+func TestListWebhooksIsScopedToOwner(t *testing.T) {
+	env := newTestEnv(t)
+	for _, owner := range []string{"alice", "bob"} {
+		subscription := models.WebhookSubscription{ID: uuid.New(), Owner: owner, URL: "https://example.com/" + owner}
+		if err := env.webhooks.CreateSubscription(context.Background(), &subscription); err != nil {
+			t.Fatalf("failed to create subscription: %v", err)
+		}
+	}
+
+	rec := env.do(t, http.MethodGet, "/api/v1/webhooks", "alice", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list returned %d: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Webhooks []models.WebhookSubscription `json:"webhooks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid list response: %v", err)
+	}
+	if len(response.Webhooks) != 1 || response.Webhooks[0].Owner != "alice" {
+		t.Errorf("got %+v, want only alice's subscription", response.Webhooks)
+	}
+}
+
+// completed records a processed image of owner along with its objects
+func (e *testEnv) completed(t *testing.T, owner string) *models.Image {
+	t.Helper()
+	ctx := context.Background()
+	data := testPNG(t)
+	now := time.Now().UTC()
+	image := &models.Image{
+		ID:              uuid.New(),
+		Filename:        "photo.png",
+		Status:          models.ImageStatusCompleted,
+		BucketName:      e.handler.layout.RawBucket,
+		ProcessedBucket: e.handler.layout.ProcessedBucket,
+		Owner:           owner,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	image.OriginalKey = image.ID.String() + ".png"
+	image.ProcessedKey = image.ID.String() + ".png"
+	for _, bucket := range []string{image.BucketName, image.ProcessedBucket} {
+		_, err := e.store.UploadFile(ctx, bucket, image.ID.String()+".png", bytes.NewReader(data), int64(len(data)), storage.PutOptions{ContentType: "image/png"})
+		if err != nil {
+			t.Fatalf("failed to store object: %v", err)
+		}
+	}
+	if err := e.images.Create(ctx, image); err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	return image
+}
+
+func TestImagesAreOnlyReadableByOwner(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.completed(t, "alice")
+	base := "/api/v1/images/" + image.ID.String()
+
+	for _, path := range []string{base, base + "/content", base + "/render?w=8"} {
+		if rec := env.do(t, http.MethodGet, path, "alice", nil); rec.Code != http.StatusOK {
+			t.Errorf("GET %s by the owner returned %d: %s", path, rec.Code, rec.Body)
+		}
+		if rec := env.do(t, http.MethodGet, path, "bob", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s by another user returned %d, want 404", path, rec.Code)
+		}
+		if rec := env.do(t, http.MethodGet, path, "", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous GET %s returned %d, want 401", path, rec.Code)
+		}
+	}
+	if rec := env.do(t, http.MethodPost, base+"/signed-url", "bob", []byte("{}")); rec.Code != http.StatusNotFound {
+		t.Errorf("signing by another user returned %d, want 404", rec.Code)
+	}
+}
+
+func TestSignedURLGrantsAnonymousAccess(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.completed(t, "alice")
+
+	rec := env.do(t, http.MethodPost, "/api/v1/images/"+image.ID.String()+"/signed-url", "alice", []byte(`{"render": {"w": 8}}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signing returned %d: %s", rec.Code, rec.Body)
+	}
+	var response SignedURLResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid signed URL response: %v", err)
+	}
+
+	if rec := env.do(t, http.MethodGet, response.URL, "", nil); rec.Code != http.StatusOK {
+		t.Errorf("anonymous GET of the signed URL returned %d: %s", rec.Code, rec.Body)
+	}
+	tampered := strings.Replace(response.URL, "w=8", "w=9", 1)
+	if rec := env.do(t, http.MethodGet, tampered, "", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("anonymous GET of a tampered URL returned %d, want 403", rec.Code)
+	}
+}
+
+func TestEventsURLOpensStreamWithoutBearer(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.completed(t, "alice")
+	base := "/api/v1/images/" + image.ID.String()
+
+	if rec := env.do(t, http.MethodPost, base+"/events-url", "bob", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("events URL for another user returned %d, want 404", rec.Code)
+	}
+	rec := env.do(t, http.MethodPost, base+"/events-url", "alice", nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("events URL returned %d: %s", rec.Code, rec.Body)
+	}
+	var response EventsURLResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid events URL response: %v", err)
+	}
+
+	rec = env.do(t, http.MethodGet, response.EventsURL, "", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"completed"`) {
+		t.Errorf("anonymous GET of the events URL returned %d: %s", rec.Code, rec.Body)
+	}
+	// The signature covers the image, so it does not open another image's stream
+	other := env.completed(t, "bob")
+	tampered := strings.Replace(response.EventsURL, image.ID.String(), other.ID.String(), 1)
+	if rec := env.do(t, http.MethodGet, tampered, "", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("events URL of another image returned %d, want 403", rec.Code)
+	}
+}
+
+func TestWebSocketChecksOrigin(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.EventsAllowedOrigins = []string{"https://app.example"}
+	})
+	image := env.completed(t, "alice")
+	server := httptest.NewServer(env.router)
+	defer server.Close()
+
+	dial := func(origin string) (*websocket.Conn, error) {
+		cfg, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/images/"+image.ID.String()+"/ws", origin)
+		if err != nil {
+			t.Fatalf("invalid websocket config: %v", err)
+		}
+		cfg.Header.Set("X-Test-User", "alice")
+		return websocket.DialConfig(cfg)
+	}
+
+	if ws, err := dial("https://evil.example"); err == nil {
+		ws.Close()
+		t.Errorf("upgrade from a foreign origin was accepted")
+	}
+	for _, origin := range []string{"https://app.example", server.URL} {
+		ws, err := dial(origin)
+		if err != nil {
+			t.Errorf("upgrade from %s failed: %v", origin, err)
+			continue
+		}
+		var event events.StatusEvent
+		if err := websocket.JSON.Receive(ws, &event); err != nil || event.Status != string(models.ImageStatusCompleted) {
+			t.Errorf("got event %+v (%v), want the completed status", event, err)
+		}
+		ws.Close()
+	}
+}
+
+func TestOnlySignedContentIsPubliclyCacheable(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.completed(t, "alice")
+	base := "/api/v1/images/" + image.ID.String()
+
+	// The render is requested twice to cover both a fresh and a cached render
+	for _, path := range []string{base + "/content", base + "/render?w=8", base + "/render?w=8"} {
+		rec := env.do(t, http.MethodGet, path, "alice", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s returned %d: %s", path, rec.Code, rec.Body)
+		}
+		if cacheControl := rec.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "private,") {
+			t.Errorf("GET %s sent Cache-Control %q, want private", path, cacheControl)
+		}
+	}
+
+	renderPath := "/public/images/" + image.ID.String() + "/render"
+	signed := env.handler.urlSigner.Sign(renderPath, url.Values{"w": {"8"}}, time.Now().Add(time.Hour))
+	rec := env.do(t, http.MethodGet, renderPath+"?"+signed.Encode(), "", nil)
+	if cacheControl := rec.Header().Get("Cache-Control"); rec.Code != http.StatusOK || !strings.HasPrefix(cacheControl, "public,") {
+		t.Errorf("signed render returned %d with Cache-Control %q, want public", rec.Code, cacheControl)
+	}
+}
+
+func TestSimilarImagesAreScopedToOwner(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	latitude := 52.5
+	hashes := models.PerceptualHashes{AHash: 1, DHash: 1, PHash: 1}
+	var images []*models.Image
+	for _, owner := range []string{"alice", "alice", "bob"} {
+		image := env.completed(t, owner)
+		image.Format = "png"
+		image.CameraModel = "camera of " + owner
+		image.Latitude = &latitude
+		if err := env.images.SetMetadata(ctx, image.ID, image.ImageMetadata); err != nil {
+			t.Fatalf("failed to set metadata: %v", err)
+		}
+		if err := env.images.SetHashes(ctx, image.ID, hashes); err != nil {
+			t.Fatalf("failed to set hashes: %v", err)
+		}
+		images = append(images, image)
+	}
+	path := "/api/v1/images/" + images[0].ID.String() + "/similar"
+
+	search := func(user, roles string) (int, []SimilarImageResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Test-User", user)
+		req.Header.Set("X-Test-Roles", roles)
+		rec := httptest.NewRecorder()
+		env.router.ServeHTTP(rec, req)
+		var response struct {
+			Images []SimilarImageResponse `json:"images"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid similar response: %v", err)
+			}
+		}
+		return rec.Code, response.Images
+	}
+
+	if code, _ := search("bob", env.handler.cfg.ModerationRole); code != http.StatusNotFound {
+		t.Errorf("search from another owner's image returned %d, want 404", code)
+	}
+
+	code, found := search("alice", "")
+	if code != http.StatusOK || len(found) != 1 || found[0].ID != images[1].ID.String() {
+		t.Fatalf("search by the owner returned %d with %+v, want only %s", code, found, images[1].ID)
+	}
+	if found[0].Metadata == nil || found[0].Metadata.Latitude == nil || found[0].ContentURL == "" {
+		t.Errorf("own result lost its metadata or content URL: %+v", found[0])
+	}
+
+	code, found = search("alice", env.handler.cfg.ModerationRole)
+	if code != http.StatusOK || len(found) != 2 {
+		t.Fatalf("search by a moderator returned %d with %d images, want 2", code, len(found))
+	}
+	for _, result := range found {
+		if result.Owner != "bob" {
+			continue
+		}
+		if result.Metadata == nil || result.Metadata.Format != "png" {
+			t.Errorf("other owner's result lost its format: %+v", result.Metadata)
+		} else if result.Metadata.Latitude != nil || result.Metadata.CameraModel != "" {
+			t.Errorf("other owner's result exposes GPS or camera: %+v", result.Metadata)
+		}
+		if result.ContentURL != "" {
+			t.Errorf("other owner's result has content URL %q", result.ContentURL)
+		}
+	}
+}
+
+func TestDeleteImageRequiresOwner(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.completed(t, "alice")
+	path := "/api/v1/images/" + image.ID.String()
+
+	if rec := env.do(t, http.MethodDelete, path, "bob", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("delete by another user returned %d, want 404", rec.Code)
+	}
+	if _, err := env.images.Get(context.Background(), image.ID); err != nil {
+		t.Fatalf("image was deleted by another user: %v", err)
+	}
+	if rec := env.do(t, http.MethodDelete, path, "alice", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("delete by the owner returned %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := env.images.Get(context.Background(), image.ID); err == nil {
+		t.Error("image is still visible after the owner deleted it")
+	}
+}
+
+func TestRenderOnlyUsesLogosOfTheSameOwner(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.completed(t, "alice")
+	path := "/api/v1/images/" + image.ID.String() + "/render?w=8&overlay_logo="
+
+	for _, tt := range []struct {
+		owner string
+		want  int
+	}{
+		{"alice", http.StatusOK},
+		{"bob", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	} {
+		logo := env.completed(t, tt.owner)
+		if rec := env.do(t, http.MethodGet, path+logo.ID.String(), "alice", nil); rec.Code != tt.want {
+			t.Errorf("render with a logo of %q returned %d, want %d: %s", tt.owner, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	// Images recorded before uploads were authenticated have no owner and
+	// must not be able to use each other as logos
+	ownerless, logo := env.completed(t, ""), env.completed(t, "")
+	renderPath := "/public/images/" + ownerless.ID.String() + "/render"
+	params := url.Values{"w": {"8"}, "overlay_logo": {logo.ID.String()}}
+	signed := env.handler.urlSigner.Sign(renderPath, params, time.Now().Add(time.Hour))
+	if rec := env.do(t, http.MethodGet, renderPath+"?"+signed.Encode(), "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("render of an ownerless image with an ownerless logo returned %d, want 400", rec.Code)
+	}
+}
+
+func TestRenderLimitsSize(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.MaxImagePixels = 16*16 - 1
+	})
+	image := env.completed(t, "alice")
+	path := "/api/v1/images/" + image.ID.String() + "/render"
+
+	for _, query := range []string{"", "?w=0&h=0", "?format=png"} {
+		if rec := env.do(t, http.MethodGet, path+query, "alice", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("render%s returned %d, want 400", query, rec.Code)
+		}
+	}
+	if rec := env.do(t, http.MethodGet, path+"?w=8", "alice", nil); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("render of an original over the pixel limit returned %d, want 422: %s", rec.Code, rec.Body)
+	}
+
+	env.handler.cfg.MaxImagePixels = 16 * 16
+	if rec := env.do(t, http.MethodGet, path+"?w=8", "alice", nil); rec.Code != http.StatusOK {
+		t.Errorf("render of an original within the pixel limit returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestLayoutUsesOwnerAsTenant(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.OriginalKeyTemplate = "{tenant}/{id}.{ext}"
+		cfg.RenderKeyTemplate = "renders/{tenant}/{id}/{variant}.{ext}"
+	})
+	ctx := context.Background()
+
+	uploaded, err := env.images.Get(ctx, env.upload(t, "alice"))
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if want := "alice/" + uploaded.ID.String() + ".png"; uploaded.OriginalKey != want {
+		t.Errorf("got original key %q, want %q", uploaded.OriginalKey, want)
+	}
+
+	image := env.completed(t, "alice")
+	if rec := env.do(t, http.MethodGet, "/api/v1/images/"+image.ID.String()+"/render?w=8", "alice", nil); rec.Code != http.StatusOK {
+		t.Fatalf("render returned %d: %s", rec.Code, rec.Body)
+	}
+	prefix := "renders/alice/" + image.ID.String() + "/"
+	renders, err := env.store.ListFiles(ctx, image.ProcessedBucket, prefix)
+	if err != nil {
+		t.Fatalf("failed to list renders: %v", err)
+	}
+	if len(renders) != 1 {
+		t.Errorf("got %d renders under %s, want 1", len(renders), prefix)
+	}
+}
+
+// presign starts a presigned upload as user and stores data where the
+// client would PUT it
+func (e *testEnv) presign(t *testing.T, user string, data []byte) *models.Image {
+	t.Helper()
+	rec := e.do(t, http.MethodPost, "/api/v1/uploads/presign", user, []byte(`{"filename": "photo.png"}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("presign returned %d: %s", rec.Code, rec.Body)
+	}
+	var response PresignResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid presign response: %v", err)
+	}
+	image, err := e.images.Get(context.Background(), uuid.MustParse(response.ID))
+	if err != nil {
+		t.Fatalf("image was not recorded: %v", err)
+	}
+	_, err = e.store.UploadFile(context.Background(), image.BucketName, image.OriginalKey, bytes.NewReader(data), int64(len(data)), storage.PutOptions{})
+	if err != nil {
+		t.Fatalf("failed to store upload: %v", err)
+	}
+	return image
+}
+
+func TestPresignedUploadCompletes(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.presign(t, "alice", testPNG(t))
+	if _, err := env.uploads.Get(context.Background(), image.ID); err != nil {
+		t.Fatalf("presign did not record an upload session: %v", err)
+	}
+
+	rec := env.do(t, http.MethodPost, "/api/v1/uploads/"+image.ID.String()+"/complete", "alice", nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("complete returned %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := env.uploads.Get(context.Background(), image.ID); err == nil {
+		t.Error("the upload session outlived the upload")
+	}
+	if tasks := env.queue.tasks(t); len(tasks) != 1 || tasks[0].ImageID != image.ID.String() {
+		t.Errorf("got tasks %+v, want one for %s", tasks, image.ID)
+	}
+}
+
+func TestExpireUploadSessionsDiscardsPresignedUploads(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	image := env.presign(t, "alice", testPNG(t))
+
+	session, err := env.uploads.Get(ctx, image.ID)
+	if err != nil {
+		t.Fatalf("presign did not record an upload session: %v", err)
+	}
+	session.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := env.uploads.SaveProgress(ctx, session); err != nil {
+		t.Fatalf("failed to expire session: %v", err)
+	}
+
+	n, err := env.handler.ExpireUploadSessions(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("ExpireUploadSessions returned %d, %v; want 1", n, err)
+	}
+	expired, err := env.images.Get(ctx, image.ID)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if expired.Status != models.ImageStatusFailed {
+		t.Errorf("got status %s, want failed", expired.Status)
+	}
+	if _, err := env.store.StatFile(ctx, image.BucketName, image.OriginalKey); err == nil {
+		t.Error("the uploaded object was not deleted")
+	}
+	if rec := env.do(t, http.MethodPost, "/api/v1/uploads/"+image.ID.String()+"/complete", "alice", nil); rec.Code != http.StatusConflict {
+		t.Errorf("completing an expired upload returned %d, want 409", rec.Code)
+	}
+}
+
+func TestUploadsOnlyAcceptTheirOwner(t *testing.T) {
+	env := newTestEnv(t)
+	image := env.presign(t, "alice", testPNG(t))
+	path := "/api/v1/uploads/" + image.ID.String()
+
+	if rec := env.do(t, http.MethodPost, path+"/complete", "bob", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("complete by another user returned %d, want 404", rec.Code)
+	}
+	if rec := env.do(t, http.MethodDelete, path, "bob", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("cancel by another user returned %d, want 404", rec.Code)
+	}
+	if rec := env.do(t, http.MethodPost, path+"/complete", "alice", nil); rec.Code != http.StatusAccepted {
+		t.Errorf("complete by the owner returned %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func (e *testEnv) patch(t *testing.T, id uuid.UUID, user string, offset int64, chunk []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/uploads/"+id.String(), bytes.NewReader(chunk))
+	req.Header.Set("X-Test-User", user)
+	req.Header.Set("Content-Type", chunkContentType)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	rec := httptest.NewRecorder()
+	e.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// slowPartStore lets the chunk lock expire and another request take it
+// while a part is being stored
+type slowPartStore struct {
+	presignStore
+	redis *redisclient.Client
+}
+
+func (s slowPartStore) UploadPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	lockKey := "upload:" + strings.TrimSuffix(path.Base(objectName), path.Ext(objectName)) + ":lock"
+	if err := s.redis.Set(ctx, lockKey, "next-request", time.Minute); err != nil {
+		return "", err
+	}
+	return s.presignStore.UploadPart(ctx, bucketName, objectName, uploadID, partNumber, reader, size)
+}
+
+func TestChunkLockIsOnlyReleasedByItsHolder(t *testing.T) {
+	env := newTestEnv(t)
+	env.handler.store = slowPartStore{presignStore{env.store}, env.handler.redisClient}
+	data := testPNG(t)
+
+	body, _ := json.Marshal(CreateUploadRequest{Filename: "photo.png", Size: int64(len(data))})
+	rec := env.do(t, http.MethodPost, "/api/v1/uploads", "alice", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", rec.Code, rec.Body)
+	}
+	var created ResumableUploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("invalid create response: %v", err)
+	}
+
+	if rec := env.patch(t, uuid.MustParse(created.ID), "alice", 0, data); rec.Code != http.StatusOK {
+		t.Fatalf("chunk returned %d: %s", rec.Code, rec.Body)
+	}
+	holder, err := env.handler.redisClient.Get(context.Background(), "upload:"+created.ID+":lock")
+	if err != nil || holder != "next-request" {
+		t.Errorf("got lock holder %q (%v), want the lock of the next request kept", holder, err)
+	}
+}
+
+func TestFinalizeUploadCanBeRetried(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	data := testPNG(t)
+
+	body, _ := json.Marshal(CreateUploadRequest{Filename: "photo.png", Size: int64(len(data))})
+	rec := env.do(t, http.MethodPost, "/api/v1/uploads", "alice", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", rec.Code, rec.Body)
+	}
+	var created ResumableUploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("invalid create response: %v", err)
+	}
+	id := uuid.MustParse(created.ID)
+
+	// An earlier attempt assembled the object but failed before the
+	// session was finished
+	session, err := env.uploads.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	etag, err := env.store.UploadPart(ctx, session.BucketName, session.ObjectName, session.UploadID, 1, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to upload part: %v", err)
+	}
+	if err := env.store.CompleteMultipartUpload(ctx, session.BucketName, session.ObjectName, session.UploadID, []string{etag}); err != nil {
+		t.Fatalf("failed to complete upload: %v", err)
+	}
+	digest := sha256.New()
+	digest.Write(data)
+	session.HashState, err = digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to save checksum: %v", err)
+	}
+	session.Offset, session.PartETags = int64(len(data)), []string{etag}
+	if err := env.uploads.SaveProgress(ctx, session); err != nil {
+		t.Fatalf("failed to save progress: %v", err)
+	}
+
+	if rec := env.patch(t, id, "alice", int64(len(data)), nil); rec.Code != http.StatusOK {
+		t.Fatalf("retrying the final chunk returned %d: %s", rec.Code, rec.Body)
+	}
+	image, err := env.images.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if image.Status != models.ImageStatusPending || image.Checksum != hex.EncodeToString(digest.Sum(nil)) {
+		t.Errorf("got status %s and checksum %q, want pending with the checksum of the upload", image.Status, image.Checksum)
+	}
+	if tasks := env.queue.tasks(t); len(tasks) != 1 {
+		t.Errorf("got %d tasks, want 1", len(tasks))
+	}
+}
@@ -3,11 +3,18 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"image-processor/internal/events"
 	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+	redisclient "image-processor/pkg/database/redis"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -19,8 +26,51 @@ type ImageResponse struct {
 	Status      string    `json:"status"`
 	BucketName  string    `json:"bucket_name"`
 	DownloadURL string    `json:"download_url,omitempty"`
+	ContentURL  string    `json:"content_url,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Checksum    string    `json:"checksum,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// Metadata is set once the worker has read the original
+	Metadata *models.ImageMetadata `json:"metadata,omitempty"`
+	// BlurHash and Palette are set once the worker has decoded the original
+	BlurHash string   `json:"blurhash,omitempty"`
+	Palette  []string `json:"palette,omitempty"`
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 100
+)
+
+func newImageResponse(image *models.Image) ImageResponse {
+	response := ImageResponse{
+		ID:         image.ID.String(),
+		Filename:   image.Filename,
+		Status:     string(image.Status),
+		BucketName: image.BucketName,
+		Error:      image.Error,
+		Checksum:   image.Checksum,
+		CreatedAt:  image.CreatedAt,
+		UpdatedAt:  image.UpdatedAt,
+		BlurHash:   image.BlurHash,
+		Palette:    image.Palette,
+	}
+	if image.Format != "" {
+		metadata := image.ImageMetadata
+		response.Metadata = &metadata
+	}
+	return response
+}
+
+// cachedImage is the cached form of an image: the response plus its owner,
+// who alone may read it, and the location of the processed object, which
+// is needed to presign its download URL
+type cachedImage struct {
+	ImageResponse
+	Owner           string `json:"owner,omitempty"`
+	ProcessedBucket string `json:"processed_bucket,omitempty"`
+	ProcessedKey    string `json:"processed_key,omitempty"`
 }
 
 func (h *Handler) GetImage(c *gin.Context) {
@@ -36,66 +86,279 @@ func (h *Handler) GetImage(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
 
-	cacheKey := fmt.Sprintf("image:%s", imageID.String())
-
-	// Check Redis cache first
-	cachedData, err := h.redisClient.Get(ctx, cacheKey)
-	if err == nil {
-		// Cache hit
-		var response ImageResponse
-		if err := json.Unmarshal([]byte(cachedData), &response); err == nil {
-			// Regenerate presigned URL (they expire)
-			if response.Status == string(models.ImageStatusCompleted) {
-				downloadURL, _ := h.minioClient.GetFileLink(ctx, "processed-images", response.ID+".png", 15*time.Minute)
-				response.DownloadURL = downloadURL
-			}
-			c.JSON(http.StatusOK, response)
-			return
+	// Read through the Redis cache; presigned URLs expire, so they are never cached
+	data, err := h.imageCache.GetOrLoad(ctx, redisclient.ImageKey(imageID.String()), func(ctx context.Context) ([]byte, time.Duration, error) {
+		image, err := h.images.Get(ctx, imageID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, 0, redisclient.ErrNotFound
+		}
+		if err != nil {
+			return nil, 0, err
 		}
-	}
 
-	// Cache miss - query PostgreSQL
-	var image models.Image
-	query := `
-		SELECT id, filename, status, bucket_name, created_at, updated_at
-		FROM images
-		WHERE id = $1
-	`
-	err = h.pgPool.QueryRow(ctx, query, imageID).Scan(
-		&image.ID,
-		&image.Filename,
-		&image.Status,
-		&image.BucketName,
-		&image.CreatedAt,
-		&image.UpdatedAt,
-	)
-	if err != nil {
+		data, err := json.Marshal(cachedImage{
+			ImageResponse:   newImageResponse(image),
+			Owner:           image.Owner,
+			ProcessedBucket: image.ProcessedBucket,
+			ProcessedKey:    image.ProcessedKey,
+		})
+		return data, h.imageCacheTTL(image.Status), err
+	})
+	if errors.Is(err, redisclient.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load image: %v", err)})
+		return
+	}
 
-	response := ImageResponse{
-		ID:         image.ID.String(),
-		Filename:   image.Filename,
-		Status:     string(image.Status),
-		BucketName: image.BucketName,
-		CreatedAt:  image.CreatedAt,
-		UpdatedAt:  image.UpdatedAt,
+	var cached cachedImage
+	if err := json.Unmarshal(data, &cached); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode cached image"})
+		return
+	}
+	if !canRead(c, cached.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
 	}
 
+	response := cached.ImageResponse
+
 	// Generate presigned URL if image is completed
-	if image.Status == models.ImageStatusCompleted {
-		// Assume processed images are stored with .png extension
-		objectName := fmt.Sprintf("%s.png", image.ID.String())
-		downloadURL, err := h.minioClient.GetFileLink(ctx, "processed-images", objectName, 15*time.Minute)
+	if response.Status == string(models.ImageStatusCompleted) {
+		downloadURL, err := h.store.GetFileLink(ctx, cached.ProcessedBucket, cached.ProcessedKey, 15*time.Minute)
 		if err == nil {
 			response.DownloadURL = downloadURL
 		}
+		response.ContentURL = contentURL(response.ID)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListImages returns the authenticated user's images, newest first. Pages
+// continue from the next_before of the previous one.
+func (h *Handler) ListImages(c *gin.Context) {
+	owner := c.GetString("user")
+	if owner == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Listing images requires an authenticated user"})
+		return
+	}
+
+	opts := repository.ListOptions{Owner: owner, Limit: defaultListLimit}
+	if status := c.Query("status"); status != "" {
+		switch models.ImageStatus(status) {
+		case models.ImageStatusUploading, models.ImageStatusPending, models.ImageStatusProcessing,
+			models.ImageStatusCompleted, models.ImageStatusFailed, models.ImageStatusExpired:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown image status %q", status)})
+			return
+		}
+		opts.Status = models.ImageStatus(status)
+	}
+	if before := c.Query("before"); before != "" {
+		t, err := time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before must be an RFC 3339 timestamp"})
+			return
+		}
+		opts.Before = t
+	}
+	if !parseMetadataFilters(c, &opts) {
+		return
 	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxListLimit)})
+			return
+		}
+		opts.Limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
 
-	// Cache the result in Redis (TTL: 10 minutes)
-	responseBytes, _ := json.Marshal(response)
-	_ = h.redisClient.Set(ctx, cacheKey, string(responseBytes), 10*time.Minute)
+	images, err := h.images.List(ctx, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to list images: %v", err)})
+		return
+	}
 
+	responses := make([]ImageResponse, len(images))
+	for i := range images {
+		responses[i] = newImageResponse(&images[i])
+		if images[i].Status == models.ImageStatusCompleted {
+			responses[i].ContentURL = contentURL(responses[i].ID)
+		}
+	}
+	response := gin.H{"images": responses}
+	if len(images) == opts.Limit {
+		response["next_before"] = images[len(images)-1].CreatedAt
+	}
 	c.JSON(http.StatusOK, response)
 }
+
+// parseMetadataFilters reads the list filters on image metadata into opts.
+// It responds with 400 and returns false if one is invalid.
+func parseMetadataFilters(c *gin.Context, opts *repository.ListOptions) bool {
+	if format := c.Query("format"); format != "" {
+		switch format {
+		case "jpeg", "png", "tiff":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown format %q", format)})
+			return false
+		}
+		opts.Format = format
+	}
+	for param, dst := range map[string]*int{"min_width": &opts.MinWidth, "min_height": &opts.MinHeight} {
+		if value := c.Query(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a non-negative integer", param)})
+				return false
+			}
+			*dst = n
+		}
+	}
+	opts.CameraMake = c.Query("camera_make")
+	opts.CameraModel = c.Query("camera_model")
+	for param, dst := range map[string]*time.Time{"taken_after": &opts.TakenAfter, "taken_before": &opts.TakenBefore} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 timestamp", param)})
+				return false
+			}
+			*dst = t
+		}
+	}
+	if value := c.Query("has_gps"); value != "" {
+		hasGPS, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "has_gps must be true or false"})
+			return false
+		}
+		opts.HasGPS = &hasGPS
+	}
+	return true
+}
+
+// DeleteImage marks an image deleted. It disappears from the API at once;
+// the retention janitor removes its objects after RETENTION_DELETED_GRACE.
+func (h *Handler) DeleteImage(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	// Owners never change, so checking first leaves no gap to exploit
+	image, err := h.images.Get(ctx, imageID)
+	if err == nil && image.Owner != c.GetString("user") {
+		err = repository.ErrNotFound
+	}
+	if err == nil {
+		err = h.images.MarkDeleted(ctx, imageID)
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Image is still being uploaded or processed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete image: %v", err)})
+		return
+	}
+
+	if err := h.imageCache.Invalidate(ctx, redisclient.ImageKey(imageID.String())); err != nil {
+		log.Printf("Warning: failed to invalidate cache for image %s: %v", imageID, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// imageCacheTTL keeps terminal states cached for long and in-flight states
+// only briefly, since the latter are expected to change soon
+func (h *Handler) imageCacheTTL(status models.ImageStatus) time.Duration {
+	switch status {
+	case models.ImageStatusCompleted, models.ImageStatusFailed, models.ImageStatusExpired:
+		return h.cfg.ImageCacheTTL
+	default:
+		return h.cfg.ImageCacheInFlightTTL
+	}
+}
+
+// statusChanged drops the cached metadata of an image after its status
+// changes and notifies clients listening for its events
+func (h *Handler) statusChanged(ctx context.Context, imageID uuid.UUID, status models.ImageStatus) {
+	if err := h.imageCache.Invalidate(ctx, redisclient.ImageKey(imageID.String())); err != nil {
+		log.Printf("Warning: failed to invalidate cache for image %s: %v", imageID, err)
+	}
+	event := events.StatusEvent{ImageID: imageID.String(), Status: string(status)}
+	if err := events.PublishStatus(ctx, h.redisClient, event); err != nil {
+		log.Printf("Warning: failed to publish status event for image %s: %v", imageID, err)
+	}
+}
+
+// notifyWebhooks queues webhook deliveries for an image that reached a
+// terminal status in the gateway, e.g. a rejected or expired upload
+func (h *Handler) notifyWebhooks(ctx context.Context, imageID uuid.UUID) {
+	if _, err := h.notifier.Enqueue(ctx, imageID); err != nil {
+		log.Printf("Warning: failed to queue webhooks for image %s: %v", imageID, err)
+	}
+}
+
+// canRead reports whether the caller may read an image of owner: the owner
+// may, and so may anyone holding a signed URL for it. Other callers are
+// told the image does not exist.
+func canRead(c *gin.Context, owner string) bool {
+	if c.GetBool("signed") {
+		return true
+	}
+	user := c.GetString("user")
+	return user != "" && user == owner
+}
+
+// newImage prepares the record of an image uploaded by the caller and
+// resolves the key of its original. With an empty ext the key is left for
+// the worker to resolve once the type of the file is known.
+func (h *Handler) newImage(c *gin.Context, filename, ext string, status models.ImageStatus, cb callback) *models.Image {
+	now := time.Now().UTC()
+	image := &models.Image{
+		ID:             uuid.New(),
+		Filename:       filename,
+		Status:         status,
+		BucketName:     h.layout.RawBucket,
+		Owner:          c.GetString("user"),
+		CallbackURL:    cb.URL,
+		CallbackSecret: cb.Secret,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if ext != "" {
+		image.OriginalKey = h.layout.OriginalKey(storage.KeyParams{
+			ID:      image.ID,
+			Tenant:  image.Owner,
+			Created: image.CreatedAt,
+			Ext:     ext,
+		})
+	}
+	return image
+}
+
+// objectMetadata returns the metadata stored with the objects of an image
+func objectMetadata(image *models.Image) storage.Metadata {
+	return storage.Metadata{
+		ImageID:  image.ID.String(),
+		Owner:    image.Owner,
+		Filename: image.Filename,
+	}
+}
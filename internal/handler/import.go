@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/queue"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImportRequest struct {
+	SourceURL   string `json:"source_url" binding:"required"`
+	CallbackURL string `json:"callback_url"`
+}
+
+// ImportImage queues an image to be fetched from a remote URL. The gateway only
+// checks that the URL is well formed; the worker downloads it with size,
+// timeout, redirect and network restrictions applied.
+func (h *Handler) ImportImage(c *gin.Context) {
+	var req ImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source_url is required"})
+		return
+	}
+
+	sourceURL, err := parseRemoteURL(req.SourceURL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source_url " + err.Error()})
+		return
+	}
+
+	cb, ok := parseCallbackURL(c, req.CallbackURL)
+	if !ok {
+		return
+	}
+
+	filename := path.Base(sourceURL.Path)
+	if filename == "/" || filename == "." {
+		filename = sourceURL.Hostname()
+	}
+
+	// The worker resolves the key of the original once it knows the type
+	image := h.newImage(c, filename, "", models.ImageStatusPending, cb)
+	imageID, bucketName := image.ID, image.BucketName
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.images.Create(ctx, image); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save to database: %v", err)})
+		return
+	}
+
+	if err := queue.PublishTask(h.queue, queue.TaskMessage{
+		ImageID:    imageID.String(),
+		BucketName: bucketName,
+		SourceURL:  sourceURL.String(),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to publish message: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, UploadResponse{
+		ID:             imageID.String(),
+		Filename:       filename,
+		Status:         string(models.ImageStatusPending),
+		Message:        "Image queued for import and processing",
+		CallbackSecret: cb.Secret,
+	})
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"image-processor/internal/imageformat"
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type PresignRequest struct {
+	Filename    string `json:"filename" binding:"required"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type PresignResponse struct {
+	ID        string            `json:"id"`
+	UploadURL string            `json:"upload_url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// CallbackSecret signs the deliveries to the callback_url of the upload
+	CallbackSecret string `json:"callback_secret,omitempty"`
+}
+
+// PresignUpload creates an image record awaiting its original and returns a
+// presigned URL the client uses to PUT the file directly into Minio. The
+// client must call CompleteUpload once the PUT has succeeded; uploads not
+// completed within the session TTL after the URL expires are discarded.
+func (h *Handler) PresignUpload(c *gin.Context) {
+	var req PresignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename is required"})
+		return
+	}
+
+	filename := filepath.Base(req.Filename)
+	ext, contentType, ok := resolveUploadType(filename, req.ContentType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only .jpg, .jpeg, .png, .tif and .tiff extensions are allowed"})
+		return
+	}
+
+	if req.Size > h.cfg.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds maximum upload size of %d bytes", h.cfg.MaxUploadSize)})
+		return
+	}
+
+	cb, ok := parseCallbackURL(c, req.CallbackURL)
+	if !ok {
+		return
+	}
+
+	image := h.newImage(c, filename, ext, models.ImageStatusUploading, cb)
+	imageID, bucketName, objectName := image.ID, image.BucketName, image.OriginalKey
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	uploadURL, err := h.store.GetUploadLink(ctx, bucketName, objectName, h.cfg.PresignExpiry)
+	if errors.Is(err, storage.ErrNotSupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Direct uploads are not supported by the configured storage backend"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create upload URL: %v", err)})
+		return
+	}
+
+	urlExpiresAt := time.Now().Add(h.cfg.PresignExpiry).UTC()
+	err = h.uploads.Create(ctx, image, &models.UploadSession{
+		ImageID:     imageID,
+		BucketName:  bucketName,
+		ObjectName:  objectName,
+		ContentType: contentType,
+		TotalSize:   req.Size,
+		ExpiresAt:   urlExpiresAt.Add(h.cfg.UploadSessionTTL),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save to database: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, PresignResponse{
+		ID:             imageID.String(),
+		UploadURL:      uploadURL,
+		Method:         http.MethodPut,
+		Headers:        map[string]string{"Content-Type": contentType},
+		ExpiresAt:      urlExpiresAt,
+		CallbackSecret: cb.Secret,
+	})
+}
+
+// CompleteUpload verifies that a presigned upload landed in Minio with an
+// acceptable size and type, then queues the image for processing.
+func (h *Handler) CompleteUpload(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	image, err := h.images.Get(ctx, imageID)
+	if err != nil || image.Owner != c.GetString("user") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	filename, status, bucketName, objectName := image.Filename, image.Status, image.BucketName, image.OriginalKey
+	if status != models.ImageStatusUploading {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Upload is not awaiting completion (status: %s)", status)})
+		return
+	}
+
+	info, err := h.store.StatFile(ctx, bucketName, objectName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Object has not been uploaded yet"})
+		return
+	}
+
+	if info.Size <= 0 || info.Size > h.cfg.MaxUploadSize {
+		reason := fmt.Sprintf("Uploaded file size %d is outside the allowed range (1-%d bytes)", info.Size, h.cfg.MaxUploadSize)
+		h.rejectUpload(ctx, imageID, bucketName, objectName, reason)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": reason})
+		return
+	}
+
+	// Check the magic bytes rather than trusting the Content-Type sent with the PUT
+	obj, err := h.store.DownloadFile(ctx, bucketName, objectName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read uploaded file: %v", err)})
+		return
+	}
+	header := make([]byte, imageformat.SniffLen)
+	n, err := io.ReadFull(obj, header)
+	obj.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read uploaded file: %v", err)})
+		return
+	}
+	if imageformat.Detect(header[:n]) == "" {
+		reason := "Uploaded file is not a JPEG, PNG or TIFF image"
+		h.rejectUpload(ctx, imageID, bucketName, objectName, reason)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": reason})
+		return
+	}
+
+	// Transition only from uploading so concurrent completions enqueue once
+	_, err = h.uploads.Finish(ctx, imageID, repository.Transition{
+		From: []models.ImageStatus{models.ImageStatusUploading},
+		To:   models.ImageStatusPending,
+	})
+	if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload has already been completed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update image: %v", err)})
+		return
+	}
+	h.statusChanged(ctx, imageID, models.ImageStatusPending)
+
+	if err := h.enqueue(imageID, bucketName, objectName); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to publish message: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, UploadResponse{
+		ID:       imageID.String(),
+		Filename: filename,
+		Status:   string(models.ImageStatusPending),
+		Message:  "Upload verified and queued for processing",
+	})
+}
+
+// rejectUpload removes an invalid direct upload and its session and marks
+// its record as failed
+func (h *Handler) rejectUpload(ctx context.Context, imageID uuid.UUID, bucketName, objectName, reason string) {
+	err := h.discardUploadSession(ctx, &models.UploadSession{ImageID: imageID, BucketName: bucketName, ObjectName: objectName}, reason)
+	if err != nil {
+		log.Printf("Warning: failed to mark image %s as failed: %v", imageID, err)
+	}
+}
@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"image-processor/internal/imageformat"
+	"image-processor/internal/logo"
+	"image-processor/internal/models"
+	"image-processor/internal/storage"
+	"image-processor/internal/transform"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RenderImage transforms the original image on request, e.g.
+// /images/:id/render?w=400&h=300&fit=fill&format=jpeg&q=80&metadata=strip, and
+// may composite a watermark with the overlay_* parameters. Results are
+// cached in the processed bucket under a hash of the normalised parameters,
+// so each combination is only rendered once.
+func (h *Handler) RenderImage(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.UploadTimeout)
+	defer cancel()
+
+	image, err := h.images.Get(ctx, imageID)
+	if err != nil || !canRead(c, image.Owner) || image.Status == models.ImageStatusUploading || image.OriginalKey == "" && !originalExpired(image) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	h.touchImage(ctx, image)
+
+	opts, err := h.parseRenderOptions(c, image)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := opts.Key()
+	objectName := h.layout.RenderKey(storage.KeyParams{
+		ID:      image.ID,
+		Tenant:  image.Owner,
+		Created: image.CreatedAt,
+		Variant: key,
+		Ext:     opts.Extension(),
+	})
+	filename := downloadName(image.Filename, objectName)
+
+	// Serve a cached render if one exists
+	if object, info, err := h.store.OpenFile(ctx, h.layout.ProcessedBucket, objectName); err == nil {
+		defer object.Close()
+		h.setContentHeaders(c, key, info.ContentType, filename)
+		http.ServeContent(c.Writer, c.Request, objectName, info.LastModified, object)
+		return
+	}
+
+	// Renders cached before the original expired outlive it
+	if originalExpired(image) {
+		c.JSON(http.StatusGone, gin.H{"error": "Original has expired"})
+		return
+	}
+
+	// Bound the CPU and memory spent on renders
+	select {
+	case h.renderSlots <- struct{}{}:
+		defer func() { <-h.renderSlots }()
+	case <-ctx.Done():
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Render capacity exhausted, try again later"})
+		return
+	}
+
+	obj, _, err := h.store.OpenFile(ctx, image.BucketName, image.OriginalKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Original image not found"})
+		return
+	}
+	defer obj.Close()
+
+	// Metadata that cannot be read is left out rather than failing the render
+	embedded, err := transform.ReadMetadata(obj, opts)
+	if err != nil {
+		log.Printf("Warning: failed to read metadata of image %s: %v", imageID, err)
+	}
+	if _, err := obj.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read original image: %v", err)})
+		return
+	}
+
+	// Decoding allocates for every pixel, so huge originals are refused
+	// from their header alone
+	err = transform.CheckPixels(obj, h.cfg.MaxImagePixels)
+	if errors.Is(err, transform.ErrTooManyPixels) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Original image is too large to render"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to decode original image: %v", err)})
+		return
+	}
+	img, err := transform.Decode(obj)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to decode original image: %v", err)})
+		return
+	}
+
+	// Logos are other images of the same owner, so images without one
+	// cannot be used
+	if opts.Overlay != nil && opts.Overlay.LogoID != uuid.Nil {
+		loaded, err := h.logos.Load(ctx, opts.Overlay.LogoID)
+		if errors.Is(err, logo.ErrNotFound) || err == nil && (loaded.Owner == "" || loaded.Owner != image.Owner) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Overlay logo not found"})
+			return
+		}
+		if errors.Is(err, transform.ErrTooManyPixels) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Overlay logo is too large to render"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load overlay logo: %v", err)})
+			return
+		}
+		opts = opts.WithLogo(loaded.Image)
+	}
+	img = transform.Apply(img, opts)
+
+	var buf bytes.Buffer
+	if err := transform.Encode(&buf, img, opts, embedded); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to encode image: %v", err)})
+		return
+	}
+
+	// A failed cache write only costs a re-render next time
+	meta := objectMetadata(image)
+	meta.Checksum = storage.Checksum(buf.Bytes())
+	meta.Pipeline = key
+	_, err = h.store.UploadFile(ctx, h.layout.ProcessedBucket, objectName, bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.PutOptions{
+		ContentType: opts.ContentType(),
+		Metadata:    meta,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to cache render %s: %v", objectName, err)
+	}
+
+	h.setContentHeaders(c, key, opts.ContentType(), filename)
+	http.ServeContent(c.Writer, c.Request, objectName, time.Now(), bytes.NewReader(buf.Bytes()))
+}
+
+// parseRenderOptions reads the render query parameters and enforces the
+// configured limits. The output format defaults to that of the original,
+// with TIFF originals rendered as JPEG.
+func (h *Handler) parseRenderOptions(c *gin.Context, image *models.Image) (transform.Options, error) {
+	var opts transform.Options
+	var err error
+
+	if opts.Width, err = queryInt(c, "w"); err != nil {
+		return opts, err
+	}
+	if opts.Height, err = queryInt(c, "h"); err != nil {
+		return opts, err
+	}
+	// Without a size the render would be as large as the original
+	if opts.Width == 0 && opts.Height == 0 {
+		return opts, fmt.Errorf("w or h is required")
+	}
+	if opts.Quality, err = queryInt(c, "q"); err != nil {
+		return opts, err
+	}
+	opts.Fit = c.DefaultQuery("fit", transform.FitContain)
+	opts.Metadata = c.DefaultQuery("metadata", transform.MetadataStrip)
+	opts.Format = c.Query("format")
+	if opts.Format == "jpg" {
+		opts.Format = transform.FormatJPEG
+	}
+	if opts.Format == "" {
+		opts.Format = transform.FormatJPEG
+		if imageformat.FromExtension(filepath.Ext(image.Filename)) == imageformat.PNG {
+			opts.Format = transform.FormatPNG
+		}
+	}
+
+	if opts.Overlay, err = parseOverlay(c); err != nil {
+		return opts, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+
+	for _, size := range []int{opts.Width, opts.Height} {
+		if size > h.cfg.RenderMaxDimension {
+			return opts, fmt.Errorf("w and h must not exceed %d", h.cfg.RenderMaxDimension)
+		}
+		if size != 0 && len(h.cfg.RenderAllowedSizes) > 0 && !slices.Contains(h.cfg.RenderAllowedSizes, size) {
+			return opts, fmt.Errorf("size %d is not allowed; allowed sizes are %v", size, h.cfg.RenderAllowedSizes)
+		}
+	}
+	if opts.Quality != 0 {
+		if opts.Format != transform.FormatJPEG {
+			return opts, fmt.Errorf("q is only supported for jpeg output")
+		}
+		if len(h.cfg.RenderAllowedQualities) > 0 && !slices.Contains(h.cfg.RenderAllowedQualities, opts.Quality) {
+			return opts, fmt.Errorf("quality %d is not allowed; allowed qualities are %v", opts.Quality, h.cfg.RenderAllowedQualities)
+		}
+	}
+
+	return opts, nil
+}
+
+// parseOverlay reads the overlay_* render parameters. It returns nil when
+// neither overlay_logo nor overlay_text is given.
+func parseOverlay(c *gin.Context) (*transform.Overlay, error) {
+	logoID, text := c.Query("overlay_logo"), c.Query("overlay_text")
+	if logoID == "" && text == "" {
+		return nil, nil
+	}
+	overlay := &transform.Overlay{
+		Text:     text,
+		Position: c.Query("overlay_position"),
+	}
+	if logoID != "" {
+		id, err := uuid.Parse(logoID)
+		if err != nil {
+			return nil, fmt.Errorf("overlay_logo must be an image ID")
+		}
+		overlay.LogoID = id
+	}
+	var err error
+	if overlay.Opacity, err = queryFloat(c, "overlay_opacity"); err != nil {
+		return nil, err
+	}
+	if overlay.Scale, err = queryFloat(c, "overlay_scale"); err != nil {
+		return nil, err
+	}
+	if value := c.Query("overlay_tile"); value != "" {
+		if overlay.Tile, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("overlay_tile must be true or false")
+		}
+	}
+	return overlay, nil
+}
+
+// queryFloat parses an optional number query parameter, returning 0 when absent
+func queryFloat(c *gin.Context, name string) (float64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsNaN(f) {
+		return 0, fmt.Errorf("%s must be a non-negative number", name)
+	}
+	return f, nil
+}
+
+// queryInt parses an optional integer query parameter, returning 0 when absent
+func queryInt(c *gin.Context, name string) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
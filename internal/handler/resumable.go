@@ -0,0 +1,461 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"image-processor/internal/imageformat"
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads follow the shape of the tus protocol: the client creates
+// an upload, PATCHes chunks at the current Upload-Offset and uses HEAD to find
+// out where to resume after a dropped connection. Every chunk becomes one part
+// of a Minio multipart upload, so all chunks except the last must be at least
+// storage.MinPartSize bytes. A chunk is stored entirely or not at all.
+
+const chunkContentType = "application/offset+octet-stream"
+
+type CreateUploadRequest struct {
+	Filename    string `json:"filename" binding:"required"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size" binding:"required"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type ResumableUploadResponse struct {
+	ID           string    `json:"id"`
+	Offset       int64     `json:"offset"`
+	Length       int64     `json:"length"`
+	MinChunkSize int64     `json:"min_chunk_size"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// CallbackSecret signs the deliveries to the callback_url of the upload
+	CallbackSecret string `json:"callback_secret,omitempty"`
+}
+
+// CreateUpload starts a resumable upload session for a file of known size
+func (h *Handler) CreateUpload(c *gin.Context) {
+	var req CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename and size are required"})
+		return
+	}
+
+	filename := filepath.Base(req.Filename)
+	ext, contentType, ok := resolveUploadType(filename, req.ContentType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only .jpg, .jpeg, .png, .tif and .tiff extensions are allowed"})
+		return
+	}
+
+	if req.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive"})
+		return
+	}
+	if req.Size > h.cfg.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds maximum upload size of %d bytes", h.cfg.MaxUploadSize)})
+		return
+	}
+
+	cb, ok := parseCallbackURL(c, req.CallbackURL)
+	if !ok {
+		return
+	}
+
+	image := h.newImage(c, filename, ext, models.ImageStatusUploading, cb)
+	imageID, bucketName, objectName := image.ID, image.BucketName, image.OriginalKey
+	expiresAt := time.Now().Add(h.cfg.UploadSessionTTL).UTC()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	uploadID, err := h.store.NewMultipartUpload(ctx, bucketName, objectName, storage.PutOptions{
+		ContentType: contentType,
+		Metadata:    objectMetadata(image),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to start upload: %v", err)})
+		return
+	}
+
+	err = h.uploads.Create(ctx, image, &models.UploadSession{
+		ImageID:     imageID,
+		UploadID:    uploadID,
+		BucketName:  bucketName,
+		ObjectName:  objectName,
+		ContentType: contentType,
+		TotalSize:   req.Size,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		h.abortMultipart(bucketName, objectName, uploadID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save to database: %v", err)})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/v1/uploads/%s", imageID))
+	c.Header("Upload-Offset", "0")
+	c.Header("Upload-Length", strconv.FormatInt(req.Size, 10))
+	c.Header("Upload-Expires", expiresAt.Format(http.TimeFormat))
+	c.JSON(http.StatusCreated, ResumableUploadResponse{
+		ID:             imageID.String(),
+		Offset:         0,
+		Length:         req.Size,
+		MinChunkSize:   storage.MinPartSize,
+		ExpiresAt:      expiresAt,
+		CallbackSecret: cb.Secret,
+	})
+}
+
+// UploadStatus reports how many bytes of a resumable upload have been stored
+func (h *Handler) UploadStatus(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if !h.ownsUpload(ctx, c, imageID) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	session, err := h.uploads.Get(ctx, imageID)
+	if err != nil || session.UploadID == "" || time.Now().After(session.ExpiresAt) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.TotalSize, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// PatchUpload appends one chunk to a resumable upload. The Upload-Offset
+// header must match the number of bytes already stored. When the last chunk
+// arrives the object is assembled and queued for processing.
+func (h *Handler) PatchUpload(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID format"})
+		return
+	}
+
+	if c.ContentType() != chunkContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": fmt.Sprintf("Content-Type must be %s", chunkContentType)})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header is required"})
+		return
+	}
+
+	chunkSize := c.Request.ContentLength
+	if chunkSize < 0 {
+		c.JSON(http.StatusLengthRequired, gin.H{"error": "Content-Length header is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.UploadTimeout)
+	defer cancel()
+
+	if !h.ownsUpload(ctx, c, imageID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+
+	// Serialise chunks for the same upload so part numbers never collide.
+	// The token keeps a request whose lock expired from releasing the lock
+	// of the next one.
+	lockKey := fmt.Sprintf("upload:%s:lock", imageID)
+	lockToken := rand.Text()
+	acquired, err := h.redisClient.SetNX(ctx, lockKey, lockToken, h.cfg.UploadTimeout)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to lock upload: %v", err)})
+		return
+	}
+	if !acquired {
+		c.JSON(http.StatusLocked, gin.H{"error": "Another chunk is currently being uploaded"})
+		return
+	}
+	defer func() {
+		if _, err := h.redisClient.DeleteIfEqual(context.Background(), lockKey, lockToken); err != nil {
+			log.Printf("Warning: failed to release lock %s: %v", lockKey, err)
+		}
+	}()
+
+	session, err := h.uploads.Get(ctx, imageID)
+	if errors.Is(err, repository.ErrUploadNotFound) || err == nil && session.UploadID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load upload: %v", err)})
+		return
+	}
+	if time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload has expired"})
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	if offset != session.Offset {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Upload-Offset %d does not match stored offset %d", offset, session.Offset)})
+		return
+	}
+
+	remaining := session.TotalSize - session.Offset
+	if chunkSize > remaining {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Chunk of %d bytes exceeds the %d bytes remaining", chunkSize, remaining)})
+		return
+	}
+	// An empty chunk is only meaningful to retry assembling a fully received upload
+	if chunkSize == 0 && remaining != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk is empty"})
+		return
+	}
+	if chunkSize < remaining && chunkSize < storage.MinPartSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Chunks other than the last must be at least %d bytes", storage.MinPartSize)})
+		return
+	}
+
+	if chunkSize > 0 {
+		var body io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, chunkSize)
+
+		// Reject files that are not images before storing anything
+		if session.Offset == 0 {
+			br := bufio.NewReaderSize(body, imageformat.SniffLen)
+			header, _ := br.Peek(imageformat.SniffLen)
+			if imageformat.Detect(header) == "" {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Uploaded file is not a JPEG, PNG or TIFF image"})
+				return
+			}
+			body = br
+		}
+
+		digest, err := resumeHash(session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to resume upload: %v", err)})
+			return
+		}
+		body = io.TeeReader(body, digest)
+
+		partNumber := len(session.PartETags) + 1
+		etag, err := h.store.UploadPart(ctx, session.BucketName, session.ObjectName, session.UploadID, partNumber, body, chunkSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to store chunk: %v", err)})
+			return
+		}
+
+		session.PartETags = append(session.PartETags, etag)
+		session.Offset += chunkSize
+		session.ExpiresAt = time.Now().Add(h.cfg.UploadSessionTTL).UTC()
+		session.HashState, err = digest.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save upload checksum: %v", err)})
+			return
+		}
+
+		if err := h.uploads.SaveProgress(ctx, session); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save upload progress: %v", err)})
+			return
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	if session.Offset < session.TotalSize {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	h.finalizeUpload(ctx, c, session)
+}
+
+// CancelUpload aborts a resumable or presigned upload and discards what was
+// stored
+func (h *Handler) CancelUpload(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	session, err := h.uploads.Get(ctx, imageID)
+	if err != nil || !h.ownsUpload(ctx, c, imageID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+
+	if err := h.discardUploadSession(ctx, session, "Upload cancelled"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to cancel upload: %v", err)})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ExpireUploadSessions discards resumable uploads that have not received a
+// chunk within the session TTL and presigned uploads that were not completed,
+// and returns how many were removed
+func (h *Handler) ExpireUploadSessions(ctx context.Context) (int, error) {
+	sessions, err := h.uploads.Expired(ctx, time.Now(), 100)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range sessions {
+		if err := h.discardUploadSession(ctx, &sessions[i], "Upload session expired"); err != nil {
+			log.Printf("Failed to expire upload %s: %v", sessions[i].ImageID, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// finalizeUpload assembles the uploaded parts and hands the image to the
+// worker. It may be retried with an empty chunk: an object assembled by an
+// earlier attempt whose session could not be finished is used as it is,
+// since its multipart upload no longer exists.
+func (h *Handler) finalizeUpload(ctx context.Context, c *gin.Context, session *models.UploadSession) {
+	_, err := h.store.StatFile(ctx, session.BucketName, session.ObjectName)
+	if errors.Is(err, storage.ErrNotFound) {
+		err = h.store.CompleteMultipartUpload(ctx, session.BucketName, session.ObjectName, session.UploadID, session.PartETags)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to assemble upload: %v", err)})
+		return
+	}
+
+	digest, err := resumeHash(session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to assemble upload: %v", err)})
+		return
+	}
+	checksum := hex.EncodeToString(digest.Sum(nil))
+
+	image, err := h.uploads.Finish(ctx, session.ImageID, repository.Transition{
+		From:     []models.ImageStatus{models.ImageStatusUploading},
+		To:       models.ImageStatusPending,
+		Checksum: checksum,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update image: %v", err)})
+		return
+	}
+	filename := image.Filename
+
+	if h.linkDuplicate(ctx, session.ImageID, checksum) {
+		c.JSON(http.StatusOK, UploadResponse{
+			ID:       session.ImageID.String(),
+			Filename: filename,
+			Status:   string(models.ImageStatusCompleted),
+			Message:  duplicateMessage,
+		})
+		return
+	}
+	h.statusChanged(ctx, session.ImageID, models.ImageStatusPending)
+
+	if err := h.enqueue(session.ImageID, session.BucketName, session.ObjectName); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to publish message: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, UploadResponse{
+		ID:       session.ImageID.String(),
+		Filename: filename,
+		Status:   string(models.ImageStatusPending),
+		Message:  "Upload completed and queued for processing",
+	})
+}
+
+// discardUploadSession removes the session, marks the image as failed with
+// reason and discards what was stored: the parts of a multipart upload, or
+// the object a presigned upload may have written
+func (h *Handler) discardUploadSession(ctx context.Context, session *models.UploadSession, reason string) error {
+	if session.UploadID != "" {
+		if err := h.store.AbortMultipartUpload(ctx, session.BucketName, session.ObjectName, session.UploadID); err != nil {
+			log.Printf("Warning: %v (upload %s)", err, session.ImageID)
+		}
+	}
+
+	// An image that is no longer uploading has already moved on
+	changed, err := h.uploads.Discard(ctx, session.ImageID, repository.Transition{
+		From:  []models.ImageStatus{models.ImageStatusUploading},
+		To:    models.ImageStatusFailed,
+		Error: reason,
+	})
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	// Presigned objects are only deleted once the image can no longer be
+	// completed, since they are the final object
+	if session.UploadID == "" {
+		if err := h.store.DeleteFile(ctx, session.BucketName, session.ObjectName); err != nil {
+			log.Printf("Warning: failed to delete %s/%s: %v (upload %s)", session.BucketName, session.ObjectName, err, session.ImageID)
+		}
+	}
+	h.statusChanged(ctx, session.ImageID, models.ImageStatusFailed)
+	h.notifyWebhooks(ctx, session.ImageID)
+	return nil
+}
+
+// resumeHash restores the SHA-256 of the bytes a session has received so far
+func resumeHash(session *models.UploadSession) (hash.Hash, error) {
+	digest := sha256.New()
+	if session.Offset == 0 {
+		return digest, nil
+	}
+	if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(session.HashState); err != nil {
+		return nil, fmt.Errorf("failed to restore upload checksum: %w", err)
+	}
+	return digest, nil
+}
+
+// ownsUpload reports whether the caller started the upload of an image.
+// Uploads of other users are reported as not found.
+func (h *Handler) ownsUpload(ctx context.Context, c *gin.Context, imageID uuid.UUID) bool {
+	image, err := h.images.Get(ctx, imageID)
+	return err == nil && image.Owner == c.GetString("user")
+}
+
+// abortMultipart discards a multipart upload whose session could not be recorded
+func (h *Handler) abortMultipart(bucketName, objectName, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.store.AbortMultipartUpload(ctx, bucketName, objectName, uploadID); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"image-processor/pkg/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Register adds the API routes to router. auth authenticates the caller
+// and sets "user"; images record it as their owner and only their owner
+// may read them, so every API route runs it. Signed public routes are the
+// only way to read an image without a bearer token.
+func (h *Handler) Register(router gin.IRouter, auth gin.HandlerFunc) {
+	v1 := router.Group("/api/v1", auth)
+	{
+		// Uploads are owned by the Keycloak user, which scopes listings and
+		// selects the account webhooks notified about them
+		v1.POST("/upload", h.UploadImage)
+		v1.PUT("/images", h.UploadImageRaw)
+		v1.POST("/images/import", h.ImportImage)
+		v1.POST("/uploads/presign", h.PresignUpload)
+		v1.POST("/uploads/:id/complete", h.CompleteUpload)
+		v1.POST("/uploads", h.CreateUpload)
+		v1.HEAD("/uploads/:id", h.UploadStatus)
+		v1.PATCH("/uploads/:id", h.PatchUpload)
+		v1.DELETE("/uploads/:id", h.CancelUpload)
+		v1.GET("/images", h.ListImages)
+		v1.GET("/images/:id", h.GetImage)
+		v1.DELETE("/images/:id", h.DeleteImage)
+		v1.GET("/images/:id/content", h.GetImageContent)
+		v1.GET("/images/:id/events", h.ImageEvents)
+		v1.GET("/images/:id/ws", h.ImageEventsWebSocket)
+		v1.POST("/images/:id/events-url", h.CreateEventsURL)
+		v1.GET("/images/:id/render", h.RenderImage)
+		v1.POST("/images/:id/signed-url", h.CreateSignedURL)
+	}
+
+	// Similarity search starts from an image of the caller and only spans
+	// other owners for moderators
+	v1.GET("/images/:id/similar", h.SimilarImages)
+
+	// Account webhooks are scoped to the Keycloak user
+	webhooks := v1.Group("/webhooks")
+	{
+		webhooks.POST("", h.CreateWebhook)
+		webhooks.GET("", h.ListWebhooks)
+		webhooks.DELETE("/:id", h.DeleteWebhook)
+		webhooks.GET("/deliveries", h.ListWebhookDeliveries)
+		webhooks.POST("/deliveries/:id/replay", h.ReplayWebhookDelivery)
+	}
+
+	// Signed public routes (no bearer token required)
+	if h.urlSigner != nil {
+		public := router.Group("/public", security.SignedURLMiddleware(h.urlSigner))
+		{
+			public.GET("/images/:id/content", h.GetImageContent)
+			public.GET("/images/:id/render", h.RenderImage)
+			// Browsers cannot send a bearer token with EventSource or WebSocket
+			public.GET("/images/:id/events", h.ImageEvents)
+			public.GET("/images/:id/ws", h.ImageEventsWebSocket)
+		}
+	}
+}
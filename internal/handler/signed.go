@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RenderParams struct {
+	Width    int            `json:"w"`
+	Height   int            `json:"h"`
+	Fit      string         `json:"fit"`
+	Format   string         `json:"format"`
+	Quality  int            `json:"q"`
+	Metadata string         `json:"metadata"`
+	Overlay  *OverlayParams `json:"overlay"`
+}
+
+// OverlayParams are the overlay_* render parameters
+type OverlayParams struct {
+	LogoID   string  `json:"logo_id"`
+	Text     string  `json:"text"`
+	Position string  `json:"position"`
+	Opacity  float64 `json:"opacity"`
+	Scale    float64 `json:"scale"`
+	Tile     bool    `json:"tile"`
+}
+
+type SignedURLRequest struct {
+	// Variant selects the content to link to; ignored when Render is set
+	Variant   string        `json:"variant"`
+	Render    *RenderParams `json:"render"`
+	ExpiresIn int           `json:"expires_in"` // seconds
+}
+
+type SignedURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateSignedURL issues a link to an image's content or a rendition that
+// can be fetched without a bearer token until it expires
+func (h *Handler) CreateSignedURL(c *gin.Context) {
+	if h.urlSigner == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Signed URLs are not configured"})
+		return
+	}
+
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	var req SignedURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ttl := h.cfg.SignedURLDefaultTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > h.cfg.SignedURLMaxTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in must not exceed %d seconds", int(h.cfg.SignedURLMaxTTL.Seconds()))})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Signed URLs grant access to anyone, so only the owner may issue them
+	if image, err := h.images.Get(ctx, imageID); err != nil || !canRead(c, image.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	params := url.Values{}
+	path := fmt.Sprintf("/public/images/%s/content", imageID)
+	if req.Render != nil {
+		path = fmt.Sprintf("/public/images/%s/render", imageID)
+		setNonZero(params, "w", req.Render.Width)
+		setNonZero(params, "h", req.Render.Height)
+		setNonZero(params, "q", req.Render.Quality)
+		if req.Render.Fit != "" {
+			params.Set("fit", req.Render.Fit)
+		}
+		if req.Render.Format != "" {
+			params.Set("format", req.Render.Format)
+		}
+		if req.Render.Metadata != "" {
+			params.Set("metadata", req.Render.Metadata)
+		}
+		if overlay := req.Render.Overlay; overlay != nil {
+			setNonEmpty(params, "overlay_logo", overlay.LogoID)
+			setNonEmpty(params, "overlay_text", overlay.Text)
+			setNonEmpty(params, "overlay_position", overlay.Position)
+			if overlay.Opacity != 0 {
+				params.Set("overlay_opacity", strconv.FormatFloat(overlay.Opacity, 'g', -1, 64))
+			}
+			if overlay.Scale != 0 {
+				params.Set("overlay_scale", strconv.FormatFloat(overlay.Scale, 'g', -1, 64))
+			}
+			if overlay.Tile {
+				params.Set("overlay_tile", "true")
+			}
+		}
+	} else if req.Variant != "" {
+		if req.Variant != VariantProcessed && req.Variant != VariantOriginal {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown variant %q", req.Variant)})
+			return
+		}
+		params.Set("variant", req.Variant)
+	}
+
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	signed := h.urlSigner.Sign(path, params, expiresAt)
+
+	c.JSON(http.StatusCreated, SignedURLResponse{
+		URL:       strings.TrimSuffix(h.cfg.PublicBaseURL, "/") + path + "?" + signed.Encode(),
+		ExpiresAt: expiresAt,
+	})
+}
+
+func setNonZero(params url.Values, key string, value int) {
+	if value != 0 {
+		params.Set(key, strconv.Itoa(value))
+	}
+}
+
+func setNonEmpty(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
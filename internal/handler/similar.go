@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/pkg/security"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSimilarDistance = 10
+	defaultSimilarLimit    = 20
+	maxSimilarLimit        = 100
+)
+
+// SimilarImageResponse is an image found by a similarity search. Results of
+// moderators span all owners, so the owner is included.
+type SimilarImageResponse struct {
+	ImageResponse
+	Owner    string `json:"owner"`
+	Distance int    `json:"distance"`
+}
+
+// SimilarImages finds near-duplicates of an image of the caller by the
+// Hamming distance between perceptual hashes, e.g.
+// /images/:id/similar?algorithm=phash&max_distance=10&limit=20. Users only
+// find their own images; moderators find reposts across all owners, without
+// the capture details of other owners' images.
+func (h *Handler) SimilarImages(c *gin.Context) {
+	imageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+		return
+	}
+
+	opts := repository.SimilarOptions{
+		Algorithm:   c.DefaultQuery("algorithm", models.HashDCT),
+		MaxDistance: defaultSimilarDistance,
+		Limit:       defaultSimilarLimit,
+	}
+	if _, ok := (models.PerceptualHashes{}).Hash(opts.Algorithm); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown algorithm %q; use ahash, dhash or phash", opts.Algorithm)})
+		return
+	}
+	if value := c.Query("max_distance"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_distance must be between 0 and 64"})
+			return
+		}
+		opts.MaxDistance = n
+	}
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSimilarLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxSimilarLimit)})
+			return
+		}
+		opts.Limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	image, err := h.images.Get(ctx, imageID)
+	if err != nil || !canRead(c, image.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	user := c.GetString("user")
+	if !security.HasRole(c, h.cfg.ModerationRole) {
+		opts.Owner = user
+	}
+
+	similar, err := h.images.Similar(ctx, imageID, opts)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrNotHashed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Image has not been hashed; it may still be processing"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to search similar images: %v", err)})
+		return
+	}
+
+	responses := make([]SimilarImageResponse, len(similar))
+	for i := range similar {
+		responses[i] = SimilarImageResponse{
+			ImageResponse: newImageResponse(&similar[i].Image),
+			Owner:         similar[i].Owner,
+			Distance:      similar[i].Distance,
+		}
+		if similar[i].Owner != user {
+			responses[i].Metadata = publicMetadata(responses[i].Metadata)
+			continue
+		}
+		if similar[i].Status == models.ImageStatusCompleted {
+			responses[i].ContentURL = contentURL(responses[i].ID)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"image_id":     imageID.String(),
+		"algorithm":    opts.Algorithm,
+		"max_distance": opts.MaxDistance,
+		"images":       responses,
+	})
+}
+
+// publicMetadata keeps what describes the file of another owner's image and
+// drops the EXIF details that identify where, when and with which camera it
+// was taken
+func publicMetadata(metadata *models.ImageMetadata) *models.ImageMetadata {
+	if metadata == nil {
+		return nil
+	}
+	return &models.ImageMetadata{
+		Width:         metadata.Width,
+		Height:        metadata.Height,
+		Format:        metadata.Format,
+		Size:          metadata.Size,
+		Orientation:   metadata.Orientation,
+		HasICCProfile: metadata.HasICCProfile,
+	}
+}
@@ -2,128 +2,260 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"image-processor/internal/imageformat"
 	"image-processor/internal/models"
+	"image-processor/internal/queue"
+	"image-processor/internal/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-const MaxUploadSize = 10 << 20 // 10MB
+// multipartOverhead is the slack allowed on top of MaxUploadSize for
+// multipart boundaries and part headers
+const multipartOverhead = 1 << 20 // 1MB
+
+// allowedExtensions maps accepted file extensions to their canonical content type
+var allowedExtensions = map[string]string{
+	".jpg":  imageformat.JPEG,
+	".jpeg": imageformat.JPEG,
+	".png":  imageformat.PNG,
+	".tif":  imageformat.TIFF,
+	".tiff": imageformat.TIFF,
+}
+
+var errUploadTooLarge = errors.New("upload exceeds maximum allowed size")
+
+// duplicateMessage is returned for uploads identical to an image already processed
+const duplicateMessage = "Identical image already processed; its results are reused"
 
 type UploadResponse struct {
 	ID       string `json:"id"`
 	Filename string `json:"filename"`
 	Status   string `json:"status"`
 	Message  string `json:"message"`
+	// CallbackSecret signs the deliveries to the callback_url of the upload.
+	// It is only returned when the upload is created.
+	CallbackSecret string `json:"callback_secret,omitempty"`
 }
 
-type TaskMessage struct {
-	ImageID    string `json:"image_id"`
-	BucketName string `json:"bucket_name"`
-	ObjectName string `json:"object_name"`
+// limitedReader returns errUploadTooLarge once more than remaining bytes have been read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		return int(l.remaining), errUploadTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
 }
 
+// UploadImage streams the "image" part of a multipart form straight into Minio
+// without spooling it to memory or disk first. Form fields sent after the file
+// part are ignored.
 func (h *Handler) UploadImage(c *gin.Context) {
-	// Set max upload size
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxUploadSize)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.MaxUploadSize+multipartOverhead)
 
-	// Parse multipart form
-	file, header, err := c.Request.FormFile("image")
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart/form-data request"})
+		return
+	}
+
+	// Skip parts until we reach the image file
+	var part *multipart.Part
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read multipart form"})
+			return
+		}
+		if p.FormName() == "image" && p.FileName() != "" {
+			part = p
+			break
+		}
+		p.Close()
+	}
+	if part == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file from request"})
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
-	// Validate file extension first
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Only .jpg, .jpeg, and .png extensions are allowed"})
+	h.ingestImage(c, part.FileName(), part.Header.Get("Content-Type"), part, -1)
+}
+
+// UploadImageRaw accepts the image as the raw request body for clients that
+// cannot produce multipart forms. The original filename is taken from the
+// "filename" query parameter or the X-Filename header.
+func (h *Handler) UploadImageRaw(c *gin.Context) {
+	filename := c.Query("filename")
+	if filename == "" {
+		filename = c.GetHeader("X-Filename")
+	}
+	if filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename query parameter or X-Filename header is required"})
+		return
+	}
+	filename = filepath.Base(filename)
+
+	if c.Request.ContentLength == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body is empty"})
 		return
 	}
 
-	// Validate file type from Content-Type header
-	contentType := header.Header.Get("Content-Type")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.MaxUploadSize+1)
+	h.ingestImage(c, filename, c.GetHeader("Content-Type"), c.Request.Body, c.Request.ContentLength)
+}
 
-	// If Content-Type is missing or invalid, determine it from extension
-	if contentType == "" {
-		switch ext {
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".png":
-			contentType = "image/png"
-		}
+// ingestImage validates the file type, streams body into the raw bucket,
+// records the image and queues it for processing. size may be -1 when unknown.
+func (h *Handler) ingestImage(c *gin.Context, filename, contentType string, body io.Reader, size int64) {
+	ext, contentType, ok := resolveUploadType(filename, contentType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only .jpg, .jpeg, .png, .tif and .tiff extensions are allowed"})
+		return
 	}
 
-	// Validate Content-Type matches allowed types
-	if !strings.HasPrefix(contentType, "image/jpeg") && !strings.HasPrefix(contentType, "image/png") {
-		// If Content-Type doesn't match but extension is valid, use extension-based type
-		if ext == ".jpg" || ext == ".jpeg" {
-			contentType = "image/jpeg"
-		} else if ext == ".png" {
-			contentType = "image/png"
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Only JPEG and PNG files are allowed"})
-			return
-		}
+	if size > h.cfg.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds maximum upload size of %d bytes", h.cfg.MaxUploadSize)})
+		return
 	}
 
-	// Generate UUID for image
-	imageID := uuid.New()
-	objectName := fmt.Sprintf("%s%s", imageID.String(), ext)
-	bucketName := "raw-images"
+	cb, ok := parseCallbackURL(c, c.Query("callback_url"))
+	if !ok {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	image := h.newImage(c, filename, ext, models.ImageStatusPending, cb)
+	imageID, bucketName, objectName := image.ID, image.BucketName, image.OriginalKey
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.UploadTimeout)
 	defer cancel()
 
-	// Upload to Minio
-	_, err = h.minioClient.UploadFile(ctx, bucketName, objectName, file, header.Size, contentType)
+	// Stream to Minio, hashing on the way; unknown sizes are sent as a
+	// multipart object upload
+	limited := &limitedReader{r: body, remaining: h.cfg.MaxUploadSize}
+	hash := sha256.New()
+	_, err := h.store.UploadFile(ctx, bucketName, objectName, io.TeeReader(limited, hash), size, storage.PutOptions{
+		ContentType: contentType,
+		Metadata:    objectMetadata(image),
+	})
 	if err != nil {
+		if limited.exceeded {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds maximum upload size of %d bytes", h.cfg.MaxUploadSize)})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to upload file: %v", err)})
 		return
 	}
 
 	// Insert record into PostgreSQL
-	query := `
-		INSERT INTO images (id, filename, status, bucket_name, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
-	`
-	_, err = h.pgPool.Exec(ctx, query, imageID, header.Filename, models.ImageStatusPending, bucketName)
-	if err != nil {
+	image.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if err := h.images.Create(ctx, image); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save to database: %v", err)})
 		return
 	}
 
-	// Publish message to RabbitMQ
-	taskMsg := TaskMessage{
-		ImageID:    imageID.String(),
-		BucketName: bucketName,
-		ObjectName: objectName,
-	}
-	msgBytes, err := json.Marshal(taskMsg)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task message"})
+	if h.linkDuplicate(ctx, imageID, image.Checksum) {
+		c.JSON(http.StatusCreated, UploadResponse{
+			ID:             imageID.String(),
+			Filename:       filename,
+			Status:         string(models.ImageStatusCompleted),
+			Message:        duplicateMessage,
+			CallbackSecret: cb.Secret,
+		})
 		return
 	}
 
-	err = h.rabbitClient.Publish(msgBytes)
-	if err != nil {
+	// Publish message to RabbitMQ
+	if err := h.enqueue(imageID, bucketName, objectName); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to publish message: %v", err)})
 		return
 	}
 
 	// Return success response
 	c.JSON(http.StatusCreated, UploadResponse{
-		ID:       imageID.String(),
-		Filename: header.Filename,
-		Status:   string(models.ImageStatusPending),
-		Message:  "Image uploaded successfully and queued for processing",
+		ID:             imageID.String(),
+		Filename:       filename,
+		Status:         string(models.ImageStatusPending),
+		Message:        "Image uploaded successfully and queued for processing",
+		CallbackSecret: cb.Secret,
+	})
+}
+
+// resolveUploadType validates the extension of filename and returns it along
+// with the content type to store. A missing or unrecognised contentType is
+// replaced by the type implied by the extension.
+func resolveUploadType(filename, contentType string) (string, string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	extType, ok := allowedExtensions[ext]
+	if !ok {
+		return "", "", false
+	}
+	if !isAllowedContentType(contentType) {
+		contentType = extType
+	}
+	return ext, contentType, true
+}
+
+// isAllowedContentType reports whether contentType is one of the accepted image types
+func isAllowedContentType(contentType string) bool {
+	for _, allowed := range allowedExtensions {
+		if strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkDuplicate lets imageID share the objects of an identical image that
+// was already processed and reports whether it did. Errors are logged and
+// the image is processed as usual.
+func (h *Handler) linkDuplicate(ctx context.Context, imageID uuid.UUID, checksum string) bool {
+	if !h.cfg.DedupEnabled {
+		return false
+	}
+	linked, err := h.dedup.Link(ctx, imageID, checksum, h.pipeline.Key())
+	if err != nil {
+		log.Printf("Warning: failed to deduplicate image %s: %v", imageID, err)
+		return false
+	}
+	if linked {
+		h.statusChanged(ctx, imageID, models.ImageStatusCompleted)
+		h.notifyWebhooks(ctx, imageID)
+	}
+	return linked
+}
+
+// enqueue publishes a processing task for an uploaded image
+func (h *Handler) enqueue(imageID uuid.UUID, bucketName, objectName string) error {
+	return queue.PublishTask(h.queue, queue.TaskMessage{
+		ImageID:    imageID.String(),
+		BucketName: bucketName,
+		ObjectName: objectName,
 	})
 }
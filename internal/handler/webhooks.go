@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreateWebhookRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// CreateWebhookResponse is the only response that includes the signing secret
+type CreateWebhookResponse struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// CreateWebhook subscribes a URL to the terminal events of every image
+// uploaded by the caller's account
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	owner, ok := requireOwner(c)
+	if !ok {
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+	target, err := parseRemoteURL(req.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url " + err.Error()})
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	subscription := models.WebhookSubscription{
+		ID:     uuid.New(),
+		Owner:  owner,
+		URL:    target.String(),
+		Secret: secret,
+	}
+	if err := h.webhooks.CreateSubscription(ctx, &subscription); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save to database: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateWebhookResponse{WebhookSubscription: subscription, Secret: secret})
+}
+
+// ListWebhooks returns the caller's subscriptions
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	owner, ok := requireOwner(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	subscriptions, err := h.webhooks.ListSubscriptions(ctx, owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load webhooks: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": subscriptions})
+}
+
+// DeleteWebhook removes a subscription along with its delivery log
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	owner, ok := requireOwner(c)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	err = h.webhooks.DeleteSubscription(ctx, id, owner)
+	if errors.Is(err, repository.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete webhook: %v", err)})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries returns the caller's delivery log, newest first,
+// optionally filtered by status and image
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+	owner, ok := requireOwner(c)
+	if !ok {
+		return
+	}
+
+	opts := repository.DeliveryListOptions{Owner: owner, Limit: 100}
+	if status := c.Query("status"); status != "" {
+		switch models.DeliveryStatus(status) {
+		case models.DeliveryStatusPending, models.DeliveryStatusDelivered, models.DeliveryStatusFailed:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown delivery status %q", status)})
+			return
+		}
+		opts.Status = models.DeliveryStatus(status)
+	}
+	if imageParam := c.Query("image_id"); imageParam != "" {
+		imageID, err := uuid.Parse(imageParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID format"})
+			return
+		}
+		opts.ImageID = imageID
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	deliveries, err := h.webhooks.ListDeliveries(ctx, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load deliveries: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// ReplayWebhookDelivery schedules a failed delivery to be sent again
+// immediately, with a fresh set of attempts
+func (h *Handler) ReplayWebhookDelivery(c *gin.Context) {
+	owner, ok := requireOwner(c)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	delivery, err := h.webhooks.GetDelivery(ctx, id, owner)
+	if errors.Is(err, repository.ErrDeliveryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load delivery: %v", err)})
+		return
+	}
+	if delivery.Status != models.DeliveryStatusFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only failed deliveries can be replayed (delivery is %s)", delivery.Status)})
+		return
+	}
+
+	if err := h.webhooks.ReplayDelivery(ctx, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to replay delivery: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": models.DeliveryStatusPending})
+}
+
+// callback is a per-upload callback URL and the secret that signs the
+// deliveries to it. Each upload gets its own secret, so a receiver can only
+// verify callbacks of the uploads it was given.
+type callback struct {
+	URL    string
+	Secret string
+}
+
+// parseCallbackURL validates an optional per-upload callback URL and
+// generates its secret. It writes an error response and returns false if
+// the URL cannot be accepted.
+func parseCallbackURL(c *gin.Context, raw string) (callback, bool) {
+	if raw == "" {
+		return callback{}, true
+	}
+	callbackURL, err := parseRemoteURL(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "callback_url " + err.Error()})
+		return callback{}, false
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return callback{}, false
+	}
+	return callback{URL: callbackURL.String(), Secret: secret}, true
+}
+
+// parseRemoteURL checks that a user-supplied URL is an absolute http(s) URL
+// without credentials. Whether its host may be reached is decided at dial time.
+func parseRemoteURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, errors.New("must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return nil, errors.New("must not contain credentials")
+	}
+	return u, nil
+}
+
+// requireOwner returns the authenticated user, writing 401 if there is none
+func requireOwner(c *gin.Context) (string, bool) {
+	owner := c.GetString("user")
+	if owner == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Webhooks require an authenticated user"})
+		return "", false
+	}
+	return owner, true
+}
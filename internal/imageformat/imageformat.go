@@ -0,0 +1,56 @@
+package imageformat
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	TIFF = "image/tiff"
+)
+
+// SniffLen is the number of leading bytes Detect needs to identify a format
+const SniffLen = 512
+
+// Detect sniffs the magic bytes of an image and returns its content type, or
+// an empty string if it is not an accepted format
+func Detect(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return TIFF
+	}
+	return ""
+}
+
+// Extension returns the canonical file extension for an accepted content type
+func Extension(contentType string) string {
+	switch contentType {
+	case JPEG:
+		return ".jpg"
+	case PNG:
+		return ".png"
+	case TIFF:
+		return ".tiff"
+	}
+	return ""
+}
+
+// FromExtension returns the content type implied by a file extension, or an
+// empty string if the extension is not an accepted format
+func FromExtension(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return JPEG
+	case ".png":
+		return PNG
+	case ".tif", ".tiff":
+		return TIFF
+	}
+	return ""
+}
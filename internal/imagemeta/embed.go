@@ -0,0 +1,309 @@
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"slices"
+
+	"image-processor/internal/imageformat"
+)
+
+// Embedded is the metadata of an original that can be copied into an output
+type Embedded struct {
+	EXIF []byte // TIFF structure; nil when none is copied
+	ICC  []byte // RGB ICC profile; nil when none is copied
+}
+
+// tagOrientation is rewritten because outputs are stored upright
+const tagOrientation = 0x0112
+
+// Tags that are never copied. Structure tags describe the pixels of the
+// original and pointers are rebuilt; maker notes hold offsets that break
+// when moved, and XMP and IPTC blocks are not inspected.
+var droppedTags = map[uint16]bool{
+	0x0100: true, 0x0101: true, 0x0102: true, 0x0103: true, 0x0106: true, // dimensions and encoding
+	0x0111: true, 0x0115: true, 0x0116: true, 0x0117: true, 0x011C: true, // strips
+	0x0140: true, 0x0142: true, 0x0143: true, 0x0144: true, 0x0145: true, // palette and tiles
+	0x0152: true, 0x0153: true, 0x014A: true, // extra samples, sub-IFDs
+	0x0201: true, 0x0202: true, // thumbnail
+	0x02BC: true, 0x83BB: true, // XMP and IPTC
+	0x927C: true,               // MakerNote
+	0xA002: true, 0xA003: true, // pixel dimensions, which change with the output
+	0xA005:        true, // interoperability IFD
+	tagExifIFD:    true,
+	tagGPSIFD:     true,
+	tagICCProfile: true,
+}
+
+// privateTags identify the camera or its owner and are dropped together with
+// the GPS IFD unless private metadata is kept
+var privateTags = map[uint16]bool{
+	0x013B: true, // Artist
+	0x9286: true, // UserComment
+	0xA420: true, // ImageUniqueID
+	0xA430: true, // CameraOwnerName
+	0xA431: true, // BodySerialNumber
+	0xA435: true, // LensSerialNumber
+}
+
+// ReadEmbedded reads the EXIF and ICC profile of an original for copying into
+// outputs. EXIF is rewritten with orientation 1, as outputs are rotated
+// upright, and without thumbnails, maker notes or structure tags. Unless
+// keepPrivate is set, GPS and tags identifying the camera or its owner are
+// removed as well. Profiles other than RGB are dropped because outputs are
+// always RGB.
+func ReadEmbedded(r io.ReadSeeker, keepPrivate bool) (Embedded, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Embedded{}, err
+	}
+	_, format, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return Embedded{}, fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	found, err := scan(r, format)
+	var embedded Embedded
+	if len(found.icc) >= 20 && string(found.icc[16:20]) == "RGB " {
+		embedded.ICC = found.icc
+	}
+	if found.exif != nil {
+		// Metadata that cannot be rewritten is left out
+		embedded.EXIF, _ = rewriteEXIF(found.exif, keepPrivate)
+	}
+	return embedded, err
+}
+
+type rawEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+// rewriteEXIF copies the tags of IFD0 and the EXIF and GPS IFDs into a new
+// TIFF structure in the same byte order
+func rewriteEXIF(r io.ReaderAt, keepPrivate bool) ([]byte, error) {
+	t, offset, err := newTIFFReader(r)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := t.readIFD(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := func(e entry) bool {
+		return !droppedTags[e.tag] && (keepPrivate || !privateTags[e.tag])
+	}
+	ifd0, err := t.rawEntries(entries, keep)
+	if err != nil {
+		return nil, err
+	}
+	var exif, gps []rawEntry
+	for _, e := range entries {
+		switch {
+		case e.tag == tagExifIFD:
+			sub, err := t.readIFD(int64(t.order.Uint32(e.value)))
+			if err == nil {
+				exif, _ = t.rawEntries(sub, keep)
+			}
+		case e.tag == tagGPSIFD && keepPrivate:
+			sub, err := t.readIFD(int64(t.order.Uint32(e.value)))
+			if err == nil {
+				gps, _ = t.rawEntries(sub, func(entry) bool { return true })
+			}
+		}
+	}
+
+	for i := range ifd0 {
+		if ifd0[i].tag == tagOrientation {
+			ifd0[i] = rawEntry{tag: tagOrientation, typ: typeShort, count: 1, data: t.order.AppendUint16(nil, 1)}
+		}
+	}
+	if len(ifd0) == 0 && len(exif) == 0 && len(gps) == 0 {
+		return nil, nil
+	}
+	return t.write(ifd0, exif, gps), nil
+}
+
+// rawEntries reads the values of the entries keep accepts
+func (t tiffReader) rawEntries(entries []entry, keep func(entry) bool) ([]rawEntry, error) {
+	var raws []rawEntry
+	for _, e := range entries {
+		if !keep(e) {
+			continue
+		}
+		data, err := t.raw(e)
+		if err != nil {
+			return nil, err
+		}
+		raws = append(raws, rawEntry{tag: e.tag, typ: e.typ, count: e.count, data: data})
+	}
+	return raws, nil
+}
+
+// write lays out IFD0 followed by the EXIF and GPS IFDs, each followed by
+// the values that do not fit in their entries
+func (t tiffReader) write(ifd0, exif, gps []rawEntry) []byte {
+	pointer := func(tag uint16) rawEntry {
+		return rawEntry{tag: tag, typ: typeLong, count: 1, data: make([]byte, 4)}
+	}
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, pointer(tagExifIFD))
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, pointer(tagGPSIFD))
+	}
+
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exif)
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case tagExifIFD:
+			ifd0[i].data = t.order.AppendUint32(nil, uint32(exifOffset))
+		case tagGPSIFD:
+			ifd0[i].data = t.order.AppendUint32(nil, uint32(gpsOffset))
+		}
+	}
+
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	if t.order == binary.BigEndian {
+		out = []byte("MM\x00*\x00\x00\x00\x08")
+	}
+	out = t.appendIFD(out, ifd0)
+	if len(exif) > 0 {
+		out = t.appendIFD(out, exif)
+	}
+	if len(gps) > 0 {
+		out = t.appendIFD(out, gps)
+	}
+	return out
+}
+
+func ifdSize(entries []rawEntry) int {
+	if len(entries) == 0 {
+		return 0
+	}
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			size += len(e.data) + len(e.data)%2
+		}
+	}
+	return size
+}
+
+// appendIFD writes an IFD at the end of out. Entries must be sorted by tag.
+func (t tiffReader) appendIFD(out []byte, entries []rawEntry) []byte {
+	slices.SortFunc(entries, func(a, b rawEntry) int { return int(a.tag) - int(b.tag) })
+
+	valueOffset := len(out) + 2 + 12*len(entries) + 4
+	var values []byte
+	out = t.order.AppendUint16(out, uint16(len(entries)))
+	for _, e := range entries {
+		out = t.order.AppendUint16(out, e.tag)
+		out = t.order.AppendUint16(out, e.typ)
+		out = t.order.AppendUint32(out, e.count)
+		if len(e.data) <= 4 {
+			var inline [4]byte
+			copy(inline[:], e.data)
+			out = append(out, inline[:]...)
+			continue
+		}
+		out = t.order.AppendUint32(out, uint32(valueOffset+len(values)))
+		values = append(values, e.data...)
+		// Values start on word boundaries
+		if len(e.data)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	out = t.order.AppendUint32(out, 0) // no next IFD
+	return append(out, values...)
+}
+
+// Embed returns an encoded JPEG or PNG with the metadata in e inserted after
+// its header. EXIF that does not fit in a JPEG segment is left out.
+func Embed(encoded []byte, e Embedded) ([]byte, error) {
+	if e.EXIF == nil && e.ICC == nil {
+		return encoded, nil
+	}
+	switch imageformat.Detect(encoded) {
+	case imageformat.JPEG:
+		return embedJPEG(encoded, e), nil
+	case imageformat.PNG:
+		return embedPNG(encoded, e)
+	}
+	return nil, errors.New("metadata can only be embedded in JPEG and PNG")
+}
+
+// maxJPEGSegment is the largest payload a JPEG segment can hold
+const maxJPEGSegment = 65533
+
+func embedJPEG(encoded []byte, e Embedded) []byte {
+	var buf bytes.Buffer
+	buf.Write(encoded[:2]) // SOI
+	if e.EXIF != nil && len(exifHeader)+len(e.EXIF) <= maxJPEGSegment {
+		writeSegment(&buf, 0xE1, exifHeader, e.EXIF)
+	}
+	// Profiles are split into numbered APP2 chunks
+	chunkSize := maxJPEGSegment - len(iccHeader) - 2
+	chunks := (len(e.ICC) + chunkSize - 1) / chunkSize
+	if chunks <= 255 {
+		for i := 0; i < chunks; i++ {
+			chunk := e.ICC[i*chunkSize : min((i+1)*chunkSize, len(e.ICC))]
+			writeSegment(&buf, 0xE2, append(slices.Clone(iccHeader), byte(i+1), byte(chunks)), chunk)
+		}
+	}
+	buf.Write(encoded[2:])
+	return buf.Bytes()
+}
+
+func writeSegment(buf *bytes.Buffer, marker byte, header, payload []byte) {
+	buf.Write([]byte{0xFF, marker})
+	binary.Write(buf, binary.BigEndian, uint16(2+len(header)+len(payload)))
+	buf.Write(header)
+	buf.Write(payload)
+}
+
+// pngHeaderSize covers the signature and the IHDR chunk, after which the
+// profile and EXIF chunks go
+const pngHeaderSize = 8 + 8 + 13 + 4
+
+func embedPNG(encoded []byte, e Embedded) ([]byte, error) {
+	if len(encoded) < pngHeaderSize || string(encoded[12:16]) != "IHDR" {
+		return nil, errors.New("PNG does not start with IHDR")
+	}
+	var buf bytes.Buffer
+	buf.Write(encoded[:pngHeaderSize])
+	if e.ICC != nil {
+		var payload bytes.Buffer
+		payload.WriteString("ICC Profile\x00\x00")
+		zw := zlib.NewWriter(&payload)
+		zw.Write(e.ICC)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		writeChunk(&buf, "iCCP", payload.Bytes())
+	}
+	if e.EXIF != nil {
+		writeChunk(&buf, "eXIf", e.EXIF)
+	}
+	buf.Write(encoded[pngHeaderSize:])
+	return buf.Bytes(), nil
+}
+
+func writeChunk(buf *bytes.Buffer, kind string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	buf.WriteString(kind)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
@@ -0,0 +1,334 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxEntries bounds the entries read from one IFD
+	maxEntries = 512
+	// maxValue bounds the bytes read for one tag; larger values such as
+	// thumbnails and maker notes are left out
+	maxValue = 4096
+)
+
+// Tags of IFD0 that are handled specially
+const (
+	tagExifIFD = 0x8769
+	tagGPSIFD  = 0x8825
+	// tagICCProfile holds an embedded color profile in TIFF files
+	tagICCProfile = 0x8773
+)
+
+// tagNames lists the tags kept from each IFD. Unknown tags are ignored so the
+// stored blob only holds descriptive values.
+var tagNames = map[string]map[uint16]string{
+	"ifd0": {
+		0x010E: "ImageDescription",
+		0x010F: "Make",
+		0x0110: "Model",
+		0x0112: "Orientation",
+		0x011A: "XResolution",
+		0x011B: "YResolution",
+		0x0128: "ResolutionUnit",
+		0x0131: "Software",
+		0x0132: "DateTime",
+		0x013B: "Artist",
+		0x8298: "Copyright",
+		0x8773: "InterColorProfile",
+	},
+	"exif": {
+		0x829A: "ExposureTime",
+		0x829D: "FNumber",
+		0x8822: "ExposureProgram",
+		0x8827: "ISOSpeedRatings",
+		0x9000: "ExifVersion",
+		0x9003: "DateTimeOriginal",
+		0x9004: "DateTimeDigitized",
+		0x9010: "OffsetTime",
+		0x9011: "OffsetTimeOriginal",
+		0x9012: "OffsetTimeDigitized",
+		0x9201: "ShutterSpeedValue",
+		0x9202: "ApertureValue",
+		0x9204: "ExposureBiasValue",
+		0x9207: "MeteringMode",
+		0x9209: "Flash",
+		0x920A: "FocalLength",
+		0x9291: "SubSecTimeOriginal",
+		0xA001: "ColorSpace",
+		0xA002: "PixelXDimension",
+		0xA003: "PixelYDimension",
+		0xA402: "ExposureMode",
+		0xA403: "WhiteBalance",
+		0xA405: "FocalLengthIn35mmFilm",
+		0xA406: "SceneCaptureType",
+		0xA431: "BodySerialNumber",
+		0xA432: "LensSpecification",
+		0xA433: "LensMake",
+		0xA434: "LensModel",
+	},
+	"gps": {
+		0x0000: "GPSVersionID",
+		0x0001: "GPSLatitudeRef",
+		0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef",
+		0x0004: "GPSLongitude",
+		0x0005: "GPSAltitudeRef",
+		0x0006: "GPSAltitude",
+		0x0007: "GPSTimeStamp",
+		0x0010: "GPSImgDirectionRef",
+		0x0011: "GPSImgDirection",
+		0x001D: "GPSDateStamp",
+	},
+}
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeSByte     = 6
+	typeUndefined = 7
+	typeSShort    = 8
+	typeSLong     = 9
+	typeSRational = 10
+	typeFloat     = 11
+	typeDouble    = 12
+)
+
+var typeSizes = map[uint16]int{
+	typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8, typeSByte: 1,
+	typeUndefined: 1, typeSShort: 2, typeSLong: 4, typeSRational: 8, typeFloat: 4, typeDouble: 8,
+}
+
+// byteOrder reads and appends values in the byte order of a TIFF structure
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+type tiffReader struct {
+	r     io.ReaderAt
+	order byteOrder
+}
+
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // the four bytes holding the value or its offset
+}
+
+// newTIFFReader reads the header of a TIFF structure and returns the offset
+// of IFD0
+func newTIFFReader(r io.ReaderAt) (tiffReader, int64, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return tiffReader{}, 0, err
+	}
+	t := tiffReader{r: r}
+	switch string(header[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return t, 0, errors.New("invalid TIFF header")
+	}
+	return t, int64(t.order.Uint32(header[4:])), nil
+}
+
+// readEXIF reads the known tags of IFD0 and the EXIF and GPS IFDs it points to
+func readEXIF(r io.ReaderAt) (exifData, error) {
+	t, offset, err := newTIFFReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(exifData)
+	entries, err := t.readIFD(offset)
+	if err != nil {
+		return nil, err
+	}
+	data["ifd0"] = t.tags(entries, tagNames["ifd0"])
+
+	for _, e := range entries {
+		ifd := ""
+		switch e.tag {
+		case tagExifIFD:
+			ifd = "exif"
+		case tagGPSIFD:
+			ifd = "gps"
+		default:
+			continue
+		}
+		// A broken sub-IFD does not spoil the tags already read
+		sub, err := t.readIFD(int64(t.order.Uint32(e.value)))
+		if err == nil {
+			data[ifd] = t.tags(sub, tagNames[ifd])
+		}
+	}
+	return data, nil
+}
+
+func (t tiffReader) readIFD(offset int64) ([]entry, error) {
+	if offset < 8 {
+		return nil, fmt.Errorf("invalid IFD offset %d", offset)
+	}
+	var count [2]byte
+	if _, err := t.r.ReadAt(count[:], offset); err != nil {
+		return nil, err
+	}
+	n := int(t.order.Uint16(count[:]))
+	if n > maxEntries {
+		return nil, fmt.Errorf("IFD has %d entries", n)
+	}
+
+	buf := make([]byte, 12*n)
+	if _, err := t.r.ReadAt(buf, offset+2); err != nil {
+		return nil, err
+	}
+	entries := make([]entry, n)
+	for i := range entries {
+		b := buf[12*i:]
+		entries[i] = entry{
+			tag:   t.order.Uint16(b),
+			typ:   t.order.Uint16(b[2:]),
+			count: t.order.Uint32(b[4:]),
+			value: b[8:12],
+		}
+	}
+	return entries, nil
+}
+
+// tags decodes the entries named in names, skipping those that cannot be read
+func (t tiffReader) tags(entries []entry, names map[uint16]string) map[string]any {
+	tags := make(map[string]any)
+	for _, e := range entries {
+		name, ok := names[e.tag]
+		if !ok {
+			continue
+		}
+		// Only the presence of a color profile is recorded
+		if e.tag == tagICCProfile {
+			tags[name] = true
+			continue
+		}
+		if value := t.decode(e); value != nil {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
+// raw returns the bytes of an entry's value, in the byte order of the file
+func (t tiffReader) raw(e entry) ([]byte, error) {
+	size := typeSizes[e.typ]
+	if size == 0 {
+		return nil, fmt.Errorf("tag 0x%04X has unknown type %d", e.tag, e.typ)
+	}
+	n := int64(e.count) * int64(size)
+	if n > maxProfile {
+		return nil, fmt.Errorf("tag 0x%04X is too large", e.tag)
+	}
+	raw := make([]byte, n)
+	if n <= 4 {
+		copy(raw, e.value)
+		return raw, nil
+	}
+	if _, err := t.r.ReadAt(raw, int64(t.order.Uint32(e.value))); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// decode returns the value of an entry as a string, number or list of
+// numbers, or nil if it is unreadable or too large
+func (t tiffReader) decode(e entry) any {
+	size := typeSizes[e.typ]
+	if size == 0 || e.count == 0 || int64(e.count)*int64(size) > maxValue {
+		return nil
+	}
+	raw, err := t.raw(e)
+	if err != nil {
+		return nil
+	}
+
+	switch e.typ {
+	case typeASCII:
+		return text(raw)
+	case typeUndefined:
+		// Undefined values are kept only when they are text, such as ExifVersion
+		for _, c := range raw {
+			if c != 0 && (c < 0x20 || c > 0x7E) {
+				return nil
+			}
+		}
+		return text(raw)
+	}
+
+	values := make([]any, e.count)
+	for i := range values {
+		b := raw[i*size:]
+		switch e.typ {
+		case typeByte:
+			values[i] = int64(b[0])
+		case typeSByte:
+			values[i] = int64(int8(b[0]))
+		case typeShort:
+			values[i] = int64(t.order.Uint16(b))
+		case typeSShort:
+			values[i] = int64(int16(t.order.Uint16(b)))
+		case typeLong:
+			values[i] = int64(t.order.Uint32(b))
+		case typeSLong:
+			values[i] = int64(int32(t.order.Uint32(b)))
+		case typeRational:
+			num, den := t.order.Uint32(b), t.order.Uint32(b[4:])
+			if den == 0 {
+				return nil
+			}
+			values[i] = float64(num) / float64(den)
+		case typeSRational:
+			num, den := int32(t.order.Uint32(b)), int32(t.order.Uint32(b[4:]))
+			if den == 0 {
+				return nil
+			}
+			values[i] = float64(num) / float64(den)
+		case typeFloat:
+			values[i] = float64(math.Float32frombits(t.order.Uint32(b)))
+		case typeDouble:
+			values[i] = math.Float64frombits(t.order.Uint64(b))
+		}
+		// JSON cannot represent these
+		if f, ok := values[i].(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return nil
+		}
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// text trims the padding of a string value and makes it valid UTF-8, which
+// JSONB requires
+func text(raw []byte) any {
+	s := strings.TrimSpace(strings.TrimRight(string(raw), "\x00"))
+	s = strings.ReplaceAll(s, "\x00", "")
+	if s == "" {
+		return nil
+	}
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "�")
+	}
+	return s
+}
@@ -0,0 +1,360 @@
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"image-processor/internal/models"
+
+	_ "golang.org/x/image/tiff"
+)
+
+const (
+	// maxSegment bounds how much of a PNG chunk is read while looking for
+	// EXIF and color profiles; JPEG segments are limited to 64KB by the format
+	maxSegment = 1 << 20
+	// maxProfile bounds the size of an ICC profile
+	maxProfile = 4 << 20
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+// Extract reads the properties of an original. Dimensions come from the image
+// header and must be readable; EXIF that cannot be parsed is left out rather
+// than failing the extraction.
+func Extract(r io.ReadSeeker, size int64) (models.ImageMetadata, error) {
+	meta := models.ImageMetadata{Size: size}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return meta, err
+	}
+	config, format, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return meta, fmt.Errorf("failed to read image header: %w", err)
+	}
+	meta.Width, meta.Height, meta.Format = config.Width, config.Height, format
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return meta, err
+	}
+	segments, err := scan(r, format)
+	meta.HasICCProfile = segments.icc != nil
+	if err != nil || segments.exif == nil {
+		return meta, nil
+	}
+
+	exif, err := readEXIF(segments.exif)
+	if err != nil {
+		return meta, nil
+	}
+	exif.apply(&meta)
+	return meta, nil
+}
+
+// segments are the parts of a file that hold metadata. What was found before
+// an error is still returned.
+type segments struct {
+	exif io.ReaderAt // TIFF structure holding EXIF; nil if absent
+	icc  []byte      // ICC profile; nil if absent or incomplete
+}
+
+// scan finds the metadata of a file in format, as reported by image.DecodeConfig
+func scan(r io.ReadSeeker, format string) (segments, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return segments{}, err
+	}
+	switch format {
+	case "jpeg":
+		return scanJPEG(bufio.NewReader(r))
+	case "png":
+		return scanPNG(r)
+	case "tiff":
+		return scanTIFF(seekReaderAt{r})
+	}
+	return segments{}, nil
+}
+
+// scanJPEG walks the segments before the image data, reading the TIFF
+// structure of an APP1 EXIF segment and the ICC profile split across APP2
+// segments
+func scanJPEG(r *bufio.Reader) (segments, error) {
+	var found segments
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return found, errors.New("not a JPEG")
+	}
+
+	var iccChunks [][]byte
+	for {
+		marker, err := nextMarker(r)
+		if err != nil {
+			return found, err
+		}
+		// Markers without a payload
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+		// Start of scan or end of image: no metadata follows
+		if marker == 0xDA || marker == 0xD9 {
+			found.icc = joinICC(iccChunks)
+			return found, nil
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return found, err
+		}
+		n := int(binary.BigEndian.Uint16(length[:])) - 2
+		if n < 0 {
+			return found, errors.New("invalid JPEG segment length")
+		}
+		if marker != 0xE1 && marker != 0xE2 {
+			if _, err := r.Discard(n); err != nil {
+				return found, err
+			}
+			continue
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return found, err
+		}
+		switch {
+		case marker == 0xE1 && found.exif == nil && bytes.HasPrefix(payload, exifHeader):
+			found.exif = bytes.NewReader(payload[len(exifHeader):])
+		case marker == 0xE2 && bytes.HasPrefix(payload, iccHeader):
+			iccChunks = append(iccChunks, payload[len(iccHeader):])
+		}
+	}
+}
+
+// joinICC reassembles a profile from APP2 chunks, each starting with its
+// sequence number and the total number of chunks
+func joinICC(chunks [][]byte) []byte {
+	if len(chunks) == 0 || len(chunks) > 255 {
+		return nil
+	}
+	ordered := make([][]byte, len(chunks))
+	for _, chunk := range chunks {
+		if len(chunk) < 2 || int(chunk[1]) != len(chunks) || chunk[0] < 1 || int(chunk[0]) > len(chunks) {
+			return nil
+		}
+		ordered[chunk[0]-1] = chunk[2:]
+	}
+	var profile []byte
+	for _, chunk := range ordered {
+		if chunk == nil {
+			return nil
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile
+}
+
+// nextMarker skips to the next marker and returns its code
+func nextMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errors.New("invalid JPEG marker")
+	}
+	// Any number of 0xFF may pad a marker
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// scanPNG walks the chunks of a PNG, reading the TIFF structure of an eXIf
+// chunk and the profile of an iCCP chunk. Image data is skipped, not read.
+func scanPNG(r io.ReadSeeker) (segments, error) {
+	var found segments
+	if _, err := r.Seek(8, io.SeekStart); err != nil {
+		return found, err
+	}
+
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return found, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunk := string(header[4:])
+		if chunk == "IEND" {
+			return found, nil
+		}
+		if (chunk == "eXIf" && found.exif == nil || chunk == "iCCP" && found.icc == nil) && length <= maxSegment {
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return found, err
+			}
+			if chunk == "eXIf" {
+				found.exif = bytes.NewReader(payload)
+			} else {
+				found.icc = inflateICC(payload)
+			}
+			length = 0
+		}
+		// Skip the rest of the chunk and its CRC
+		if _, err := r.Seek(length+4, io.SeekCurrent); err != nil {
+			return found, err
+		}
+	}
+}
+
+// inflateICC decompresses the profile of an iCCP chunk: a name, a zero byte,
+// the compression method and the zlib stream
+func inflateICC(payload []byte) []byte {
+	name := bytes.IndexByte(payload, 0)
+	if name < 0 || name+2 > len(payload) || payload[name+1] != 0 {
+		return nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(payload[name+2:]))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	profile, err := io.ReadAll(io.LimitReader(zr, maxProfile+1))
+	if err != nil || len(profile) == 0 || len(profile) > maxProfile {
+		return nil
+	}
+	return profile
+}
+
+// scanTIFF reads the color profile of a TIFF file; the file itself is the
+// TIFF structure holding EXIF
+func scanTIFF(r io.ReaderAt) (segments, error) {
+	found := segments{exif: r}
+	t, offset, err := newTIFFReader(r)
+	if err != nil {
+		return found, err
+	}
+	entries, err := t.readIFD(offset)
+	if err != nil {
+		return found, err
+	}
+	for _, e := range entries {
+		if e.tag == tagICCProfile && e.count > 0 && e.count <= maxProfile {
+			found.icc, _ = t.raw(e)
+		}
+	}
+	return found, nil
+}
+
+// seekReaderAt reads a TIFF file in place. It moves the position of the
+// underlying reader, so it must not be used concurrently.
+type seekReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.r, p)
+}
+
+// exifData holds the known tags of each IFD by name
+type exifData map[string]map[string]any
+
+// apply copies the tags stored in typed columns to meta and keeps the rest
+// as its EXIF blob
+func (e exifData) apply(meta *models.ImageMetadata) {
+	ifd0, exif, gps := e["ifd0"], e["exif"], e["gps"]
+
+	if orientation, ok := ifd0["Orientation"].(int64); ok && orientation >= 1 && orientation <= 8 {
+		meta.Orientation = int(orientation)
+	}
+	meta.CameraMake, _ = ifd0["Make"].(string)
+	meta.CameraModel, _ = ifd0["Model"].(string)
+	if _, ok := ifd0["InterColorProfile"]; ok {
+		meta.HasICCProfile = true
+		delete(ifd0, "InterColorProfile")
+	}
+
+	for _, tags := range [][2]string{
+		{"DateTimeOriginal", "OffsetTimeOriginal"},
+		{"DateTimeDigitized", "OffsetTimeDigitized"},
+	} {
+		value, _ := exif[tags[0]].(string)
+		offset, _ := exif[tags[1]].(string)
+		if t, ok := parseDateTime(value, offset); ok {
+			meta.TakenAt = &t
+			break
+		}
+	}
+
+	lat, latOK := coordinate(gps["GPSLatitude"], gps["GPSLatitudeRef"], "S", 90)
+	lon, lonOK := coordinate(gps["GPSLongitude"], gps["GPSLongitudeRef"], "W", 180)
+	if latOK && lonOK {
+		meta.Latitude, meta.Longitude = &lat, &lon
+	}
+
+	blob := make(map[string]any)
+	for ifd, tags := range e {
+		if len(tags) > 0 {
+			blob[ifd] = tags
+		}
+	}
+	if len(blob) > 0 {
+		meta.EXIF = blob
+	}
+}
+
+// parseDateTime parses an EXIF date such as "2024:05:01 14:03:22". Cameras
+// that record no UTC offset are assumed to be set to UTC.
+func parseDateTime(value, offset string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t.UTC(), true
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil || t.Year() < 1800 {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// coordinate converts degrees, minutes and seconds to signed decimal degrees
+func coordinate(value, ref any, negative string, limit float64) (float64, bool) {
+	dms, ok := value.([]any)
+	if !ok || len(dms) != 3 {
+		return 0, false
+	}
+	var parts [3]float64
+	for i, v := range dms {
+		if parts[i], ok = v.(float64); !ok {
+			return 0, false
+		}
+	}
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+	if r, _ := ref.(string); strings.EqualFold(r, negative) {
+		degrees = -degrees
+	}
+	if math.IsNaN(degrees) || math.Abs(degrees) > limit {
+		return 0, false
+	}
+	return degrees, true
+}
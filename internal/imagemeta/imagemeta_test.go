@@ -0,0 +1,122 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+// testTIFF returns a little-endian TIFF structure with Make, Orientation
+// and an EXIF IFD holding DateTimeOriginal
+func testTIFF() []byte {
+	le := binary.LittleEndian
+	entry := func(b []byte, tag, typ uint16, count, value uint32) []byte {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, count)
+		return le.AppendUint32(b, value)
+	}
+
+	b := []byte("II*\x00")
+	b = le.AppendUint32(b, 8)
+	// IFD0 at 8 with three entries ends at 50, followed by the make
+	b = le.AppendUint16(b, 3)
+	b = entry(b, 0x010F, typeASCII, 6, 50)
+	b = entry(b, 0x0112, typeShort, 1, 6)
+	b = entry(b, tagExifIFD, typeLong, 1, 56)
+	b = le.AppendUint32(b, 0)
+	b = append(b, "Canon\x00"...)
+	// The EXIF IFD at 56 with one entry ends at 74, followed by the date
+	b = le.AppendUint16(b, 1)
+	b = entry(b, 0x9003, typeASCII, 20, 74)
+	b = le.AppendUint32(b, 0)
+	return append(b, "2024:05:01 12:00:00\x00"...)
+}
+
+// testJPEG returns a 16x12 JPEG whose APP1 segment holds tiff as EXIF
+func testJPEG(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 16, 12)), nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(exifHeader)+len(tiff)))
+	segment = append(append(segment, exifHeader...), tiff...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte(nil), data[:2]...), segment...), data[2:]...)
+}
+
+func TestExtractReadsEXIF(t *testing.T) {
+	data := testJPEG(t, testTIFF())
+	meta, err := Extract(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if meta.Width != 16 || meta.Height != 12 || meta.Format != "jpeg" {
+		t.Errorf("got %dx%d %s, want 16x12 jpeg", meta.Width, meta.Height, meta.Format)
+	}
+	if meta.CameraMake != "Canon" || meta.Orientation != 6 || meta.TakenAt == nil {
+		t.Errorf("got make %q, orientation %d and taken at %v, want Canon, 6 and a time", meta.CameraMake, meta.Orientation, meta.TakenAt)
+	}
+}
+
+func TestExtractSurvivesMalformedEXIF(t *testing.T) {
+	le := binary.LittleEndian
+	valid := testTIFF()
+	patched := func(offset int, value uint32, size int) []byte {
+		tiff := append([]byte(nil), valid...)
+		if size == 2 {
+			le.PutUint16(tiff[offset:], uint16(value))
+		} else {
+			le.PutUint32(tiff[offset:], value)
+		}
+		return tiff
+	}
+
+	cases := map[string][]byte{
+		"IFD0 past the end":          patched(4, 0xFFFFFFF0, 4),
+		"IFD0 inside the header":     patched(4, 2, 4),
+		"too many entries":           patched(8, 0xFFFF, 2),
+		"entries past the end":       patched(8, 200, 2),
+		"value past the end":         patched(10+8, 0xFFFFFFF0, 4),
+		"huge count":                 patched(10+4, 0xFFFFFFFF, 4),
+		"unknown type":               patched(10+2, 0xBEEF, 2),
+		"EXIF IFD past the end":      patched(10+24+8, 0xFFFFFFF0, 4),
+		"EXIF IFD pointing at IFD0":  patched(10+24+8, 8, 4),
+		"EXIF IFD inside the header": patched(10+24+8, 0, 4),
+		"bad byte order":             append([]byte("XX"), valid[2:]...),
+	}
+	for n := range len(valid) {
+		cases[fmt.Sprintf("truncated to %d bytes", n)] = valid[:n]
+	}
+	random := rand.New(rand.NewSource(1))
+	for i := range 200 {
+		tiff := append([]byte(nil), valid...)
+		for range 4 {
+			tiff[random.Intn(len(tiff))] = byte(random.Intn(256))
+		}
+		cases[fmt.Sprintf("random corruption %d", i)] = tiff
+	}
+
+	for name, tiff := range cases {
+		data := testJPEG(t, tiff)
+		meta, err := Extract(bytes.NewReader(data), int64(len(data)))
+		if err != nil || meta.Width != 16 || meta.Height != 12 {
+			t.Errorf("%s: got %dx%d (%v), want the dimensions despite the EXIF", name, meta.Width, meta.Height, err)
+		}
+	}
+}
+
+func TestExtractSurvivesTruncatedFiles(t *testing.T) {
+	data := testJPEG(t, testTIFF())
+	for n := range len(data) {
+		// Most prefixes cannot be decoded; they must fail, not panic
+		Extract(bytes.NewReader(data[:n]), int64(n))
+	}
+}
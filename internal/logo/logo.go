@@ -0,0 +1,100 @@
+package logo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+	"image-processor/internal/transform"
+
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxSize bounds the side of a decoded logo; overlays are scaled down
+	// from it, so larger logos only cost memory
+	maxSize = 2048
+	// maxCached bounds the number of logos kept decoded
+	maxCached = 32
+)
+
+// ErrNotFound is returned for logos whose image or original does not exist
+var ErrNotFound = errors.New("logo not found")
+
+// Logo is the decoded original of an image used as an overlay
+type Logo struct {
+	Owner string
+	Image image.Image
+}
+
+// Loader decodes the originals of images used as overlay logos. Originals
+// never change, so decoded logos are kept in memory; records are still read
+// on every load so deleted logos stop being used.
+type Loader struct {
+	images    repository.ImageRepository
+	store     storage.ObjectStore
+	maxPixels int64
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]image.Image
+}
+
+// NewLoader creates a Loader that refuses logos of more than maxPixels
+// pixels; 0 allows any size
+func NewLoader(images repository.ImageRepository, store storage.ObjectStore, maxPixels int64) *Loader {
+	return &Loader{
+		images:    images,
+		store:     store,
+		maxPixels: maxPixels,
+		cache:     make(map[uuid.UUID]image.Image),
+	}
+}
+
+// Load returns the logo stored as the original of image id, or ErrNotFound
+func (l *Loader) Load(ctx context.Context, id uuid.UUID) (*Logo, error) {
+	record, err := l.images.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) || err == nil && (record.OriginalKey == "" || record.Status == models.ImageStatusUploading) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	img, ok := l.cache[id]
+	l.mu.Unlock()
+	if ok {
+		return &Logo{Owner: record.Owner, Image: img}, nil
+	}
+
+	obj, _, err := l.store.OpenFile(ctx, record.BucketName, record.OriginalKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	defer obj.Close()
+	if err := transform.CheckPixels(obj, l.maxPixels); err != nil {
+		return nil, fmt.Errorf("failed to decode logo: %w", err)
+	}
+	img, err = transform.Decode(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode logo: %w", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() > maxSize || bounds.Dy() > maxSize {
+		img = imaging.Fit(img, maxSize, maxSize, imaging.Lanczos)
+	}
+
+	l.mu.Lock()
+	// The cache is emptied rather than tracking use; logos are few
+	if len(l.cache) >= maxCached {
+		clear(l.cache)
+	}
+	l.cache[id] = img
+	l.mu.Unlock()
+	return &Logo{Owner: record.Owner, Image: img}, nil
+}
@@ -9,17 +9,92 @@ import (
 type ImageStatus string
 
 const (
+	// ImageStatusUploading marks a record whose original is being uploaded
+	// directly to storage and has not been queued yet
+	ImageStatusUploading  ImageStatus = "uploading"
 	ImageStatusPending    ImageStatus = "pending"
 	ImageStatusProcessing ImageStatus = "processing"
 	ImageStatusCompleted  ImageStatus = "completed"
 	ImageStatusFailed     ImageStatus = "failed"
+	// ImageStatusExpired marks a completed image whose processed outputs
+	// were removed by the retention janitor
+	ImageStatusExpired ImageStatus = "expired"
 )
 
 type Image struct {
-	ID         uuid.UUID   `json:"id" db:"id"`
-	Filename   string      `json:"filename" db:"filename"`
-	Status     ImageStatus `json:"status" db:"status"`
-	BucketName string      `json:"bucket_name" db:"bucket_name"`
-	CreatedAt  time.Time   `json:"created_at" db:"created_at"`
-	UpdatedAt  time.Time   `json:"updated_at" db:"updated_at"`
+	ID              uuid.UUID   `json:"id" db:"id"`
+	Filename        string      `json:"filename" db:"filename"`
+	Status          ImageStatus `json:"status" db:"status"`
+	BucketName      string      `json:"bucket_name" db:"bucket_name"`
+	OriginalKey     string      `json:"original_key" db:"original_key"` // empty until an imported original is stored
+	ProcessedBucket string      `json:"processed_bucket" db:"processed_bucket"`
+	ProcessedKey    string      `json:"processed_key" db:"processed_key"` // empty until processing completes
+	Owner           string      `json:"owner" db:"owner"`                 // empty when uploaded without authentication
+	CallbackURL     string      `json:"callback_url" db:"callback_url"`
+	CallbackSecret  string      `json:"-" db:"callback_secret"` // signs deliveries to CallbackURL
+	Error           string      `json:"error" db:"error"`       // why processing failed
+	Checksum        string      `json:"checksum" db:"checksum"` // hex SHA-256 of the original; empty until known
+	Pipeline        string      `json:"pipeline" db:"pipeline"` // transform options key of the processed object
+	CreatedAt       time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at" db:"updated_at"`
+	AccessedAt      *time.Time  `json:"accessed_at" db:"accessed_at"` // last time its content was served
+	DeletedAt       *time.Time  `json:"deleted_at" db:"deleted_at"`   // set when deleted; purged after a grace period
+
+	ImageMetadata
+	Placeholder
+}
+
+// ImageMetadata describes an original as read by the worker. Zero values
+// mean unknown: the original has not been read yet, or the file does not
+// record the property.
+type ImageMetadata struct {
+	// Width and Height are the stored pixel dimensions, before Orientation
+	// is applied
+	Width         int            `json:"width" db:"width"`
+	Height        int            `json:"height" db:"height"`
+	Format        string         `json:"format" db:"format"`           // jpeg, png or tiff
+	Size          int64          `json:"size" db:"size_bytes"`         // bytes
+	Orientation   int            `json:"orientation" db:"orientation"` // EXIF orientation, 1-8
+	CameraMake    string         `json:"camera_make" db:"camera_make"`
+	CameraModel   string         `json:"camera_model" db:"camera_model"`
+	TakenAt       *time.Time     `json:"taken_at" db:"taken_at"`
+	Latitude      *float64       `json:"gps_latitude" db:"gps_latitude"`
+	Longitude     *float64       `json:"gps_longitude" db:"gps_longitude"`
+	HasICCProfile bool           `json:"has_icc_profile" db:"has_icc_profile"`
+	EXIF          map[string]any `json:"exif" db:"exif"` // known tags by IFD: ifd0, exif and gps
+}
+
+// Placeholder is what clients show while an image loads. Both fields are
+// empty until the worker has decoded the original.
+type Placeholder struct {
+	BlurHash string   `json:"blurhash" db:"blurhash"`
+	Palette  []string `json:"palette" db:"palette"` // dominant colors as #rrggbb, most common first
+}
+
+// Perceptual hash algorithms
+const (
+	HashAverage    = "ahash"
+	HashDifference = "dhash"
+	HashDCT        = "phash"
+)
+
+// PerceptualHashes fingerprint how an image looks. Images that look alike
+// have hashes a small Hamming distance apart.
+type PerceptualHashes struct {
+	AHash uint64 // average hash
+	DHash uint64 // difference hash
+	PHash uint64 // DCT hash
+}
+
+// Hash returns the hash computed by algorithm
+func (h PerceptualHashes) Hash(algorithm string) (uint64, bool) {
+	switch algorithm {
+	case HashAverage:
+		return h.AHash, true
+	case HashDifference:
+		return h.DHash, true
+	case HashDCT:
+		return h.PHash, true
+	}
+	return 0, false
 }
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadSession tracks an unfinished upload until it completes or expires.
+// Resumable uploads are backed by a Minio multipart upload; presigned
+// uploads have an empty UploadID because the client writes the object.
+type UploadSession struct {
+	ImageID     uuid.UUID `json:"image_id" db:"image_id"`
+	UploadID    string    `json:"upload_id" db:"upload_id"`
+	BucketName  string    `json:"bucket_name" db:"bucket_name"`
+	ObjectName  string    `json:"object_name" db:"object_name"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	TotalSize   int64     `json:"total_size" db:"total_size"`
+	Offset      int64     `json:"upload_offset" db:"upload_offset"`
+	PartETags   []string  `json:"part_etags" db:"part_etags"`
+	// HashState is the serialised SHA-256 of the bytes received so far
+	HashState []byte    `json:"-" db:"hash_state"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
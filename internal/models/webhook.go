@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusDelivered DeliveryStatus = "delivered"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+// WebhookSubscription receives callbacks for every image of an account
+type WebhookSubscription struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	Owner     string    `json:"owner" db:"owner"`
+	URL       string    `json:"url" db:"url"`
+	Secret    string    `json:"-" db:"secret"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// WebhookDelivery is one callback POST and its retry state
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id" db:"id"`
+	ImageID        uuid.UUID       `json:"image_id" db:"image_id"`
+	SubscriptionID *uuid.UUID      `json:"subscription_id,omitempty" db:"subscription_id"`
+	Owner          string          `json:"owner" db:"owner"`
+	URL            string          `json:"url" db:"url"`
+	Event          string          `json:"event" db:"event"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         DeliveryStatus  `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	LastError      string          `json:"last_error,omitempty" db:"last_error"`
+	ResponseCode   int             `json:"response_code,omitempty" db:"response_code"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,89 @@
+package netguard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsBlocked(t *testing.T) {
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:4700:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := IsBlocked(netip.MustParseAddr(tt.addr)); got != tt.blocked {
+			t.Errorf("IsBlocked(%s) = %v, want %v", tt.addr, got, tt.blocked)
+		}
+	}
+}
+
+func TestTransportRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Names are checked after they are resolved
+	for _, url := range []string{server.URL, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)} {
+		client := &http.Client{Transport: NewTransport(false)}
+		if _, err := client.Get(url); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("GET %s returned %v, want ErrBlockedAddress", url, err)
+		}
+	}
+
+	client := &http.Client{Transport: NewTransport(true)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET with private addresses allowed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+// redirector answers requests to a public host with a redirect and passes
+// the others on to the guarded transport
+type redirector struct {
+	location string
+	next     http.RoundTripper
+}
+
+func (r redirector) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "images.example" {
+		return r.next.RoundTrip(req)
+	}
+	rec := httptest.NewRecorder()
+	http.Redirect(rec, req, r.location, http.StatusFound)
+	return rec.Result(), nil
+}
+
+func TestTransportRefusesRedirectsToLoopback(t *testing.T) {
+	var reached atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached.Store(true) }))
+	defer server.Close()
+
+	client := &http.Client{Transport: redirector{location: server.URL + "/admin", next: NewTransport(false)}}
+	if _, err := client.Get("http://images.example/photo.jpg"); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("following a redirect to loopback returned %v, want ErrBlockedAddress", err)
+	}
+	if reached.Load() {
+		t.Error("the redirect target was reached")
+	}
+}
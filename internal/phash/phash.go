@@ -0,0 +1,133 @@
+package phash
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"slices"
+
+	"image-processor/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// sampleSize is the side of the grayscale thumbnail every hash is computed
+// from; hashes ignore the aspect ratio
+const sampleSize = 64
+
+// dctSize is the side of the image the DCT of the perceptual hash runs on
+const dctSize = 32
+
+// Compute returns the average, difference and DCT hashes of img. Images that
+// look alike have hashes a small Hamming distance apart, whatever their size
+// or encoding.
+func Compute(img image.Image) models.PerceptualHashes {
+	sample := imaging.Grayscale(imaging.Resize(img, sampleSize, sampleSize, imaging.Linear))
+	return models.PerceptualHashes{
+		AHash: averageHash(sample),
+		DHash: differenceHash(sample),
+		PHash: dctHash(sample),
+	}
+}
+
+// Distance returns the number of bits in which two hashes differ
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// gray shrinks a grayscale image to w x h and returns its luminance, row by row
+func gray(img *image.NRGBA, w, h int) []float64 {
+	small := imaging.Resize(img, w, h, imaging.Box)
+	values := make([]float64, 0, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			values = append(values, float64(small.Pix[y*small.Stride+x*4]))
+		}
+	}
+	return values
+}
+
+// averageHash sets a bit for each of 8x8 pixels brighter than their mean
+func averageHash(img *image.NRGBA) uint64 {
+	values := gray(img, 8, 8)
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	return threshold(values, mean)
+}
+
+// differenceHash sets a bit for each pixel of a 9x8 image that is darker
+// than its right neighbour
+func differenceHash(img *image.NRGBA) uint64 {
+	values := gray(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if values[y*9+x] < values[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// dctHash sets a bit for each of the 8x8 lowest frequencies of a 32x32 DCT
+// that is above their median
+func dctHash(img *image.NRGBA) uint64 {
+	values := gray(img, dctSize, dctSize)
+	coefficients := dct2D(values, dctSize)
+
+	low := make([]float64, 0, 64)
+	for y := 0; y < 8; y++ {
+		low = append(low, coefficients[y*dctSize:y*dctSize+8]...)
+	}
+	sorted := slices.Clone(low)
+	slices.Sort(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	return threshold(low, median)
+}
+
+// threshold sets one bit per value, most significant first, for values above t
+func threshold(values []float64, t float64) uint64 {
+	var hash uint64
+	for _, v := range values {
+		hash <<= 1
+		if v > t {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// dct2D computes the type-II discrete cosine transform of an n x n matrix,
+// rows first and then columns
+func dct2D(values []float64, n int) []float64 {
+	cos := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cos[k*n+i] = math.Cos(math.Pi / float64(n) * (float64(i) + 0.5) * float64(k))
+		}
+	}
+	transform := func(in []float64, stride int, out []float64) {
+		for k := 0; k < n; k++ {
+			sum := 0.0
+			for i := 0; i < n; i++ {
+				sum += in[i*stride] * cos[k*n+i]
+			}
+			out[k*stride] = sum
+		}
+	}
+
+	rows := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		transform(values[y*n:], 1, rows[y*n:])
+	}
+	result := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		transform(rows[x:], n, result[x:])
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package phash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"testing"
+
+	"image-processor/internal/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// scene draws a 256x192 image of overlapping soft blobs placed by seed,
+// which has structure at several scales like a photo
+func scene(seed int64) *image.NRGBA {
+	random := rand.New(rand.NewSource(seed))
+	type blob struct{ x, y, r, v float64 }
+	blobs := make([]blob, 16)
+	for i := range blobs {
+		blobs[i] = blob{random.Float64() * 256, random.Float64() * 192, 10 + random.Float64()*50, random.Float64()*2 - 1}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 192))
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			v := 0.5
+			for _, b := range blobs {
+				dx, dy := float64(x)-b.x, float64(y)-b.y
+				v += 0.4 * b.v * math.Exp(-(dx*dx+dy*dy)/(2*b.r*b.r))
+			}
+			c := uint8(math.Max(0, math.Min(255, v*255)))
+			img.SetNRGBA(x, y, color.NRGBA{c, c, c, 255})
+		}
+	}
+	return img
+}
+
+func distances(a, b models.PerceptualHashes) [3]int {
+	return [3]int{Distance(a.AHash, b.AHash), Distance(a.DHash, b.DHash), Distance(a.PHash, b.PHash)}
+}
+
+func TestNearIdenticalImagesHaveCloseHashes(t *testing.T) {
+	original := scene(1)
+	var recompressed bytes.Buffer
+	if err := jpeg.Encode(&recompressed, original, &jpeg.Options{Quality: 50}); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	decoded, err := jpeg.Decode(&recompressed)
+	if err != nil {
+		t.Fatalf("failed to decode jpeg: %v", err)
+	}
+
+	hashes := Compute(original)
+	for name, variant := range map[string]image.Image{
+		"downscaled":   imaging.Resize(original, 128, 96, imaging.Lanczos),
+		"recompressed": decoded,
+		"brightened":   imaging.AdjustBrightness(original, 5),
+	} {
+		for i, d := range distances(hashes, Compute(variant)) {
+			if d > 6 {
+				t.Errorf("%s: hash %d is %d bits away, want at most 6", name, i, d)
+			}
+		}
+	}
+}
+
+func TestDifferentImagesHaveDistantHashes(t *testing.T) {
+	a, b := Compute(scene(1)), Compute(scene(2))
+	if d := Distance(a.PHash, b.PHash); d < 16 {
+		t.Errorf("phash is %d bits away, want at least 16", d)
+	}
+}
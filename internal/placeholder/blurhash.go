@@ -0,0 +1,116 @@
+package placeholder
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// blurHashSample bounds the side of the thumbnail a BlurHash is computed
+// from; more pixels do not change the result noticeably
+const blurHashSample = 32
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash encodes img as a BlurHash (https://blurha.sh): the average color
+// and a few cosine components, 4 along the longer side and 3 along the
+// shorter. Transparency is ignored.
+func BlurHash(img image.Image) string {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return ""
+	}
+	xComponents, yComponents := 4, 3
+	if bounds.Dy() > bounds.Dx() {
+		xComponents, yComponents = 3, 4
+	}
+
+	sample := imaging.Fit(img, blurHashSample, blurHashSample, imaging.Box)
+	w, h := sample.Bounds().Dx(), sample.Bounds().Dy()
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pix := sample.Pix[y*sample.Stride+x*4:]
+			linear[y*w+x] = [3]float64{srgbToLinear(pix[0]), srgbToLinear(pix[1]), srgbToLinear(pix[2])}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var factor [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					for c := range factor {
+						factor[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+			scale := 2 / float64(w*h)
+			if i == 0 && j == 0 {
+				scale = 1 / float64(w*h)
+			}
+			for c := range factor {
+				factor[c] *= scale
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var hash strings.Builder
+	encode83(&hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 0.0
+	for _, factor := range ac {
+		for _, v := range factor {
+			maximum = math.Max(maximum, math.Abs(v))
+		}
+	}
+	quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(maximum*166-0.5))))
+	maximum = float64(quantisedMaximum+1) / 166
+	encode83(&hash, quantisedMaximum, 1)
+
+	encode83(&hash, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, factor := range ac {
+		value := 0
+		for _, v := range factor {
+			q := int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+			value = value*19 + q
+		}
+		encode83(&hash, value, 2)
+	}
+	return hash.String()
+}
+
+// encode83 appends value as length base 83 digits, most significant first
+func encode83(b *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		b.WriteByte(base83[digit])
+	}
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,137 @@
+package placeholder
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// paletteSample bounds the side of the thumbnail colors are clustered
+	// over
+	paletteSample = 64
+	// maxIterations bounds the rounds of k-means; clusters of a thumbnail
+	// usually settle in far fewer
+	maxIterations = 20
+)
+
+type cluster struct {
+	center [3]float64
+	count  int
+}
+
+// Palette returns up to k dominant colors of img as #rrggbb, most common
+// first. Colors are found by k-means over the opaque pixels of a thumbnail.
+// Images with fewer distinct colors than k get fewer colors.
+func Palette(img image.Image, k int) []string {
+	sample := imaging.Fit(img, paletteSample, paletteSample, imaging.Box)
+	bounds := sample.Bounds()
+	var pixels [][3]float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			pix := sample.Pix[y*sample.Stride+x*4:]
+			if pix[3] < 128 {
+				continue
+			}
+			pixels = append(pixels, [3]float64{float64(pix[0]), float64(pix[1]), float64(pix[2])})
+		}
+	}
+	if len(pixels) == 0 || k < 1 {
+		return nil
+	}
+
+	clusters := kmeans(pixels, k)
+	slices.SortStableFunc(clusters, func(a, b cluster) int { return b.count - a.count })
+	colors := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		if c.count > 0 {
+			colors = append(colors, fmt.Sprintf("#%02x%02x%02x",
+				uint8(math.Round(c.center[0])), uint8(math.Round(c.center[1])), uint8(math.Round(c.center[2]))))
+		}
+	}
+	return colors
+}
+
+// kmeans clusters pixels around k centers chosen by k-means++. The random
+// source has a fixed seed, so an image always gets the same palette.
+func kmeans(pixels [][3]float64, k int) []cluster {
+	rng := rand.New(rand.NewPCG(1, 2))
+	centers := [][3]float64{pixels[rng.IntN(len(pixels))]}
+	distances := make([]float64, len(pixels))
+	for len(centers) < k {
+		total := 0.0
+		for i, p := range pixels {
+			distances[i] = math.Inf(1)
+			for _, c := range centers {
+				distances[i] = math.Min(distances[i], distance(p, c))
+			}
+			total += distances[i]
+		}
+		// Every pixel is a center already
+		if total == 0 {
+			break
+		}
+		target := rng.Float64() * total
+		next := len(pixels) - 1
+		for i, d := range distances {
+			if target -= d; target < 0 {
+				next = i
+				break
+			}
+		}
+		centers = append(centers, pixels[next])
+	}
+
+	assignments := make([]int, len(pixels))
+	clusters := make([]cluster, len(centers))
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		changed := iteration == 0
+		for i, p := range pixels {
+			nearest := 0
+			for j := range centers {
+				if distance(p, centers[j]) < distance(p, centers[nearest]) {
+					nearest = j
+				}
+			}
+			if assignments[i] != nearest {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][3]float64, len(centers))
+		clear(clusters)
+		for i, p := range pixels {
+			j := assignments[i]
+			for c := range p {
+				sums[j][c] += p[c]
+			}
+			clusters[j].count++
+		}
+		// Empty clusters keep their center
+		for j := range centers {
+			if clusters[j].count > 0 {
+				for c := range sums[j] {
+					centers[j][c] = sums[j][c] / float64(clusters[j].count)
+				}
+			}
+		}
+	}
+	for j := range clusters {
+		clusters[j].center = centers[j]
+	}
+	return clusters
+}
+
+// distance is the squared Euclidean distance between two colors
+func distance(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
@@ -0,0 +1,18 @@
+package placeholder
+
+import (
+	"image"
+
+	"image-processor/internal/models"
+)
+
+// PaletteSize is the number of dominant colors computed for each image
+const PaletteSize = 5
+
+// Compute returns the BlurHash and dominant colors clients show while img loads
+func Compute(img image.Image) models.Placeholder {
+	return models.Placeholder{
+		BlurHash: BlurHash(img),
+		Palette:  Palette(img, PaletteSize),
+	}
+}
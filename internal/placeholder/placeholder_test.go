@@ -0,0 +1,78 @@
+package placeholder
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func filled(w, h int, fill func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, fill(x, y))
+		}
+	}
+	return img
+}
+
+func TestBlurHashEncodesSizeAndAverageColor(t *testing.T) {
+	solid := func(int, int) color.NRGBA { return color.NRGBA{0x33, 0x66, 0x99, 255} }
+
+	tests := []struct {
+		name       string
+		img        image.Image
+		components string // base83 of (x-1) + (y-1)*9
+	}{
+		{"landscape", filled(64, 48, solid), "L"}, // 4x3
+		{"portrait", filled(48, 64, solid), "T"},  // 3x4
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := BlurHash(tt.img)
+			// Size flag, AC range, four characters of average color and two per AC component
+			if len(hash) != 1+1+4+2*11 {
+				t.Fatalf("got %q of length %d, want 28", hash, len(hash))
+			}
+			if hash[:1] != tt.components {
+				t.Errorf("got size flag %q, want %q", hash[:1], tt.components)
+			}
+			// 0x336699 in base83
+			if hash[2:6] != "5?}k" {
+				t.Errorf("got average color %q, want %q", hash[2:6], "5?}k")
+			}
+		})
+	}
+	if got := BlurHash(image.NewNRGBA(image.Rectangle{})); got != "" {
+		t.Errorf("got %q for an empty image, want none", got)
+	}
+}
+
+func TestPaletteOrdersColorsByShare(t *testing.T) {
+	img := filled(64, 64, func(x, y int) color.NRGBA {
+		if x < 48 {
+			return color.NRGBA{0xc8, 0x1e, 0x1e, 255}
+		}
+		return color.NRGBA{0x1e, 0x1e, 0xc8, 255}
+	})
+	// Two distinct colors give two entries even when five are asked for
+	if got, want := Palette(img, PaletteSize), []string{"#c81e1e", "#1e1ec8"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPaletteIgnoresTransparentPixels(t *testing.T) {
+	img := filled(64, 64, func(x, y int) color.NRGBA {
+		if y < 32 {
+			return color.NRGBA{0x10, 0x80, 0x10, 255}
+		}
+		return color.NRGBA{0xff, 0xff, 0xff, 0}
+	})
+	if got, want := Palette(img, PaletteSize), []string{"#108010"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := Palette(image.NewNRGBA(image.Rect(0, 0, 8, 8)), PaletteSize); got != nil {
+		t.Errorf("got %v for a transparent image, want no colors", got)
+	}
+}
@@ -0,0 +1,32 @@
+// Package queue defines the tasks the gateway and the reconciler publish to
+// the processing queue and the worker consumes
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Publisher sends task messages to the processing queue
+type Publisher interface {
+	Publish(body []byte) error
+}
+
+// TaskMessage asks the worker to process an image
+type TaskMessage struct {
+	ImageID    string `json:"image_id"`
+	BucketName string `json:"bucket_name"`
+	ObjectName string `json:"object_name"`
+	// SourceURL is set for imports; the worker fetches it into BucketName
+	// and chooses ObjectName itself
+	SourceURL string `json:"source_url,omitempty"`
+}
+
+// PublishTask encodes task and sends it to the processing queue
+func PublishTask(publisher Publisher, task TaskMessage) error {
+	body, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to create task message: %w", err)
+	}
+	return publisher.Publish(body)
+}
@@ -0,0 +1,348 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"image-processor/internal/events"
+	"image-processor/internal/models"
+	"image-processor/internal/queue"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+	"image-processor/internal/webhook"
+	redisclient "image-processor/pkg/database/redis"
+
+	"github.com/google/uuid"
+)
+
+// Options control what the Reconciler changes. With neither DeleteOrphans
+// nor Repair set it only reports.
+type Options struct {
+	// MinAge skips objects and rows changed more recently, which may belong
+	// to uploads or processing still in flight
+	MinAge time.Duration
+	// DeleteOrphans deletes objects no image references
+	DeleteOrphans bool
+	// Repair re-enqueues completed images whose processed image is missing
+	// and marks images failed when their original is missing
+	Repair bool
+	// RequeuePending lets Repair re-enqueue pending and processing images
+	// whose original is intact and fail imports that never stored one.
+	// Their tasks may still be waiting in the queue, so only set it once
+	// the queue has drained.
+	RequeuePending bool
+}
+
+// Orphan is an object that no image references
+type Orphan struct {
+	Bucket string
+	Key    string
+	Size   int64
+	Reason string
+}
+
+// Problem is an image whose objects do not match its status
+type Problem struct {
+	ImageID uuid.UUID
+	Status  models.ImageStatus
+	Reason  string
+	Action  string // requeue, fail or empty when the image is only reported
+}
+
+// Report lists what a run found. Deleted and Repaired count the changes made.
+type Report struct {
+	Objects  int
+	Images   int
+	Orphans  []Orphan
+	Problems []Problem
+	Deleted  int
+	Repaired int
+}
+
+// Actions taken on problem images
+const (
+	ActionRequeue = "requeue"
+	ActionFail    = "fail"
+)
+
+// Reconciler compares the objects in storage with the images table. Failures
+// between writing an object and recording it leave objects that nothing
+// references; failures the other way round leave images pointing at objects
+// that do not exist.
+type Reconciler struct {
+	images      repository.ImageRepository
+	uploads     repository.UploadRepository
+	store       storage.ObjectStore
+	layout      *storage.Layout
+	redisClient *redisclient.Client
+	queue       queue.Publisher
+	notifier    *webhook.Notifier
+	opts        Options
+}
+
+func NewReconciler(images repository.ImageRepository, uploads repository.UploadRepository, store storage.ObjectStore, layout *storage.Layout, redis *redisclient.Client, publisher queue.Publisher, notifier *webhook.Notifier, opts Options) *Reconciler {
+	return &Reconciler{
+		images:      images,
+		uploads:     uploads,
+		store:       store,
+		layout:      layout,
+		redisClient: redis,
+		queue:       publisher,
+		notifier:    notifier,
+		opts:        opts,
+	}
+}
+
+type objectRef = repository.Object
+
+var uuidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// Run lists every bucket and image, reports the differences and, depending
+// on the options, fixes them. Objects are listed before rows are read, so an
+// object written after the listing can never be mistaken for a missing one.
+func (r *Reconciler) Run(ctx context.Context) (*Report, error) {
+	started := time.Now()
+	cutoff := started.Add(-r.opts.MinAge)
+	report := &Report{}
+
+	objects := make(map[objectRef]storage.ObjectInfo)
+	for _, bucket := range r.layout.Buckets() {
+		infos, err := r.store.ListFiles(ctx, bucket, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list bucket %s: %w", bucket, err)
+		}
+		for _, info := range infos {
+			objects[objectRef{Bucket: bucket, Key: info.Key}] = info
+		}
+	}
+	report.Objects = len(objects)
+
+	images, referenced, err := r.loadImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Images = len(images)
+
+	for ref, info := range objects {
+		if referenced[ref] || info.LastModified.After(cutoff) {
+			continue
+		}
+		reason, orphaned := r.classify(ref, info, images)
+		if !orphaned {
+			continue
+		}
+		report.Orphans = append(report.Orphans, Orphan{Bucket: ref.Bucket, Key: ref.Key, Size: info.Size, Reason: reason})
+	}
+
+	for _, img := range images {
+		if img.DeletedAt != nil || img.UpdatedAt.After(cutoff) {
+			continue
+		}
+		if problem, ok := r.check(img, objects); ok {
+			report.Problems = append(report.Problems, problem)
+		}
+	}
+
+	if r.opts.DeleteOrphans {
+		for _, orphan := range report.Orphans {
+			if err := r.store.DeleteFile(ctx, orphan.Bucket, orphan.Key); err != nil {
+				log.Printf("Failed to delete orphan %s/%s: %v", orphan.Bucket, orphan.Key, err)
+				continue
+			}
+			report.Deleted++
+		}
+	}
+	if r.opts.Repair {
+		for _, problem := range report.Problems {
+			if problem.Action == "" {
+				continue
+			}
+			if err := r.repair(ctx, images[problem.ImageID], problem); err != nil {
+				log.Printf("Failed to repair image %s: %v", problem.ImageID, err)
+				continue
+			}
+			report.Repaired++
+		}
+	}
+	return report, nil
+}
+
+// loadImages reads every image, soft-deleted ones included since their
+// objects still exist, and the set of objects referenced by images and
+// unfinished resumable uploads
+func (r *Reconciler) loadImages(ctx context.Context) (map[uuid.UUID]*models.Image, map[objectRef]bool, error) {
+	all, err := r.images.All(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	images := make(map[uuid.UUID]*models.Image, len(all))
+	referenced := make(map[objectRef]bool)
+	for i := range all {
+		img := &all[i]
+		images[img.ID] = img
+		if img.OriginalKey != "" {
+			referenced[objectRef{Bucket: img.BucketName, Key: img.OriginalKey}] = true
+		}
+		if img.ProcessedKey != "" {
+			referenced[objectRef{Bucket: img.ProcessedBucket, Key: img.ProcessedKey}] = true
+		}
+	}
+
+	uploads, err := r.uploads.Objects(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, ref := range uploads {
+		referenced[ref] = true
+	}
+	return images, referenced, nil
+}
+
+// classify decides whether an unreferenced object is an orphan. The only
+// unreferenced objects that belong to an image are its renders, which are
+// found through the image ID in the object's metadata or key.
+func (r *Reconciler) classify(ref objectRef, info storage.ObjectInfo, images map[uuid.UUID]*models.Image) (string, bool) {
+	candidates := uuidPattern.FindAllString(ref.Key, -1)
+	if info.Metadata.ImageID != "" {
+		candidates = append([]string{info.Metadata.ImageID}, candidates...)
+	}
+
+	var owner *models.Image
+	for _, candidate := range candidates {
+		id, err := uuid.Parse(candidate)
+		if err != nil {
+			continue
+		}
+		if img, ok := images[id]; ok {
+			owner = img
+			break
+		}
+	}
+	if owner == nil {
+		return "no image with this ID", true
+	}
+
+	if ref.Bucket == r.layout.ProcessedBucket {
+		prefix, ok := r.layout.RenderPrefix(storage.KeyParams{ID: owner.ID, Tenant: owner.Owner, Created: owner.CreatedAt})
+		if ok && strings.HasPrefix(ref.Key, prefix) {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("not referenced by image %s (%s)", owner.ID, owner.Status), true
+}
+
+// check compares an image's status with the objects that exist and picks the
+// repair for any mismatch: images whose original is intact are processed
+// again, the others are marked failed
+func (r *Reconciler) check(img *models.Image, objects map[objectRef]storage.ObjectInfo) (Problem, bool) {
+	problem := Problem{ImageID: img.ID, Status: img.Status}
+	hasOriginal := img.OriginalKey != ""
+	if hasOriginal {
+		_, hasOriginal = objects[objectRef{Bucket: img.BucketName, Key: img.OriginalKey}]
+	}
+
+	switch img.Status {
+	case models.ImageStatusPending, models.ImageStatusProcessing:
+		switch {
+		case img.OriginalKey == "" && !r.opts.RequeuePending:
+			// A queued import stores its original once a worker picks it up
+			problem.Reason = "import never stored its original, the task may still be queued"
+		case img.OriginalKey == "":
+			// The source URL is only part of the task, so the import cannot be retried
+			problem.Reason = "import never stored its original"
+			problem.Action = ActionFail
+		case !hasOriginal:
+			problem.Reason = fmt.Sprintf("original %s/%s is missing", img.BucketName, img.OriginalKey)
+			problem.Action = ActionFail
+		case r.opts.RequeuePending:
+			problem.Reason = "processing never finished"
+			problem.Action = ActionRequeue
+		default:
+			// Its task may still be waiting in the queue
+			problem.Reason = "processing never finished, the task may still be queued"
+		}
+		return problem, true
+
+	case models.ImageStatusCompleted:
+		_, hasProcessed := objects[objectRef{Bucket: img.ProcessedBucket, Key: img.ProcessedKey}]
+		switch {
+		case img.ProcessedKey != "" && hasProcessed && (hasOriginal || img.OriginalKey == ""):
+			return problem, false
+		case img.ProcessedKey != "" && hasProcessed:
+			// The processed image can still be served
+			problem.Reason = fmt.Sprintf("original %s/%s is missing", img.BucketName, img.OriginalKey)
+		case hasOriginal:
+			problem.Reason = fmt.Sprintf("processed image %s/%s is missing", img.ProcessedBucket, img.ProcessedKey)
+			problem.Action = ActionRequeue
+		default:
+			problem.Reason = "original and processed image are missing"
+			problem.Action = ActionFail
+		}
+		return problem, true
+
+	case models.ImageStatusFailed, models.ImageStatusExpired:
+		// Nothing is served from these, so a missing original is only reported
+		if img.OriginalKey != "" && !hasOriginal {
+			problem.Reason = fmt.Sprintf("original %s/%s is missing", img.BucketName, img.OriginalKey)
+			return problem, true
+		}
+	}
+	// Uploading images are expired by the gateway's upload sweeps
+	return problem, false
+}
+
+// repair applies a problem's action. The update only matches if the image has
+// not changed since it was read, so a worker that picked it up meanwhile wins.
+func (r *Reconciler) repair(ctx context.Context, img *models.Image, problem Problem) error {
+	var status models.ImageStatus
+	var errMsg string
+	switch problem.Action {
+	case ActionRequeue:
+		status = models.ImageStatusPending
+	case ActionFail:
+		status = models.ImageStatusFailed
+		errMsg = "Reconciliation: " + problem.Reason
+	default:
+		return nil
+	}
+
+	_, err := r.images.Transition(ctx, img.ID, repository.Transition{
+		From:      []models.ImageStatus{img.Status},
+		To:        status,
+		Error:     errMsg,
+		UpdatedAt: img.UpdatedAt,
+	})
+	if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("image changed since it was checked")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+
+	if err := r.redisClient.Delete(ctx, redisclient.ImageKey(img.ID.String())); err != nil {
+		log.Printf("Warning: failed to invalidate cache for image %s: %v", img.ID, err)
+	}
+	event := events.StatusEvent{ImageID: img.ID.String(), Status: string(status)}
+	if err := events.PublishStatus(ctx, r.redisClient, event); err != nil {
+		log.Printf("Warning: failed to publish status event for image %s: %v", img.ID, err)
+	}
+
+	if status == models.ImageStatusFailed {
+		if _, err := r.notifier.Enqueue(ctx, img.ID); err != nil {
+			log.Printf("Warning: failed to queue webhooks for image %s: %v", img.ID, err)
+		}
+		return nil
+	}
+
+	return queue.PublishTask(r.queue, queue.TaskMessage{
+		ImageID:    img.ID.String(),
+		BucketName: img.BucketName,
+		ObjectName: img.OriginalKey,
+	})
+}
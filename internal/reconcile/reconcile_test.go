@@ -0,0 +1,167 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"image-processor/internal/config"
+	"image-processor/internal/models"
+	"image-processor/internal/queue"
+	"image-processor/internal/repository/memory"
+	"image-processor/internal/storage"
+	memstore "image-processor/internal/storage/memory"
+	"image-processor/internal/webhook"
+	"image-processor/pkg/database/redis/redistest"
+
+	"github.com/google/uuid"
+)
+
+type fakeQueue struct {
+	tasks []queue.TaskMessage
+}
+
+func (q *fakeQueue) Publish(body []byte) error {
+	var task queue.TaskMessage
+	if err := json.Unmarshal(body, &task); err != nil {
+		return err
+	}
+	q.tasks = append(q.tasks, task)
+	return nil
+}
+
+type testEnv struct {
+	images  *memory.ImageRepository
+	uploads *memory.UploadRepository
+	store   *memstore.Store
+	layout  *storage.Layout
+	queue   *fakeQueue
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	layout, err := storage.NewLayout(cfg)
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+	images := memory.NewImageRepository()
+	return &testEnv{
+		images:  images,
+		uploads: memory.NewUploadRepository(images),
+		store:   memstore.NewStore(layout.Buckets()),
+		layout:  layout,
+		queue:   &fakeQueue{},
+	}
+}
+
+func (e *testEnv) run(t *testing.T, opts Options) *Report {
+	t.Helper()
+	client, _ := redistest.NewClient(t)
+	notifier := webhook.NewNotifier(e.images, memory.NewWebhookRepository(), nil, "http://gateway", time.Hour)
+	opts.MinAge = time.Hour
+	report, err := NewReconciler(e.images, e.uploads, e.store, e.layout, client, e.queue, notifier, opts).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return report
+}
+
+// image creates an image of status last changed two hours ago
+func (e *testEnv) image(t *testing.T, status models.ImageStatus, withOriginal bool) *models.Image {
+	t.Helper()
+	ctx := context.Background()
+	changed := time.Now().UTC().Add(-2 * time.Hour)
+	img := &models.Image{
+		ID:         uuid.New(),
+		Filename:   "photo.png",
+		Status:     status,
+		BucketName: e.layout.RawBucket,
+		Owner:      "alice",
+		CreatedAt:  changed,
+		UpdatedAt:  changed,
+	}
+	img.OriginalKey = e.layout.OriginalKey(storage.KeyParams{ID: img.ID, Tenant: img.Owner, Created: changed, Ext: ".png"})
+	if withOriginal {
+		if _, err := e.store.UploadFile(ctx, img.BucketName, img.OriginalKey, strings.NewReader("original"), 8, storage.PutOptions{}); err != nil {
+			t.Fatalf("failed to store original: %v", err)
+		}
+	}
+
+	if err := e.images.Create(ctx, img); err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	return img
+}
+
+func (e *testEnv) status(t *testing.T, id uuid.UUID) models.ImageStatus {
+	t.Helper()
+	img, err := e.images.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	return img.Status
+}
+
+func TestPendingImagesAreOnlyRequeuedWhenAllowed(t *testing.T) {
+	env := newTestEnv(t)
+	img := env.image(t, models.ImageStatusProcessing, true)
+
+	report := env.run(t, Options{Repair: true})
+	if len(report.Problems) != 1 || report.Problems[0].Action != "" {
+		t.Fatalf("got problems %+v, want one reported without an action", report.Problems)
+	}
+	if len(env.queue.tasks) != 0 || env.status(t, img.ID) != models.ImageStatusProcessing {
+		t.Fatalf("image was requeued without RequeuePending")
+	}
+
+	report = env.run(t, Options{Repair: true, RequeuePending: true})
+	if len(report.Problems) != 1 || report.Problems[0].Action != ActionRequeue || report.Repaired != 1 {
+		t.Fatalf("got problems %+v and %d repaired, want one requeued", report.Problems, report.Repaired)
+	}
+	want := queue.TaskMessage{ImageID: img.ID.String(), BucketName: img.BucketName, ObjectName: img.OriginalKey}
+	if len(env.queue.tasks) != 1 || env.queue.tasks[0] != want {
+		t.Errorf("got tasks %+v, want %+v", env.queue.tasks, want)
+	}
+	if status := env.status(t, img.ID); status != models.ImageStatusPending {
+		t.Errorf("got status %s, want pending", status)
+	}
+}
+
+func TestQueuedImportsAreOnlyFailedWhenAllowed(t *testing.T) {
+	env := newTestEnv(t)
+	changed := time.Now().UTC().Add(-2 * time.Hour)
+	img := &models.Image{
+		ID:         uuid.New(),
+		Filename:   "photo.png",
+		Status:     models.ImageStatusPending,
+		BucketName: env.layout.RawBucket,
+		Owner:      "alice",
+		CreatedAt:  changed,
+		UpdatedAt:  changed,
+	}
+	if err := env.images.Create(context.Background(), img); err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+
+	report := env.run(t, Options{Repair: true})
+	if len(report.Problems) != 1 || report.Problems[0].Action != "" {
+		t.Fatalf("got problems %+v, want one reported without an action", report.Problems)
+	}
+	if status := env.status(t, img.ID); status != models.ImageStatusPending {
+		t.Fatalf("got status %s without RequeuePending, want pending", status)
+	}
+
+	report = env.run(t, Options{Repair: true, RequeuePending: true})
+	if len(report.Problems) != 1 || report.Problems[0].Action != ActionFail {
+		t.Fatalf("got problems %+v, want the import failed", report.Problems)
+	}
+	if status := env.status(t, img.ID); status != models.ImageStatusFailed {
+		t.Errorf("got status %s, want failed", status)
+	}
+}
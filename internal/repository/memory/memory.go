@@ -0,0 +1,363 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var _ repository.ImageRepository = (*ImageRepository)(nil)
+
+// ImageRepository keeps images in memory. It is meant for tests; nothing is
+// persisted or shared between processes.
+type ImageRepository struct {
+	mu     sync.RWMutex
+	images map[uuid.UUID]*models.Image
+	hashes map[uuid.UUID]models.PerceptualHashes
+	// refs counts the images sharing an object, for objects shared by two
+	// or more
+	refs map[repository.Object]int
+}
+
+func NewImageRepository() *ImageRepository {
+	return &ImageRepository{
+		images: make(map[uuid.UUID]*models.Image),
+		hashes: make(map[uuid.UUID]models.PerceptualHashes),
+		refs:   make(map[repository.Object]int),
+	}
+}
+
+// Create saves a copy of image
+func (r *ImageRepository) Create(ctx context.Context, image *models.Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.create(image)
+}
+
+// create saves a copy of image. The caller must hold mu.
+func (r *ImageRepository) create(image *models.Image) error {
+	if _, ok := r.images[image.ID]; ok {
+		return fmt.Errorf("failed to create image: image %s already exists", image.ID)
+	}
+	stored := *image
+	r.images[image.ID] = &stored
+	return nil
+}
+
+// Get returns a copy of an image or repository.ErrNotFound
+func (r *ImageRepository) Get(ctx context.Context, id uuid.UUID) (*models.Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	image, err := r.image(id)
+	if err != nil {
+		return nil, err
+	}
+	found := *image
+	return &found, nil
+}
+
+// List returns images matching opts, newest first
+func (r *ImageRepository) List(ctx context.Context, opts repository.ListOptions) ([]models.Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var images []models.Image
+	for _, image := range r.images {
+		if image.DeletedAt != nil || image.Owner != opts.Owner {
+			continue
+		}
+		if opts.Status != "" && image.Status != opts.Status {
+			continue
+		}
+		if !opts.Before.IsZero() && !image.CreatedAt.Before(opts.Before) {
+			continue
+		}
+		if !matchesMetadata(&image.ImageMetadata, opts) {
+			continue
+		}
+		images = append(images, *image)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].CreatedAt.After(images[j].CreatedAt) })
+	if opts.Limit > 0 && len(images) > opts.Limit {
+		images = images[:opts.Limit]
+	}
+	return images, nil
+}
+
+// All returns copies of every image, soft-deleted ones included
+func (r *ImageRepository) All(ctx context.Context) ([]models.Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	images := make([]models.Image, 0, len(r.images))
+	for _, image := range r.images {
+		images = append(images, *image)
+	}
+	return images, nil
+}
+
+func matchesMetadata(meta *models.ImageMetadata, opts repository.ListOptions) bool {
+	switch {
+	case opts.Format != "" && meta.Format != opts.Format,
+		meta.Width < opts.MinWidth,
+		meta.Height < opts.MinHeight,
+		opts.CameraMake != "" && !strings.EqualFold(meta.CameraMake, opts.CameraMake),
+		opts.CameraModel != "" && !strings.EqualFold(meta.CameraModel, opts.CameraModel),
+		opts.HasGPS != nil && (meta.Latitude != nil) != *opts.HasGPS:
+		return false
+	}
+	if !opts.TakenAfter.IsZero() && (meta.TakenAt == nil || meta.TakenAt.Before(opts.TakenAfter)) {
+		return false
+	}
+	if !opts.TakenBefore.IsZero() && (meta.TakenAt == nil || !meta.TakenAt.Before(opts.TakenBefore)) {
+		return false
+	}
+	return true
+}
+
+// Transition changes an image's status and returns a copy of the result
+func (r *ImageRepository) Transition(ctx context.Context, id uuid.UUID, t repository.Transition) (*models.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.transition(id, t)
+}
+
+// transition applies t to an image. The caller must hold mu.
+func (r *ImageRepository) transition(id uuid.UUID, t repository.Transition) (*models.Image, error) {
+	image, err := r.image(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.From) > 0 && !slices.Contains(t.From, image.Status) {
+		return nil, repository.ErrConflict
+	}
+	if !t.UpdatedAt.IsZero() && !t.UpdatedAt.Equal(image.UpdatedAt) {
+		return nil, repository.ErrConflict
+	}
+	image.Status = t.To
+	image.Error = t.Error
+	if t.Checksum != "" {
+		image.Checksum = t.Checksum
+	}
+	image.UpdatedAt = time.Now().UTC()
+	updated := *image
+	return &updated, nil
+}
+
+// MarkDeleted soft-deletes an image that is not in flight
+func (r *ImageRepository) MarkDeleted(ctx context.Context, id uuid.UUID) error {
+	return r.update(id, func(image *models.Image) error {
+		if repository.InFlight(image.Status) {
+			return repository.ErrConflict
+		}
+		now := time.Now().UTC()
+		image.DeletedAt = &now
+		return nil
+	})
+}
+
+// SetOriginal records the name and key of an original stored after the image was created
+func (r *ImageRepository) SetOriginal(ctx context.Context, id uuid.UUID, filename, key string) error {
+	return r.update(id, func(image *models.Image) error {
+		image.Filename = filename
+		image.OriginalKey = key
+		image.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// SetChecksum records the SHA-256 of the original
+func (r *ImageRepository) SetChecksum(ctx context.Context, id uuid.UUID, checksum string) error {
+	return r.update(id, func(image *models.Image) error {
+		image.Checksum = checksum
+		return nil
+	})
+}
+
+// SetProcessed records the location of the processed image and its pipeline
+func (r *ImageRepository) SetProcessed(ctx context.Context, id uuid.UUID, bucket, key, pipeline string) error {
+	return r.update(id, func(image *models.Image) error {
+		image.ProcessedBucket = bucket
+		image.ProcessedKey = key
+		image.Pipeline = pipeline
+		return nil
+	})
+}
+
+// SetMetadata records the properties read from the original
+func (r *ImageRepository) SetMetadata(ctx context.Context, id uuid.UUID, meta models.ImageMetadata) error {
+	return r.update(id, func(image *models.Image) error {
+		image.ImageMetadata = meta
+		return nil
+	})
+}
+
+// SetHashes records the perceptual hashes of the decoded original
+func (r *ImageRepository) SetHashes(ctx context.Context, id uuid.UUID, hashes models.PerceptualHashes) error {
+	return r.update(id, func(image *models.Image) error {
+		r.hashes[id] = hashes
+		return nil
+	})
+}
+
+// SetPlaceholder records the BlurHash and dominant colors of the decoded original
+func (r *ImageRepository) SetPlaceholder(ctx context.Context, id uuid.UUID, placeholder models.Placeholder) error {
+	return r.update(id, func(image *models.Image) error {
+		image.Placeholder = placeholder
+		return nil
+	})
+}
+
+// Similar returns images whose hash is close to that of image id, closest first
+func (r *ImageRepository) Similar(ctx context.Context, id uuid.UUID, opts repository.SimilarOptions) ([]repository.SimilarImage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, err := r.image(id); err != nil {
+		return nil, err
+	}
+	hashes, ok := r.hashes[id]
+	if !ok {
+		return nil, repository.ErrNotHashed
+	}
+	target, ok := hashes.Hash(opts.Algorithm)
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q", opts.Algorithm)
+	}
+
+	var similar []repository.SimilarImage
+	for otherID, other := range r.hashes {
+		image, err := r.image(otherID)
+		if otherID == id || err != nil || opts.Owner != "" && image.Owner != opts.Owner {
+			continue
+		}
+		hash, _ := other.Hash(opts.Algorithm)
+		if distance := bits.OnesCount64(hash ^ target); distance <= opts.MaxDistance {
+			similar = append(similar, repository.SimilarImage{Image: *image, Distance: distance})
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].CreatedAt.After(similar[j].CreatedAt)
+	})
+	if opts.Limit > 0 && len(similar) > opts.Limit {
+		similar = similar[:opts.Limit]
+	}
+	return similar, nil
+}
+
+// LinkDuplicate points image id at the objects of the oldest identical
+// completed image
+func (r *ImageRepository) LinkDuplicate(ctx context.Context, id uuid.UUID, checksum, pipeline string) (*repository.Duplicate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var source *models.Image
+	for _, image := range r.images {
+		if image.ID == id || image.DeletedAt != nil || image.Status != models.ImageStatusCompleted ||
+			image.Checksum != checksum || image.Pipeline != pipeline ||
+			image.OriginalKey == "" || image.ProcessedKey == "" {
+			continue
+		}
+		if source == nil || image.CreatedAt.Before(source.CreatedAt) {
+			source = image
+		}
+	}
+	if source == nil {
+		return nil, nil
+	}
+	own, err := r.image(id)
+	if err != nil {
+		return nil, err
+	}
+	duplicate := &repository.Duplicate{SourceID: source.ID}
+	if own.BucketName == source.BucketName && own.OriginalKey == source.OriginalKey {
+		return duplicate, nil
+	}
+
+	r.retain(repository.Object{Bucket: source.BucketName, Key: source.OriginalKey})
+	r.retain(repository.Object{Bucket: source.ProcessedBucket, Key: source.ProcessedKey})
+	for _, obj := range []repository.Object{{Bucket: own.BucketName, Key: own.OriginalKey}, {Bucket: own.ProcessedBucket, Key: own.ProcessedKey}} {
+		if obj.Key != "" && !r.release(obj) {
+			duplicate.Unreferenced = append(duplicate.Unreferenced, obj)
+		}
+	}
+
+	linked := *source
+	linked.ID, linked.Filename, linked.Owner = own.ID, own.Filename, own.Owner
+	linked.CallbackURL, linked.CallbackSecret = own.CallbackURL, own.CallbackSecret
+	linked.CreatedAt, linked.AccessedAt, linked.DeletedAt = own.CreatedAt, own.AccessedAt, own.DeletedAt
+	linked.Error, linked.UpdatedAt = "", time.Now().UTC()
+	r.images[id] = &linked
+	if hashes, ok := r.hashes[source.ID]; ok {
+		r.hashes[id] = hashes
+	}
+	return duplicate, nil
+}
+
+// retain records that one more image references obj. The caller must hold mu.
+func (r *ImageRepository) retain(obj repository.Object) {
+	if r.refs[obj] == 0 {
+		r.refs[obj] = 1
+	}
+	r.refs[obj]++
+}
+
+// release records that one image no longer references obj and reports
+// whether others still do. The caller must hold mu.
+func (r *ImageRepository) release(obj repository.Object) bool {
+	refs, ok := r.refs[obj]
+	if !ok {
+		return false
+	}
+	if refs <= 2 {
+		delete(r.refs, obj)
+	} else {
+		r.refs[obj] = refs - 1
+	}
+	return true
+}
+
+// Touch records that the image's content was served
+func (r *ImageRepository) Touch(ctx context.Context, id uuid.UUID) error {
+	return r.update(id, func(image *models.Image) error {
+		now := time.Now().UTC()
+		image.AccessedAt = &now
+		return nil
+	})
+}
+
+func (r *ImageRepository) update(id uuid.UUID, change func(image *models.Image) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, err := r.image(id)
+	if err != nil {
+		return err
+	}
+	return change(image)
+}
+
+// image returns a stored image that is not deleted. The caller must hold mu.
+func (r *ImageRepository) image(id uuid.UUID) (*models.Image, error) {
+	image, ok := r.images[id]
+	if !ok || image.DeletedAt != nil {
+		return nil, repository.ErrNotFound
+	}
+	return image, nil
+}
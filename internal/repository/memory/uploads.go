@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var _ repository.UploadRepository = (*UploadRepository)(nil)
+
+// UploadRepository keeps upload sessions in memory, next to the images of
+// an ImageRepository. It is meant for tests.
+type UploadRepository struct {
+	images   *ImageRepository
+	sessions map[uuid.UUID]*models.UploadSession // guarded by images.mu
+}
+
+func NewUploadRepository(images *ImageRepository) *UploadRepository {
+	return &UploadRepository{images: images, sessions: make(map[uuid.UUID]*models.UploadSession)}
+}
+
+// Create saves copies of an image and its upload session
+func (r *UploadRepository) Create(ctx context.Context, image *models.Image, session *models.UploadSession) error {
+	r.images.mu.Lock()
+	defer r.images.mu.Unlock()
+
+	if err := r.images.create(image); err != nil {
+		return err
+	}
+	stored := *session
+	stored.CreatedAt, stored.UpdatedAt = image.CreatedAt, image.CreatedAt
+	r.sessions[session.ImageID] = &stored
+	return nil
+}
+
+// Get returns a copy of the session of an image or repository.ErrUploadNotFound
+func (r *UploadRepository) Get(ctx context.Context, imageID uuid.UUID) (*models.UploadSession, error) {
+	r.images.mu.RLock()
+	defer r.images.mu.RUnlock()
+
+	session, ok := r.sessions[imageID]
+	if !ok {
+		return nil, repository.ErrUploadNotFound
+	}
+	found := *session
+	found.PartETags = append([]string(nil), session.PartETags...)
+	return &found, nil
+}
+
+// SaveProgress records the offset, parts, hash state and expiry of a session
+func (r *UploadRepository) SaveProgress(ctx context.Context, session *models.UploadSession) error {
+	r.images.mu.Lock()
+	defer r.images.mu.Unlock()
+
+	stored, ok := r.sessions[session.ImageID]
+	if !ok {
+		return repository.ErrUploadNotFound
+	}
+	stored.Offset = session.Offset
+	stored.PartETags = append([]string(nil), session.PartETags...)
+	stored.HashState = session.HashState
+	stored.ExpiresAt = session.ExpiresAt
+	stored.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// Expired returns copies of up to limit sessions that expired before now
+func (r *UploadRepository) Expired(ctx context.Context, now time.Time, limit int) ([]models.UploadSession, error) {
+	r.images.mu.RLock()
+	defer r.images.mu.RUnlock()
+
+	var sessions []models.UploadSession
+	for _, session := range r.sessions {
+		if session.ExpiresAt.Before(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ExpiresAt.Before(sessions[j].ExpiresAt) })
+	if len(sessions) > limit {
+		sessions = sessions[:limit]
+	}
+	return sessions, nil
+}
+
+// Objects returns the objects of every unfinished upload
+func (r *UploadRepository) Objects(ctx context.Context) ([]repository.Object, error) {
+	r.images.mu.RLock()
+	defer r.images.mu.RUnlock()
+
+	objects := make([]repository.Object, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		objects = append(objects, repository.Object{Bucket: session.BucketName, Key: session.ObjectName})
+	}
+	return objects, nil
+}
+
+// Finish removes the session of an image and applies t to the image
+func (r *UploadRepository) Finish(ctx context.Context, imageID uuid.UUID, t repository.Transition) (*models.Image, error) {
+	r.images.mu.Lock()
+	defer r.images.mu.Unlock()
+
+	image, err := r.images.transition(imageID, t)
+	if err != nil {
+		return nil, err
+	}
+	delete(r.sessions, imageID)
+	return image, nil
+}
+
+// Discard removes the session of an image and applies t to the image if it
+// can still make the transition
+func (r *UploadRepository) Discard(ctx context.Context, imageID uuid.UUID, t repository.Transition) (bool, error) {
+	r.images.mu.Lock()
+	defer r.images.mu.Unlock()
+
+	delete(r.sessions, imageID)
+	_, err := r.images.transition(imageID, t)
+	if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,212 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var _ repository.WebhookRepository = (*WebhookRepository)(nil)
+
+// WebhookRepository keeps subscriptions and deliveries in memory. It is
+// meant for tests.
+type WebhookRepository struct {
+	mu            sync.RWMutex
+	subscriptions []models.WebhookSubscription
+	deliveries    []models.WebhookDelivery
+	// callbackSecrets are the secrets of images with callback deliveries
+	callbackSecrets map[uuid.UUID]string
+}
+
+func NewWebhookRepository() *WebhookRepository {
+	return &WebhookRepository{callbackSecrets: make(map[uuid.UUID]string)}
+}
+
+// CreateSubscription saves a copy of a subscription and sets its CreatedAt
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscription.CreatedAt = time.Now().UTC()
+	r.subscriptions = append(r.subscriptions, *subscription)
+	return nil
+}
+
+// ListSubscriptions returns the subscriptions of an owner, oldest first
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context, owner string) ([]models.WebhookSubscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var subscriptions []models.WebhookSubscription
+	for _, subscription := range r.subscriptions {
+		if subscription.Owner == owner {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+// DeleteSubscription removes a subscription of owner along with its deliveries
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id uuid.UUID, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.subscriptions, func(s models.WebhookSubscription) bool {
+		return s.ID == id && s.Owner == owner
+	})
+	if i < 0 {
+		return repository.ErrWebhookNotFound
+	}
+	r.subscriptions = slices.Delete(r.subscriptions, i, i+1)
+	r.deliveries = slices.DeleteFunc(r.deliveries, func(d models.WebhookDelivery) bool {
+		return d.SubscriptionID != nil && *d.SubscriptionID == id
+	})
+	return nil
+}
+
+// RecordDeliveries records a pending delivery to the image's callback URL
+// and to every subscription of its owner
+func (r *WebhookRepository) RecordDeliveries(ctx context.Context, image *models.Image, event string, payload []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	delivery := models.WebhookDelivery{
+		ImageID:       image.ID,
+		Owner:         image.Owner,
+		Event:         event,
+		Payload:       append([]byte(nil), payload...),
+		Status:        models.DeliveryStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	count := 0
+	if image.CallbackURL != "" {
+		delivery.ID, delivery.URL = uuid.New(), image.CallbackURL
+		r.deliveries = append(r.deliveries, delivery)
+		r.callbackSecrets[image.ID] = image.CallbackSecret
+		count++
+	}
+	if image.Owner == "" {
+		return count, nil
+	}
+	for _, subscription := range r.subscriptions {
+		if subscription.Owner != image.Owner {
+			continue
+		}
+		subscriptionID := subscription.ID
+		delivery.ID, delivery.URL, delivery.SubscriptionID = uuid.New(), subscription.URL, &subscriptionID
+		r.deliveries = append(r.deliveries, delivery)
+		count++
+	}
+	return count, nil
+}
+
+// ListDeliveries returns deliveries matching opts, newest first
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, opts repository.DeliveryListOptions) ([]models.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.Owner != opts.Owner ||
+			(opts.Status != "" && delivery.Status != opts.Status) ||
+			(opts.ImageID != uuid.Nil && delivery.ImageID != opts.ImageID) {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	if opts.Limit > 0 && len(deliveries) > opts.Limit {
+		deliveries = deliveries[:opts.Limit]
+	}
+	return deliveries, nil
+}
+
+// GetDelivery returns a copy of a delivery of owner or repository.ErrDeliveryNotFound
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id uuid.UUID, owner string) (*models.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, delivery := range r.deliveries {
+		if delivery.ID == id && delivery.Owner == owner {
+			return &delivery, nil
+		}
+	}
+	return nil, repository.ErrDeliveryNotFound
+}
+
+// ReplayDelivery makes a failed delivery due again with a fresh set of attempts
+func (r *WebhookRepository) ReplayDelivery(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.deliveries {
+		delivery := &r.deliveries[i]
+		if delivery.ID == id && delivery.Status == models.DeliveryStatusFailed {
+			now := time.Now().UTC()
+			delivery.Status, delivery.Attempts = models.DeliveryStatusPending, 0
+			delivery.NextAttemptAt, delivery.UpdatedAt = now, now
+		}
+	}
+	return nil
+}
+
+// ClaimDeliveries returns copies of due pending deliveries, earliest first,
+// and pushes their next attempt past timeout
+func (r *WebhookRepository) ClaimDeliveries(ctx context.Context, limit int, timeout time.Duration) ([]repository.ClaimedDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	var due []*models.WebhookDelivery
+	for i := range r.deliveries {
+		delivery := &r.deliveries[i]
+		if delivery.Status == models.DeliveryStatusPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]repository.ClaimedDelivery, len(due))
+	for i, delivery := range due {
+		delivery.NextAttemptAt, delivery.UpdatedAt = now.Add(timeout), now
+		claimed[i] = repository.ClaimedDelivery{WebhookDelivery: *delivery, Secret: r.callbackSecrets[delivery.ImageID]}
+		if delivery.SubscriptionID != nil {
+			claimed[i].Secret = ""
+			for _, subscription := range r.subscriptions {
+				if subscription.ID == *delivery.SubscriptionID {
+					claimed[i].Secret = subscription.Secret
+				}
+			}
+		}
+	}
+	return claimed, nil
+}
+
+// RecordAttempt records the outcome of sending a delivery
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, id uuid.UUID, attempt repository.DeliveryAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.deliveries {
+		delivery := &r.deliveries[i]
+		if delivery.ID == id {
+			delivery.Status, delivery.Attempts = attempt.Status, attempt.Attempts
+			delivery.ResponseCode, delivery.LastError = attempt.ResponseCode, attempt.LastError
+			delivery.NextAttemptAt, delivery.UpdatedAt = attempt.NextAttemptAt, time.Now().UTC()
+		}
+	}
+	return nil
+}
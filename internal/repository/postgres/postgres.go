@@ -0,0 +1,450 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var _ repository.ImageRepository = (*ImageRepository)(nil)
+
+// DB is implemented by connection pools and transactions, so a repository
+// can take part in a caller's transaction. Begin on a transaction starts a
+// savepoint.
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ImageRepository stores images in the images table
+type ImageRepository struct {
+	db DB
+}
+
+func NewImageRepository(db DB) *ImageRepository {
+	return &ImageRepository{db: db}
+}
+
+// imageColumns are the columns read into models.Image, in scan order
+const imageColumns = `id, filename, status, bucket_name, original_key, processed_bucket, processed_key,
+	owner, callback_url, callback_secret, error, checksum, pipeline, created_at, updated_at, accessed_at, deleted_at,
+	width, height, format, size_bytes, orientation, camera_make, camera_model, taken_at,
+	gps_latitude, gps_longitude, has_icc_profile, exif, blurhash, palette`
+
+func scanImage(row pgx.Row) (*models.Image, error) {
+	var image models.Image
+	if err := row.Scan(imageFields(&image)...); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// imageFields returns the scan destinations of imageColumns
+func imageFields(image *models.Image) []any {
+	return []any{
+		&image.ID,
+		&image.Filename,
+		&image.Status,
+		&image.BucketName,
+		&image.OriginalKey,
+		&image.ProcessedBucket,
+		&image.ProcessedKey,
+		&image.Owner,
+		&image.CallbackURL,
+		&image.CallbackSecret,
+		&image.Error,
+		&image.Checksum,
+		&image.Pipeline,
+		&image.CreatedAt,
+		&image.UpdatedAt,
+		&image.AccessedAt,
+		&image.DeletedAt,
+		&image.Width,
+		&image.Height,
+		&image.Format,
+		&image.Size,
+		&image.Orientation,
+		&image.CameraMake,
+		&image.CameraModel,
+		&image.TakenAt,
+		&image.Latitude,
+		&image.Longitude,
+		&image.HasICCProfile,
+		&image.EXIF,
+		&image.BlurHash,
+		&image.Palette,
+	}
+}
+
+// Create saves a new image record
+func (r *ImageRepository) Create(ctx context.Context, image *models.Image) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO images (id, filename, status, bucket_name, original_key, owner, callback_url, callback_secret, checksum, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, image.ID, image.Filename, image.Status, image.BucketName, image.OriginalKey,
+		image.Owner, image.CallbackURL, image.CallbackSecret, image.Checksum, image.CreatedAt, image.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create image: %w", err)
+	}
+	return nil
+}
+
+// Get returns an image or repository.ErrNotFound
+func (r *ImageRepository) Get(ctx context.Context, id uuid.UUID) (*models.Image, error) {
+	image, err := scanImage(r.db.QueryRow(ctx, `
+		SELECT `+imageColumns+`
+		FROM images
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	return image, nil
+}
+
+// List returns images matching opts, newest first
+func (r *ImageRepository) List(ctx context.Context, opts repository.ListOptions) ([]models.Image, error) {
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE owner = $1 AND deleted_at IS NULL
+	`
+	args := []any{opts.Owner}
+	if opts.Status != "" {
+		args = append(args, opts.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if !opts.Before.IsZero() {
+		args = append(args, opts.Before)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	if opts.Format != "" {
+		args = append(args, opts.Format)
+		query += fmt.Sprintf(" AND format = $%d", len(args))
+	}
+	if opts.MinWidth > 0 {
+		args = append(args, opts.MinWidth)
+		query += fmt.Sprintf(" AND width >= $%d", len(args))
+	}
+	if opts.MinHeight > 0 {
+		args = append(args, opts.MinHeight)
+		query += fmt.Sprintf(" AND height >= $%d", len(args))
+	}
+	if opts.CameraMake != "" {
+		args = append(args, opts.CameraMake)
+		query += fmt.Sprintf(" AND lower(camera_make) = lower($%d)", len(args))
+	}
+	if opts.CameraModel != "" {
+		args = append(args, opts.CameraModel)
+		query += fmt.Sprintf(" AND lower(camera_model) = lower($%d)", len(args))
+	}
+	if !opts.TakenAfter.IsZero() {
+		args = append(args, opts.TakenAfter)
+		query += fmt.Sprintf(" AND taken_at >= $%d", len(args))
+	}
+	if !opts.TakenBefore.IsZero() {
+		args = append(args, opts.TakenBefore)
+		query += fmt.Sprintf(" AND taken_at < $%d", len(args))
+	}
+	if opts.HasGPS != nil {
+		args = append(args, *opts.HasGPS)
+		query += fmt.Sprintf(" AND (gps_latitude IS NOT NULL) = $%d", len(args))
+	}
+	args = append(args, opts.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
+
+	return r.query(ctx, query, args...)
+}
+
+// All returns every image, soft-deleted ones included
+func (r *ImageRepository) All(ctx context.Context) ([]models.Image, error) {
+	return r.query(ctx, `SELECT `+imageColumns+` FROM images`)
+}
+
+func (r *ImageRepository) query(ctx context.Context, query string, args ...any) ([]models.Image, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []models.Image
+	for rows.Next() {
+		image, err := scanImage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan image: %w", err)
+		}
+		images = append(images, *image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	return images, nil
+}
+
+// Transition changes an image's status and returns the updated image
+func (r *ImageRepository) Transition(ctx context.Context, id uuid.UUID, t repository.Transition) (*models.Image, error) {
+	from := make([]string, len(t.From))
+	for i, status := range t.From {
+		from[i] = string(status)
+	}
+	var updatedAt *time.Time
+	if !t.UpdatedAt.IsZero() {
+		updatedAt = &t.UpdatedAt
+	}
+
+	image, err := scanImage(r.db.QueryRow(ctx, `
+		UPDATE images
+		SET status = $1, error = $2, checksum = COALESCE(NULLIF($3, ''), checksum), updated_at = NOW()
+		WHERE id = $4 AND deleted_at IS NULL AND (cardinality($5::text[]) = 0 OR status = ANY($5))
+		  AND ($6::timestamptz IS NULL OR updated_at = $6)
+		RETURNING `+imageColumns,
+		t.To, t.Error, t.Checksum, id, from, updatedAt))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, r.missingOrConflict(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update status: %w", err)
+	}
+	return image, nil
+}
+
+// MarkDeleted soft-deletes an image that is not in flight
+func (r *ImageRepository) MarkDeleted(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE images SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL AND status NOT IN ($2, $3, $4)
+	`, id, models.ImageStatusUploading, models.ImageStatusPending, models.ImageStatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to delete image: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.missingOrConflict(ctx, id)
+	}
+	return nil
+}
+
+// SetOriginal records the name and key of an original stored after the image was created
+func (r *ImageRepository) SetOriginal(ctx context.Context, id uuid.UUID, filename, key string) error {
+	return r.update(ctx, id, `filename = $2, original_key = $3, updated_at = NOW()`, filename, key)
+}
+
+// SetChecksum records the SHA-256 of the original
+func (r *ImageRepository) SetChecksum(ctx context.Context, id uuid.UUID, checksum string) error {
+	return r.update(ctx, id, `checksum = $2`, checksum)
+}
+
+// SetProcessed records the location of the processed image and its pipeline
+func (r *ImageRepository) SetProcessed(ctx context.Context, id uuid.UUID, bucket, key, pipeline string) error {
+	return r.update(ctx, id, `processed_bucket = $2, processed_key = $3, pipeline = $4`, bucket, key, pipeline)
+}
+
+// SetMetadata records the properties read from the original
+func (r *ImageRepository) SetMetadata(ctx context.Context, id uuid.UUID, meta models.ImageMetadata) error {
+	return r.update(ctx, id, `
+		width = $2, height = $3, format = $4, size_bytes = $5, orientation = $6,
+		camera_make = $7, camera_model = $8, taken_at = $9, gps_latitude = $10, gps_longitude = $11,
+		has_icc_profile = $12, exif = $13`,
+		meta.Width, meta.Height, meta.Format, meta.Size, meta.Orientation,
+		meta.CameraMake, meta.CameraModel, meta.TakenAt, meta.Latitude, meta.Longitude,
+		meta.HasICCProfile, meta.EXIF)
+}
+
+// SetHashes records the perceptual hashes of the decoded original. Hashes are
+// stored as the bits of signed integers.
+func (r *ImageRepository) SetHashes(ctx context.Context, id uuid.UUID, hashes models.PerceptualHashes) error {
+	return r.update(ctx, id, `ahash = $2, dhash = $3, phash = $4`,
+		int64(hashes.AHash), int64(hashes.DHash), int64(hashes.PHash))
+}
+
+// SetPlaceholder records the BlurHash and dominant colors of the decoded original
+func (r *ImageRepository) SetPlaceholder(ctx context.Context, id uuid.UUID, placeholder models.Placeholder) error {
+	return r.update(ctx, id, `blurhash = $2, palette = $3`, placeholder.BlurHash, placeholder.Palette)
+}
+
+// hashColumns maps hash algorithms to the columns holding them
+var hashColumns = map[string]string{
+	models.HashAverage:    "ahash",
+	models.HashDifference: "dhash",
+	models.HashDCT:        "phash",
+}
+
+// Similar returns images whose hash is close to that of image id, closest
+// first. Every hashed image is compared, which is a sequential scan.
+func (r *ImageRepository) Similar(ctx context.Context, id uuid.UUID, opts repository.SimilarOptions) ([]repository.SimilarImage, error) {
+	column, ok := hashColumns[opts.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q", opts.Algorithm)
+	}
+
+	var target *int64
+	err := r.db.QueryRow(ctx, `SELECT `+column+` FROM images WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&target)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	if target == nil {
+		return nil, repository.ErrNotHashed
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT `+imageColumns+`, distance
+		FROM (
+			SELECT *, bit_count((`+column+` # $2)::bit(64))::int AS distance
+			FROM images
+			WHERE id <> $1 AND deleted_at IS NULL AND `+column+` IS NOT NULL
+				AND ($5 = '' OR owner = $5)
+		) hashed
+		WHERE distance <= $3
+		ORDER BY distance, created_at DESC
+		LIMIT $4
+	`, id, *target, opts.MaxDistance, opts.Limit, opts.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search similar images: %w", err)
+	}
+	defer rows.Close()
+
+	var similar []repository.SimilarImage
+	for rows.Next() {
+		var found repository.SimilarImage
+		if err := rows.Scan(append(imageFields(&found.Image), &found.Distance)...); err != nil {
+			return nil, fmt.Errorf("failed to scan image: %w", err)
+		}
+		similar = append(similar, found)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search similar images: %w", err)
+	}
+	return similar, nil
+}
+
+// LinkDuplicate points image id at the objects of an identical completed
+// image. The source is locked so it cannot be purged before its objects are
+// retained.
+func (r *ImageRepository) LinkDuplicate(ctx context.Context, id uuid.UUID, checksum, pipeline string) (*repository.Duplicate, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var source models.Image
+	err = tx.QueryRow(ctx, `
+		SELECT id, bucket_name, original_key, processed_bucket, processed_key
+		FROM images
+		WHERE checksum = $1 AND pipeline = $2 AND status = $3 AND id <> $4
+		  AND original_key <> '' AND processed_key <> '' AND deleted_at IS NULL
+		ORDER BY created_at
+		LIMIT 1
+		FOR SHARE
+	`, checksum, pipeline, models.ImageStatusCompleted, id).Scan(
+		&source.ID, &source.BucketName, &source.OriginalKey, &source.ProcessedBucket, &source.ProcessedKey,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up duplicate: %w", err)
+	}
+
+	var own models.Image
+	err = tx.QueryRow(ctx, `
+		SELECT bucket_name, original_key, processed_bucket, processed_key
+		FROM images WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, id).Scan(&own.BucketName, &own.OriginalKey, &own.ProcessedBucket, &own.ProcessedKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	duplicate := &repository.Duplicate{SourceID: source.ID}
+	// A redelivered task may find the image already linked
+	if own.BucketName == source.BucketName && own.OriginalKey == source.OriginalKey {
+		return duplicate, nil
+	}
+
+	if err := retain(ctx, tx, source.BucketName, source.OriginalKey); err != nil {
+		return nil, err
+	}
+	if err := retain(ctx, tx, source.ProcessedBucket, source.ProcessedKey); err != nil {
+		return nil, err
+	}
+	// Release whatever the image referenced before; usually just the
+	// freshly uploaded original
+	duplicate.Unreferenced, err = releaseAll(ctx, tx,
+		repository.Object{Bucket: own.BucketName, Key: own.OriginalKey},
+		repository.Object{Bucket: own.ProcessedBucket, Key: own.ProcessedKey},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Identical originals have identical metadata, hashes and placeholders, so they are copied as well
+	_, err = tx.Exec(ctx, `
+		UPDATE images i
+		SET bucket_name = $1, original_key = $2, processed_bucket = $3, processed_key = $4,
+		    checksum = $5, pipeline = $6, status = $7, error = '', updated_at = NOW(),
+		    width = s.width, height = s.height, format = s.format, size_bytes = s.size_bytes,
+		    orientation = s.orientation, camera_make = s.camera_make, camera_model = s.camera_model,
+		    taken_at = s.taken_at, gps_latitude = s.gps_latitude, gps_longitude = s.gps_longitude,
+		    has_icc_profile = s.has_icc_profile, exif = s.exif,
+		    ahash = s.ahash, dhash = s.dhash, phash = s.phash, blurhash = s.blurhash, palette = s.palette
+		FROM images s
+		WHERE i.id = $8 AND s.id = $9
+	`, source.BucketName, source.OriginalKey, source.ProcessedBucket, source.ProcessedKey,
+		checksum, pipeline, models.ImageStatusCompleted, id, source.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link image: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to link image: %w", err)
+	}
+	return duplicate, nil
+}
+
+// Touch records that the image's content was served. It does not change
+// updated_at, which tracks changes to the image itself.
+func (r *ImageRepository) Touch(ctx context.Context, id uuid.UUID) error {
+	return r.update(ctx, id, `accessed_at = NOW()`)
+}
+
+// update sets columns of one image; $1 is the image ID
+func (r *ImageRepository) update(ctx context.Context, id uuid.UUID, set string, args ...any) error {
+	tag, err := r.db.Exec(ctx, `UPDATE images SET `+set+` WHERE id = $1 AND deleted_at IS NULL`, append([]any{id}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to update image: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// missingOrConflict explains why a conditional update matched no row
+func (r *ImageRepository) missingOrConflict(ctx context.Context, id uuid.UUID) error {
+	var exists bool
+	err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM images WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to load image: %w", err)
+	}
+	if !exists {
+		return repository.ErrNotFound
+	}
+	return repository.ErrConflict
+}
@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"image-processor/internal/repository"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Objects shared by duplicate images are reference counted in object_refs.
+// An object without a row there belongs to a single image; rows only exist
+// while two or more images reference the object.
+
+// retain records that one more image references an object
+func retain(ctx context.Context, tx pgx.Tx, bucketName, objectName string) error {
+	if err := lockObject(ctx, tx, bucketName, objectName); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO object_refs (bucket_name, object_key, refs)
+		VALUES ($1, $2, 2)
+		ON CONFLICT (bucket_name, object_key) DO UPDATE SET refs = object_refs.refs + 1
+	`, bucketName, objectName)
+	if err != nil {
+		return fmt.Errorf("failed to retain %s/%s: %w", bucketName, objectName, err)
+	}
+	return nil
+}
+
+// release records that one image no longer references an object and reports
+// whether other images still do. The caller deletes the object after
+// committing if none do.
+func release(ctx context.Context, tx pgx.Tx, bucketName, objectName string) (bool, error) {
+	if err := lockObject(ctx, tx, bucketName, objectName); err != nil {
+		return false, err
+	}
+
+	var refs int
+	err := tx.QueryRow(ctx, `
+		UPDATE object_refs SET refs = refs - 1
+		WHERE bucket_name = $1 AND object_key = $2
+		RETURNING refs
+	`, bucketName, objectName).Scan(&refs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to release %s/%s: %w", bucketName, objectName, err)
+	}
+	if refs <= 1 {
+		_, err = tx.Exec(ctx, `DELETE FROM object_refs WHERE bucket_name = $1 AND object_key = $2`, bucketName, objectName)
+		if err != nil {
+			return false, fmt.Errorf("failed to release %s/%s: %w", bucketName, objectName, err)
+		}
+	}
+	return true, nil
+}
+
+// releaseAll releases each object with a key and returns those no other
+// image references
+func releaseAll(ctx context.Context, tx pgx.Tx, objects ...repository.Object) ([]repository.Object, error) {
+	var unreferenced []repository.Object
+	for _, obj := range objects {
+		if obj.Key == "" {
+			continue
+		}
+		shared, err := release(ctx, tx, obj.Bucket, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !shared {
+			unreferenced = append(unreferenced, obj)
+		}
+	}
+	return unreferenced, nil
+}
+
+// lockObject serialises reference changes to one object until the end of tx
+func lockObject(ctx context.Context, tx pgx.Tx, bucketName, objectName string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, bucketName+"/"+objectName)
+	if err != nil {
+		return fmt.Errorf("failed to lock %s/%s: %w", bucketName, objectName, err)
+	}
+	return nil
+}
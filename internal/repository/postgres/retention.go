@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var _ repository.RetentionRepository = (*RetentionRepository)(nil)
+
+// RetentionRepository applies retention rules to the images table
+type RetentionRepository struct {
+	db DB
+}
+
+func NewRetentionRepository(db DB) *RetentionRepository {
+	return &RetentionRepository{db: db}
+}
+
+// retentionRule selects the images a rule applies to and changes one of
+// them within tx, returning the objects it stops referencing
+type retentionRule struct {
+	where  string // predicate on images; $1 is the cutoff time, followed by args
+	args   []any
+	change func(ctx context.Context, tx pgx.Tx, image *models.Image) ([]repository.Object, error)
+}
+
+var retentionRules = map[string]retentionRule{
+	repository.RetentionDeleted: {
+		where:  `deleted_at IS NOT NULL AND deleted_at < $1`,
+		change: purge,
+	},
+	repository.RetentionOriginals: {
+		where: `deleted_at IS NULL AND status IN ($2, $3)
+			AND original_key <> '' AND updated_at < $1`,
+		args:   []any{models.ImageStatusCompleted, models.ImageStatusExpired},
+		change: deleteOriginal,
+	},
+	repository.RetentionProcessed: {
+		where: `deleted_at IS NULL AND status = $2
+			AND COALESCE(accessed_at, updated_at) < $1`,
+		args:   []any{models.ImageStatusCompleted},
+		change: expireProcessed,
+	},
+}
+
+// query returns the rule's predicate followed by extra, whose placeholders
+// are numbered after the rule's own, and the arguments of both
+func (rule retentionRule) query(cutoff time.Time, extra string, extraArgs ...any) (string, []any) {
+	args := append([]any{cutoff}, rule.args...)
+	placeholders := make([]any, len(extraArgs))
+	for i := range extraArgs {
+		placeholders[i] = fmt.Sprintf("$%d", len(args)+i+1)
+	}
+	return rule.where + " " + fmt.Sprintf(extra, placeholders...), append(args, extraArgs...)
+}
+
+func lookupRule(name string) (retentionRule, error) {
+	rule, ok := retentionRules[name]
+	if !ok {
+		return rule, fmt.Errorf("unknown retention rule %q", name)
+	}
+	return rule, nil
+}
+
+// Candidates returns IDs of images the rule applies to, in order
+func (r *RetentionRepository) Candidates(ctx context.Context, name string, cutoff time.Time, after uuid.UUID, limit int) ([]uuid.UUID, error) {
+	rule, err := lookupRule(name)
+	if err != nil {
+		return nil, err
+	}
+	where, args := rule.query(cutoff, "AND id > %s ORDER BY id LIMIT %s", after, limit)
+	rows, err := r.db.Query(ctx, `SELECT id FROM images WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query images: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan images: %w", err)
+	}
+	return ids, nil
+}
+
+// Apply re-checks and applies the rule to one image in its own transaction
+func (r *RetentionRepository) Apply(ctx context.Context, name string, cutoff time.Time, id uuid.UUID, dryRun bool) (*models.Image, []repository.Object, error) {
+	rule, err := lookupRule(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The image may have changed since the candidates were read
+	where, args := rule.query(cutoff, "AND id = %s FOR UPDATE SKIP LOCKED", id)
+	image, err := scanImage(tx.QueryRow(ctx, `SELECT `+imageColumns+` FROM images WHERE `+where, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load image: %w", err)
+	}
+
+	objects, err := rule.change(ctx, tx, image)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !dryRun {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, nil, fmt.Errorf("failed to commit: %w", err)
+		}
+	}
+	return image, objects, nil
+}
+
+// purge removes a soft-deleted image and releases its objects
+func purge(ctx context.Context, tx pgx.Tx, image *models.Image) ([]repository.Object, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM images WHERE id = $1`, image.ID); err != nil {
+		return nil, fmt.Errorf("failed to delete image: %w", err)
+	}
+	return releaseAll(ctx, tx,
+		repository.Object{Bucket: image.BucketName, Key: image.OriginalKey},
+		repository.Object{Bucket: image.ProcessedBucket, Key: image.ProcessedKey},
+	)
+}
+
+// deleteOriginal forgets the original of a processed image
+func deleteOriginal(ctx context.Context, tx pgx.Tx, image *models.Image) ([]repository.Object, error) {
+	if _, err := tx.Exec(ctx, `UPDATE images SET original_key = '' WHERE id = $1`, image.ID); err != nil {
+		return nil, fmt.Errorf("failed to update image: %w", err)
+	}
+	return releaseAll(ctx, tx, repository.Object{Bucket: image.BucketName, Key: image.OriginalKey})
+}
+
+// expireProcessed forgets the processed output of an image and marks it expired
+func expireProcessed(ctx context.Context, tx pgx.Tx, image *models.Image) ([]repository.Object, error) {
+	_, err := tx.Exec(ctx, `
+		UPDATE images
+		SET status = $1, processed_bucket = '', processed_key = '', updated_at = NOW()
+		WHERE id = $2
+	`, models.ImageStatusExpired, image.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update image: %w", err)
+	}
+	return releaseAll(ctx, tx, repository.Object{Bucket: image.ProcessedBucket, Key: image.ProcessedKey})
+}
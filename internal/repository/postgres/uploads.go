@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var _ repository.UploadRepository = (*UploadRepository)(nil)
+
+// UploadRepository stores upload sessions in the upload_sessions table
+type UploadRepository struct {
+	db DB
+}
+
+func NewUploadRepository(db DB) *UploadRepository {
+	return &UploadRepository{db: db}
+}
+
+const sessionColumns = `image_id, upload_id, bucket_name, object_name, content_type,
+	total_size, upload_offset, part_etags, hash_state, expires_at, created_at, updated_at`
+
+// Create saves a new image together with its upload session
+func (r *UploadRepository) Create(ctx context.Context, image *models.Image, session *models.UploadSession) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := NewImageRepository(tx).Create(ctx, image); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO upload_sessions (image_id, upload_id, bucket_name, object_name, content_type, total_size, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, session.ImageID, session.UploadID, session.BucketName, session.ObjectName, session.ContentType,
+		session.TotalSize, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create upload session: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// Get returns the session of an image or repository.ErrUploadNotFound
+func (r *UploadRepository) Get(ctx context.Context, imageID uuid.UUID) (*models.UploadSession, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+sessionColumns+` FROM upload_sessions WHERE image_id = $1`, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upload session: %w", err)
+	}
+	session, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.UploadSession])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upload session: %w", err)
+	}
+	return session, nil
+}
+
+// SaveProgress records the offset, parts, hash state and expiry of a session
+func (r *UploadRepository) SaveProgress(ctx context.Context, session *models.UploadSession) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE upload_sessions
+		SET upload_offset = $1, part_etags = $2, hash_state = $3, expires_at = $4, updated_at = NOW()
+		WHERE image_id = $5
+	`, session.Offset, session.PartETags, session.HashState, session.ExpiresAt, session.ImageID)
+	if err != nil {
+		return fmt.Errorf("failed to save upload progress: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrUploadNotFound
+	}
+	return nil
+}
+
+// Expired returns up to limit sessions that expired before now
+func (r *UploadRepository) Expired(ctx context.Context, now time.Time, limit int) ([]models.UploadSession, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+sessionColumns+`
+		FROM upload_sessions
+		WHERE expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2
+	`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired uploads: %w", err)
+	}
+	sessions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.UploadSession])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan expired uploads: %w", err)
+	}
+	return sessions, nil
+}
+
+// Objects returns the objects of every unfinished upload
+func (r *UploadRepository) Objects(ctx context.Context) ([]repository.Object, error) {
+	rows, err := r.db.Query(ctx, `SELECT bucket_name, object_name FROM upload_sessions`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload sessions: %w", err)
+	}
+	objects, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (repository.Object, error) {
+		var obj repository.Object
+		err := row.Scan(&obj.Bucket, &obj.Key)
+		return obj, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload sessions: %w", err)
+	}
+	return objects, nil
+}
+
+// Finish removes the session of an image and applies t to the image
+func (r *UploadRepository) Finish(ctx context.Context, imageID uuid.UUID, t repository.Transition) (*models.Image, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	image, err := NewImageRepository(tx).Transition(ctx, imageID, t)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM upload_sessions WHERE image_id = $1`, imageID); err != nil {
+		return nil, fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// Discard removes the session of an image and applies t to the image if it
+// can still make the transition
+func (r *UploadRepository) Discard(ctx context.Context, imageID uuid.UUID, t repository.Transition) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM upload_sessions WHERE image_id = $1`, imageID); err != nil {
+		return false, fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	// An image that cannot make the transition has already moved on
+	_, err = NewImageRepository(tx).Transition(ctx, imageID, t)
+	changed := err == nil
+	if err != nil && !errors.Is(err, repository.ErrConflict) && !errors.Is(err, repository.ErrNotFound) {
+		return false, fmt.Errorf("failed to update image status: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	return changed, nil
+}
@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var _ repository.WebhookRepository = (*WebhookRepository)(nil)
+
+// WebhookRepository stores subscriptions and deliveries in the
+// webhook_subscriptions and webhook_deliveries tables
+type WebhookRepository struct {
+	db DB
+}
+
+func NewWebhookRepository(db DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+const deliveryColumns = `id, image_id, subscription_id, owner, url, event, payload, status,
+	attempts, last_error, response_code, next_attempt_at, created_at, updated_at`
+
+// CreateSubscription saves a new subscription and sets its CreatedAt
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO webhook_subscriptions (id, owner, url, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`, subscription.ID, subscription.Owner, subscription.URL, subscription.Secret).Scan(&subscription.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListSubscriptions returns the subscriptions of an owner, oldest first
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context, owner string) ([]models.WebhookSubscription, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, owner, url, secret, created_at
+		FROM webhook_subscriptions
+		WHERE owner = $1
+		ORDER BY created_at
+	`, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhooks: %w", err)
+	}
+	subscriptions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.WebhookSubscription])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhooks: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// DeleteSubscription removes a subscription of owner. Its deliveries go with
+// it through the foreign key.
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id uuid.UUID, owner string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1 AND owner = $2`, id, owner)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrWebhookNotFound
+	}
+	return nil
+}
+
+// RecordDeliveries records a pending delivery to the image's callback URL
+// and to every subscription of its owner
+func (r *WebhookRepository) RecordDeliveries(ctx context.Context, image *models.Image, event string, payload []byte) (int, error) {
+	count := 0
+	if image.CallbackURL != "" {
+		_, err := r.db.Exec(ctx, `
+			INSERT INTO webhook_deliveries (id, image_id, owner, url, event, payload, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, uuid.New(), image.ID, image.Owner, image.CallbackURL, event, payload, models.DeliveryStatusPending)
+		if err != nil {
+			return count, fmt.Errorf("failed to record delivery: %w", err)
+		}
+		count++
+	}
+
+	// Anonymous uploads have no account to subscribe with
+	if image.Owner == "" {
+		return count, nil
+	}
+
+	tag, err := r.db.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, image_id, subscription_id, owner, url, event, payload, status)
+		SELECT gen_random_uuid(), $1, s.id, s.owner, s.url, $2, $3, $4
+		FROM webhook_subscriptions s
+		WHERE s.owner = $5
+	`, image.ID, event, payload, models.DeliveryStatusPending, image.Owner)
+	if err != nil {
+		return count, fmt.Errorf("failed to record subscription deliveries: %w", err)
+	}
+	return count + int(tag.RowsAffected()), nil
+}
+
+// ListDeliveries returns deliveries matching opts, newest first
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, opts repository.DeliveryListOptions) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE owner = $1`
+	args := []any{opts.Owner}
+	if opts.Status != "" {
+		args = append(args, opts.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if opts.ImageID != uuid.Nil {
+		args = append(args, opts.ImageID)
+		query += fmt.Sprintf(" AND image_id = $%d", len(args))
+	}
+	args = append(args, opts.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+	deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.WebhookDelivery])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// GetDelivery returns a delivery of owner or repository.ErrDeliveryNotFound
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id uuid.UUID, owner string) (*models.WebhookDelivery, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = $1 AND owner = $2`, id, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load delivery: %w", err)
+	}
+	delivery, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.WebhookDelivery])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// ReplayDelivery makes a failed delivery due again with a fresh set of attempts
+func (r *WebhookRepository) ReplayDelivery(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status = $3
+	`, models.DeliveryStatusPending, id, models.DeliveryStatusFailed)
+	if err != nil {
+		return fmt.Errorf("failed to replay delivery: %w", err)
+	}
+	return nil
+}
+
+// ClaimDeliveries claims due deliveries by pushing their next attempt past
+// timeout. Rows are locked with SKIP LOCKED, so concurrent dispatchers claim
+// different deliveries. Subscription deliveries are signed with the
+// subscription's secret and callbacks with the secret of their image.
+func (r *WebhookRepository) ClaimDeliveries(ctx context.Context, limit int, timeout time.Duration) ([]repository.ClaimedDelivery, error) {
+	rows, err := r.db.Query(ctx, `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $3::interval, updated_at = NOW()
+		FROM due
+		WHERE d.id = due.id
+		RETURNING d.id, d.image_id, d.subscription_id, d.owner, d.url, d.event, d.payload, d.status,
+			d.attempts, d.last_error, d.response_code, d.next_attempt_at, d.created_at, d.updated_at,
+			CASE WHEN d.subscription_id IS NULL
+				THEN COALESCE((SELECT i.callback_secret FROM images i WHERE i.id = d.image_id), '')
+				ELSE COALESCE((SELECT s.secret FROM webhook_subscriptions s WHERE s.id = d.subscription_id), '')
+			END AS secret
+	`, models.DeliveryStatusPending, limit, timeout.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+	claimed, err := pgx.CollectRows(rows, pgx.RowToStructByName[repository.ClaimedDelivery])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deliveries: %w", err)
+	}
+	return claimed, nil
+}
+
+// RecordAttempt records the outcome of sending a delivery
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, id uuid.UUID, attempt repository.DeliveryAttempt) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, response_code = $3, last_error = $4, next_attempt_at = $5, updated_at = NOW()
+		WHERE id = $6
+	`, attempt.Status, attempt.Attempts, attempt.ResponseCode, attempt.LastError, attempt.NextAttemptAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to record delivery: %w", err)
+	}
+	return nil
+}
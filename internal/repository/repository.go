@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"image-processor/internal/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned for images that do not exist or were deleted
+	ErrNotFound = errors.New("image not found")
+	// ErrConflict is returned when an image is not in a status a change
+	// applies to
+	ErrConflict = errors.New("image status conflict")
+	// ErrNotHashed is returned when searching for images similar to one
+	// whose perceptual hashes have not been computed
+	ErrNotHashed = errors.New("image has no perceptual hashes")
+	// ErrUploadNotFound is returned for images without an upload session
+	ErrUploadNotFound = errors.New("upload session not found")
+	// ErrWebhookNotFound is returned for subscriptions that do not exist or
+	// belong to another owner
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrDeliveryNotFound is returned for webhook deliveries that do not
+	// exist or belong to another owner
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// Transition changes the status of an image together with the fields that
+// change with it. The image's error is replaced by Error, so it is cleared
+// by every transition that does not set one.
+type Transition struct {
+	// From lists the statuses the image must be in; empty matches any
+	From     []models.ImageStatus
+	To       models.ImageStatus
+	Error    string
+	Checksum string // recorded when not empty
+	// UpdatedAt, when set, must match the image's, so the change is lost to
+	// any other change made since the image was read
+	UpdatedAt time.Time
+}
+
+// ListOptions select a page of images, newest first. Zero values of the
+// filters match any image.
+type ListOptions struct {
+	Owner  string
+	Status models.ImageStatus
+	Before time.Time // only images created before this; zero for the first page
+	Limit  int
+
+	Format      string // jpeg, png or tiff
+	MinWidth    int
+	MinHeight   int
+	CameraMake  string // case-insensitive
+	CameraModel string // case-insensitive
+	TakenAfter  time.Time
+	TakenBefore time.Time
+	HasGPS      *bool
+}
+
+// SimilarOptions select the images whose hash is within MaxDistance bits of
+// another image's
+type SimilarOptions struct {
+	Algorithm   string // models.HashAverage, HashDifference or HashDCT
+	MaxDistance int
+	Limit       int
+	Owner       string // only images of this owner; empty searches all owners
+}
+
+// SimilarImage is an image found by a similarity search
+type SimilarImage struct {
+	models.Image
+	Distance int // Hamming distance to the searched image's hash
+}
+
+// Object identifies a stored object
+type Object struct {
+	Bucket string
+	Key    string
+}
+
+// Duplicate is the outcome of linking an image to an identical one
+type Duplicate struct {
+	SourceID uuid.UUID
+	// Unreferenced lists the objects the image referenced before that no
+	// image references anymore. The caller deletes them.
+	Unreferenced []Object
+}
+
+// ImageRepository stores image records. Deleted images are invisible to
+// every method but All; the retention janitor purges them later.
+type ImageRepository interface {
+	// Create saves a new image record
+	Create(ctx context.Context, image *models.Image) error
+	// Get returns an image or ErrNotFound
+	Get(ctx context.Context, id uuid.UUID) (*models.Image, error)
+	// List returns images matching opts, newest first
+	List(ctx context.Context, opts ListOptions) ([]models.Image, error)
+	// All returns every image, soft-deleted ones included, for tools that
+	// compare the records with storage
+	All(ctx context.Context) ([]models.Image, error)
+
+	// Transition changes an image's status and returns the updated image.
+	// It returns ErrConflict if the image is not in one of t.From.
+	Transition(ctx context.Context, id uuid.UUID, t Transition) (*models.Image, error)
+	// MarkDeleted soft-deletes an image. Images still uploading or being
+	// processed cannot be deleted and return ErrConflict.
+	MarkDeleted(ctx context.Context, id uuid.UUID) error
+
+	// SetOriginal records the name and key of an original stored after the
+	// image was created, as with imports
+	SetOriginal(ctx context.Context, id uuid.UUID, filename, key string) error
+	// SetChecksum records the SHA-256 of the original
+	SetChecksum(ctx context.Context, id uuid.UUID, checksum string) error
+	// SetProcessed records the location of the processed image and the
+	// pipeline that produced it
+	SetProcessed(ctx context.Context, id uuid.UUID, bucket, key, pipeline string) error
+	// SetMetadata records the properties read from the original
+	SetMetadata(ctx context.Context, id uuid.UUID, meta models.ImageMetadata) error
+	// SetHashes records the perceptual hashes of the decoded original
+	SetHashes(ctx context.Context, id uuid.UUID, hashes models.PerceptualHashes) error
+	// SetPlaceholder records the BlurHash and dominant colors of the decoded
+	// original
+	SetPlaceholder(ctx context.Context, id uuid.UUID, placeholder models.Placeholder) error
+	// Similar returns images of any owner whose hash is close to that of
+	// image id, closest first. It returns ErrNotHashed if that image has no
+	// hashes yet.
+	Similar(ctx context.Context, id uuid.UUID, opts SimilarOptions) ([]SimilarImage, error)
+
+	// LinkDuplicate points image id at the objects of a completed image of
+	// any owner with the same checksum and pipeline, copies what was read
+	// from that image's original and marks image id completed. Objects
+	// shared this way are reference counted. It returns nil, changing
+	// nothing, if there is no such image.
+	LinkDuplicate(ctx context.Context, id uuid.UUID, checksum, pipeline string) (*Duplicate, error)
+
+	// Touch records that the image's content was served
+	Touch(ctx context.Context, id uuid.UUID) error
+}
+
+// InFlight reports whether an image is still being uploaded or processed
+func InFlight(status models.ImageStatus) bool {
+	switch status {
+	case models.ImageStatusUploading, models.ImageStatusPending, models.ImageStatusProcessing:
+		return true
+	}
+	return false
+}
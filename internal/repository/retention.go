@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"image-processor/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Retention rules, in the order the janitor runs them
+const (
+	RetentionDeleted   = "deleted"   // purge soft-deleted images after the grace period
+	RetentionOriginals = "originals" // delete originals some time after processing
+	RetentionProcessed = "processed" // expire processed outputs of inactive images
+)
+
+// RetentionRepository finds the images a retention rule applies to and
+// applies it. Every image is changed in its own transaction.
+type RetentionRepository interface {
+	// Candidates returns up to limit IDs greater than after, in order, of
+	// images the rule applies to with the given cutoff
+	Candidates(ctx context.Context, rule string, cutoff time.Time, after uuid.UUID, limit int) ([]uuid.UUID, error)
+	// Apply checks again that the rule applies to image id and applies it.
+	// It returns the image as it was before and the objects no image
+	// references anymore, which the caller deletes. The image is nil when
+	// the rule no longer applies or another transaction holds the image.
+	// With dryRun the change is rolled back, but the objects returned are
+	// still those a real run would release.
+	Apply(ctx context.Context, rule string, cutoff time.Time, id uuid.UUID, dryRun bool) (*models.Image, []Object, error)
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"image-processor/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// UploadRepository stores the sessions of uploads that have not finished.
+// A session belongs to an image in the uploading status and is removed in
+// the same transaction that moves the image on.
+type UploadRepository interface {
+	// Create saves a new image together with its upload session
+	Create(ctx context.Context, image *models.Image, session *models.UploadSession) error
+	// Get returns the session of an image or ErrUploadNotFound
+	Get(ctx context.Context, imageID uuid.UUID) (*models.UploadSession, error)
+	// SaveProgress records the offset, parts, hash state and expiry of a
+	// session
+	SaveProgress(ctx context.Context, session *models.UploadSession) error
+	// Expired returns up to limit sessions that expired before now
+	Expired(ctx context.Context, now time.Time, limit int) ([]models.UploadSession, error)
+	// Objects returns the objects of every unfinished upload
+	Objects(ctx context.Context) ([]Object, error)
+
+	// Finish removes the session of an image and applies t to the image,
+	// returning the updated image. Nothing changes if t fails.
+	Finish(ctx context.Context, imageID uuid.UUID, t Transition) (*models.Image, error)
+	// Discard removes the session of an image and applies t to the image
+	// if it is still in one of t.From. It reports whether the image changed.
+	Discard(ctx context.Context, imageID uuid.UUID, t Transition) (bool, error)
+}
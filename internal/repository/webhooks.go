@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"image-processor/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// DeliveryListOptions select an owner's webhook deliveries, newest first.
+// Zero values of the filters match any delivery.
+type DeliveryListOptions struct {
+	Owner   string
+	Status  models.DeliveryStatus
+	ImageID uuid.UUID
+	Limit   int
+}
+
+// ClaimedDelivery is a due delivery together with the secret that signs it
+type ClaimedDelivery struct {
+	models.WebhookDelivery
+	Secret string `db:"secret"`
+}
+
+// DeliveryAttempt is the outcome of sending a delivery
+type DeliveryAttempt struct {
+	Status        models.DeliveryStatus
+	Attempts      int
+	ResponseCode  int
+	LastError     string
+	NextAttemptAt time.Time
+}
+
+// WebhookRepository stores account subscriptions and the deliveries made to
+// them and to per-upload callbacks. Deliveries are sent by the dispatcher.
+type WebhookRepository interface {
+	// CreateSubscription saves a new subscription and sets its CreatedAt
+	CreateSubscription(ctx context.Context, subscription *models.WebhookSubscription) error
+	// ListSubscriptions returns the subscriptions of an owner, oldest first
+	ListSubscriptions(ctx context.Context, owner string) ([]models.WebhookSubscription, error)
+	// DeleteSubscription removes a subscription of owner along with its
+	// deliveries, or returns ErrWebhookNotFound
+	DeleteSubscription(ctx context.Context, id uuid.UUID, owner string) error
+
+	// RecordDeliveries records a pending delivery of payload to the image's
+	// callback URL, if it has one, and to every subscription of its owner.
+	// It returns how many were recorded.
+	RecordDeliveries(ctx context.Context, image *models.Image, event string, payload []byte) (int, error)
+	// ListDeliveries returns deliveries matching opts, newest first
+	ListDeliveries(ctx context.Context, opts DeliveryListOptions) ([]models.WebhookDelivery, error)
+	// GetDelivery returns a delivery of owner or ErrDeliveryNotFound
+	GetDelivery(ctx context.Context, id uuid.UUID, owner string) (*models.WebhookDelivery, error)
+	// ReplayDelivery makes a failed delivery due again with a fresh set of
+	// attempts. Deliveries in any other status are left alone.
+	ReplayDelivery(ctx context.Context, id uuid.UUID) error
+
+	// ClaimDeliveries returns up to limit pending deliveries that are due and
+	// hides them from other callers for timeout, so any number of
+	// dispatchers can share the deliveries
+	ClaimDeliveries(ctx context.Context, limit int, timeout time.Duration) ([]ClaimedDelivery, error)
+	// RecordAttempt records the outcome of sending a delivery
+	RecordAttempt(ctx context.Context, id uuid.UUID, attempt DeliveryAttempt) error
+}
@@ -0,0 +1,225 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"image-processor/internal/config"
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+	redisclient "image-processor/pkg/database/redis"
+
+	"github.com/google/uuid"
+)
+
+// Rules enforced by the janitor, in the order they run
+const (
+	RuleDeleted   = repository.RetentionDeleted   // purge soft-deleted images after the grace period
+	RuleOriginals = repository.RetentionOriginals // delete originals some time after processing
+	RuleProcessed = repository.RetentionProcessed // expire processed outputs of inactive images
+)
+
+// Policy holds the retention rules. A zero duration disables a rule.
+type Policy struct {
+	OriginalsAfter         time.Duration
+	ProcessedInactiveAfter time.Duration
+	DeletedGrace           time.Duration
+	BatchSize              int
+}
+
+// PolicyFromConfig reads the retention rules from the configuration
+func PolicyFromConfig(cfg *config.Config) Policy {
+	return Policy{
+		OriginalsAfter:         cfg.RetentionOriginalsAfter,
+		ProcessedInactiveAfter: cfg.RetentionProcessedInactiveAfter,
+		DeletedGrace:           cfg.RetentionDeletedGrace,
+		BatchSize:              cfg.RetentionBatchSize,
+	}
+}
+
+// Janitor enforces a Policy. Images are walked in batches and each one is
+// changed in its own transaction, so a failure only affects that image and a
+// run can be interrupted at any point.
+//
+// In dry-run mode every transaction is rolled back and no object is deleted,
+// but reference counts are still consulted, so the report lists exactly the
+// objects a real run would delete.
+type Janitor struct {
+	images      repository.RetentionRepository
+	store       storage.ObjectStore
+	layout      *storage.Layout
+	redisClient *redisclient.Client
+	policy      Policy
+	dryRun      bool
+}
+
+func NewJanitor(images repository.RetentionRepository, store storage.ObjectStore, layout *storage.Layout, redis *redisclient.Client, policy Policy, dryRun bool) *Janitor {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 100
+	}
+	return &Janitor{
+		images:      images,
+		store:       store,
+		layout:      layout,
+		redisClient: redis,
+		policy:      policy,
+		dryRun:      dryRun,
+	}
+}
+
+// Stats counts what one rule did, or would do in a dry run
+type Stats struct {
+	Images  int
+	Objects int
+	Bytes   int64
+	Errors  int
+}
+
+// Report is the outcome of a run
+type Report struct {
+	DryRun   bool
+	Started  time.Time
+	Duration time.Duration
+	Rules    map[string]*Stats
+}
+
+type object struct {
+	bucket, key string
+	size        int64 // -1 when unknown
+}
+
+// rule is a retention rule and its age threshold. Rules that remove the
+// processed output also remove the renders made from it.
+type rule struct {
+	name    string
+	age     time.Duration
+	renders bool
+}
+
+// Run applies every enabled rule once
+func (j *Janitor) Run(ctx context.Context) (*Report, error) {
+	report := &Report{DryRun: j.dryRun, Started: time.Now().UTC(), Rules: make(map[string]*Stats)}
+	defer func() { report.Duration = time.Since(report.Started) }()
+
+	rules := []rule{
+		{name: RuleDeleted, age: j.policy.DeletedGrace, renders: true},
+		{name: RuleOriginals, age: j.policy.OriginalsAfter},
+		{name: RuleProcessed, age: j.policy.ProcessedInactiveAfter, renders: true},
+	}
+
+	for _, r := range rules {
+		stats := &Stats{}
+		report.Rules[r.name] = stats
+		if r.age <= 0 {
+			continue
+		}
+		if err := j.runRule(ctx, r, report.Started.Add(-r.age), stats); err != nil {
+			return report, fmt.Errorf("%s rule: %w", r.name, err)
+		}
+	}
+	return report, nil
+}
+
+// runRule walks the images matching r in batches ordered by ID. Images that
+// fail are counted and skipped, so the walk always makes progress.
+func (j *Janitor) runRule(ctx context.Context, r rule, cutoff time.Time, stats *Stats) error {
+	var after uuid.UUID
+	for {
+		ids, err := j.images.Candidates(ctx, r.name, cutoff, after, j.policy.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := j.applyRule(ctx, r, cutoff, id, stats); err != nil {
+				log.Printf("Retention %s: image %s: %v", r.name, id, err)
+				stats.Errors++
+			}
+		}
+		if len(ids) < j.policy.BatchSize {
+			return nil
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+// applyRule re-checks and applies r to one image, then deletes the objects
+// it no longer needs
+func (j *Janitor) applyRule(ctx context.Context, r rule, cutoff time.Time, id uuid.UUID, stats *Stats) error {
+	image, released, err := j.images.Apply(ctx, r.name, cutoff, id, j.dryRun)
+	if err != nil {
+		return err
+	}
+	if image == nil {
+		return nil
+	}
+	if !j.dryRun {
+		j.invalidateCache(ctx, id)
+	}
+
+	objects := make([]object, len(released))
+	for i, obj := range released {
+		objects[i] = object{obj.Bucket, obj.Key, -1}
+	}
+	if r.renders {
+		renders, err := j.renders(ctx, image)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, renders...)
+	}
+
+	stats.Images++
+	for _, obj := range objects {
+		size := obj.size
+		if size < 0 {
+			if info, err := j.store.StatFile(ctx, obj.bucket, obj.key); err == nil {
+				size = info.Size
+			}
+		}
+		if j.dryRun {
+			log.Printf("Retention %s (dry run): would delete %s/%s of image %s", r.name, obj.bucket, obj.key, id)
+		} else if err := j.store.DeleteFile(ctx, obj.bucket, obj.key); err != nil {
+			log.Printf("Retention %s: failed to delete %s/%s of image %s: %v", r.name, obj.bucket, obj.key, id, err)
+			stats.Errors++
+			continue
+		}
+		stats.Objects++
+		if size > 0 {
+			stats.Bytes += size
+		}
+	}
+	return nil
+}
+
+// renders lists the cached renditions of an image. Renders are never shared.
+func (j *Janitor) renders(ctx context.Context, image *models.Image) ([]object, error) {
+	prefix, ok := j.layout.RenderPrefix(storage.KeyParams{ID: image.ID, Tenant: image.Owner, Created: image.CreatedAt})
+	if !ok {
+		return nil, nil
+	}
+	infos, err := j.store.ListFiles(ctx, j.layout.ProcessedBucket, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list renders: %w", err)
+	}
+	objects := make([]object, len(infos))
+	for i, info := range infos {
+		objects[i] = object{j.layout.ProcessedBucket, info.Key, info.Size}
+	}
+	return objects, nil
+}
+
+func (j *Janitor) invalidateCache(ctx context.Context, imageID uuid.UUID) {
+	if j.redisClient == nil {
+		return
+	}
+	if err := j.redisClient.Delete(ctx, redisclient.ImageKey(imageID.String())); err != nil {
+		log.Printf("Warning: failed to invalidate cache for image %s: %v", imageID, err)
+	}
+}
@@ -0,0 +1,100 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteMetrics writes the report in the Prometheus text exposition format
+func (r *Report) WriteMetrics(w io.Writer) error {
+	names := make([]string, 0, len(r.Rules))
+	for name := range r.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	gauge := func(metric, help string, value func(*Stats) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", metric, help, metric)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{rule=%q,dry_run=\"%t\"} %g\n", metric, name, r.DryRun, value(r.Rules[name]))
+		}
+	}
+	gauge("image_janitor_images", "Images affected by the last janitor run.", func(s *Stats) float64 { return float64(s.Images) })
+	gauge("image_janitor_objects_deleted", "Objects deleted by the last janitor run.", func(s *Stats) float64 { return float64(s.Objects) })
+	gauge("image_janitor_bytes_deleted", "Bytes deleted by the last janitor run.", func(s *Stats) float64 { return float64(s.Bytes) })
+	gauge("image_janitor_errors", "Errors in the last janitor run.", func(s *Stats) float64 { return float64(s.Errors) })
+
+	fmt.Fprintf(&b, "# HELP image_janitor_last_run_timestamp_seconds Start of the last janitor run.\n")
+	fmt.Fprintf(&b, "# TYPE image_janitor_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "image_janitor_last_run_timestamp_seconds %d\n", r.Started.Unix())
+	fmt.Fprintf(&b, "# HELP image_janitor_duration_seconds Duration of the last janitor run.\n")
+	fmt.Fprintf(&b, "# TYPE image_janitor_duration_seconds gauge\n")
+	fmt.Fprintf(&b, "image_janitor_duration_seconds %g\n", r.Duration.Seconds())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMetricsFile replaces path with the report's metrics, e.g. for the
+// node_exporter textfile collector. The file is renamed into place so it is
+// never read half-written.
+func (r *Report) WriteMetricsFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".janitor-*.prom")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := r.WriteMetrics(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// PushMetrics sends the report's metrics to a Prometheus Pushgateway,
+// replacing those previously pushed for the job
+func (r *Report) PushMetrics(ctx context.Context, gatewayURL, job string) error {
+	var body bytes.Buffer
+	if err := r.WriteMetrics(&body); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	url := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/" + job
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, &body)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push metrics: pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"fmt"
+
+	"image-processor/internal/config"
+	"image-processor/internal/storage"
+	"image-processor/internal/storage/local"
+	minioclient "image-processor/internal/storage/minio"
+)
+
+// New creates the object store selected by cfg.StorageBackend and makes
+// sure the given buckets exist
+func New(cfg *config.Config, buckets []string) (storage.ObjectStore, error) {
+	encrypted := cfg.StorageEncryption != "" && cfg.StorageEncryption != minioclient.EncryptionNone
+	if encrypted && cfg.StorageBackend != "minio" && cfg.StorageBackend != "s3" {
+		return nil, fmt.Errorf("storage encryption is only supported by the minio backend")
+	}
+
+	switch cfg.StorageBackend {
+	case "minio", "s3":
+		var keys minioclient.KeyProvider
+		if cfg.StorageEncryption == minioclient.EncryptionSSEC {
+			provider, err := minioclient.NewLocalKeyProvider(cfg.StorageEncryptionKeys)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+			}
+			keys = provider
+		}
+		client, err := minioclient.NewClient(minioclient.Options{
+			Endpoint:             cfg.MinioEndpoint,
+			UseSSL:               cfg.MinioUseSSL,
+			CAFile:               cfg.MinioCAFile,
+			Region:               cfg.MinioRegion,
+			BucketLookup:         cfg.MinioBucketLookup,
+			Encryption:           cfg.StorageEncryption,
+			Keys:                 keys,
+			Credentials:          cfg.MinioCredentials,
+			AccessKey:            cfg.MinioAccessKey,
+			SecretKey:            cfg.MinioSecretKey,
+			CredentialsFile:      cfg.MinioCredentialsFile,
+			CredentialsProfile:   cfg.MinioCredentialsProfile,
+			STSEndpoint:          cfg.MinioSTSEndpoint,
+			WebIdentityTokenFile: cfg.MinioWebIdentityTokenFile,
+			RoleARN:              cfg.MinioRoleARN,
+		}, buckets)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case "local":
+		store, err := local.NewStore(cfg.StorageLocalPath, buckets)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "memory":
+		// The gateway, the worker and the tools run as separate processes,
+		// none of which would see the objects written by another
+		return nil, fmt.Errorf("the memory storage backend is not shared between processes and is only for tests")
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (expected minio or local)", cfg.StorageBackend)
+	}
+}
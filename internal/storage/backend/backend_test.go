@@ -0,0 +1,14 @@
+package backend
+
+import (
+	"testing"
+
+	"image-processor/internal/config"
+)
+
+func TestNewRefusesMemory(t *testing.T) {
+	cfg := &config.Config{StorageBackend: "memory"}
+	if _, err := New(cfg, []string{"bucket"}); err == nil {
+		t.Error("New accepted the memory backend, which processes cannot share")
+	}
+}
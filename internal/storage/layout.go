@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"image-processor/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// Placeholders understood in key templates
+const (
+	PlaceholderID      = "{id}"
+	PlaceholderTenant  = "{tenant}"
+	PlaceholderYear    = "{yyyy}"
+	PlaceholderMonth   = "{mm}"
+	PlaceholderDay     = "{dd}"
+	PlaceholderVariant = "{variant}"
+	PlaceholderExt     = "{ext}"
+)
+
+// AnonymousTenant is used for {tenant} when an image has no owner
+const AnonymousTenant = "anonymous"
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+	unsafeTenantChars  = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// KeyParams are the values substituted into a key template
+type KeyParams struct {
+	ID      uuid.UUID
+	Tenant  string    // the image owner; empty for anonymous uploads
+	Created time.Time // partitions keys by date
+	Variant string
+	Ext     string // without the leading dot
+}
+
+// Layout decides the bucket and key of every stored object. Keys are
+// resolved once, when an object is first written, and stored with the image
+// so changing the templates later does not orphan existing objects.
+type Layout struct {
+	RawBucket       string
+	ProcessedBucket string
+
+	originalTemplate  string
+	processedTemplate string
+	renderTemplate    string
+}
+
+// NewLayout validates the configured buckets and key templates
+func NewLayout(cfg *config.Config) (*Layout, error) {
+	l := &Layout{
+		RawBucket:         cfg.RawBucket,
+		ProcessedBucket:   cfg.ProcessedBucket,
+		originalTemplate:  strings.TrimPrefix(cfg.OriginalKeyTemplate, "/"),
+		processedTemplate: strings.TrimPrefix(cfg.ProcessedKeyTemplate, "/"),
+		renderTemplate:    strings.TrimPrefix(cfg.RenderKeyTemplate, "/"),
+	}
+	if l.RawBucket == "" || l.ProcessedBucket == "" {
+		return nil, fmt.Errorf("bucket names must not be empty")
+	}
+
+	templates := map[string]string{
+		"original":  l.originalTemplate,
+		"processed": l.processedTemplate,
+		"render":    l.renderTemplate,
+	}
+	for name, template := range templates {
+		for _, placeholder := range placeholderPattern.FindAllString(template, -1) {
+			switch placeholder {
+			case PlaceholderID, PlaceholderTenant, PlaceholderYear, PlaceholderMonth, PlaceholderDay, PlaceholderVariant, PlaceholderExt:
+			default:
+				return nil, fmt.Errorf("%s key template: unknown placeholder %s", name, placeholder)
+			}
+		}
+		if !strings.Contains(template, PlaceholderID) {
+			return nil, fmt.Errorf("%s key template must contain %s", name, PlaceholderID)
+		}
+	}
+	if !strings.Contains(l.renderTemplate, PlaceholderVariant) {
+		return nil, fmt.Errorf("render key template must contain %s", PlaceholderVariant)
+	}
+
+	// Originals, processed images and renders must never overwrite each other
+	sample := KeyParams{ID: uuid.New(), Created: time.Now(), Ext: "png"}
+	original, processed := l.OriginalKey(sample), l.ProcessedKey(sample)
+	sample.Variant = "0123456789abcdef"
+	render := l.RenderKey(sample)
+	sameBucket := l.RawBucket == l.ProcessedBucket
+	if sameBucket && (original == processed || original == render) || processed == render {
+		return nil, fmt.Errorf("key templates must produce distinct keys for originals, processed images and renders")
+	}
+
+	return l, nil
+}
+
+// Buckets returns the distinct buckets the layout writes to
+func (l *Layout) Buckets() []string {
+	if l.RawBucket == l.ProcessedBucket {
+		return []string{l.RawBucket}
+	}
+	return []string{l.RawBucket, l.ProcessedBucket}
+}
+
+// OriginalKey returns the key of an uploaded original in RawBucket
+func (l *Layout) OriginalKey(p KeyParams) string {
+	p.Variant = "original"
+	return expand(l.originalTemplate, p)
+}
+
+// ProcessedKey returns the key of the worker output in ProcessedBucket
+func (l *Layout) ProcessedKey(p KeyParams) string {
+	p.Variant = "processed"
+	return expand(l.processedTemplate, p)
+}
+
+// RenderKey returns the key of a cached rendition in ProcessedBucket.
+// p.Variant identifies the rendition.
+func (l *Layout) RenderKey(p KeyParams) string {
+	return expand(l.renderTemplate, p)
+}
+
+// RenderPrefix returns the key prefix shared by every rendition of an image.
+// It reports false when the prefix could also match renditions of other
+// images, i.e. when {id} only appears after {variant} in the template.
+func (l *Layout) RenderPrefix(p KeyParams) (string, bool) {
+	template, _, _ := strings.Cut(l.renderTemplate, PlaceholderVariant)
+	if !strings.Contains(template, PlaceholderID) {
+		return "", false
+	}
+	return expand(template, p), true
+}
+
+func expand(template string, p KeyParams) string {
+	tenant := unsafeTenantChars.ReplaceAllString(p.Tenant, "_")
+	if tenant == "" || tenant == "." || tenant == ".." {
+		tenant = AnonymousTenant
+	}
+	created := p.Created.UTC()
+
+	return strings.NewReplacer(
+		PlaceholderID, p.ID.String(),
+		PlaceholderTenant, tenant,
+		PlaceholderYear, created.Format("2006"),
+		PlaceholderMonth, created.Format("01"),
+		PlaceholderDay, created.Format("02"),
+		PlaceholderVariant, p.Variant,
+		PlaceholderExt, strings.TrimPrefix(p.Ext, "."),
+	).Replace(template)
+}
@@ -0,0 +1,416 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"image-processor/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// metaSuffix marks the sidecar file holding an object's metadata
+const metaSuffix = ".meta.json"
+
+// uploadsDir holds in-progress multipart uploads, next to the buckets
+const uploadsDir = ".uploads"
+
+var _ storage.ObjectStore = (*Store)(nil)
+
+// Store keeps objects as files under <root>/<bucket>/<key>, with the content
+// type and ETag in a JSON sidecar next to each file. Writes go to a temporary
+// file that is renamed into place, so readers never see partial objects.
+// Presigned URLs are not supported.
+type Store struct {
+	root string
+}
+
+type metadata struct {
+	ContentType string           `json:"content_type"`
+	ETag        string           `json:"etag"`
+	User        storage.Metadata `json:"user"`
+}
+
+type uploadMetadata struct {
+	Bucket      string           `json:"bucket"`
+	Key         string           `json:"key"`
+	ContentType string           `json:"content_type"`
+	User        storage.Metadata `json:"user"`
+}
+
+// NewStore creates a Store rooted at dir and creates the given buckets
+func NewStore(dir string, buckets []string) (*Store, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage path: %w", err)
+	}
+	for _, bucket := range append(buckets, uploadsDir) {
+		if err := os.MkdirAll(filepath.Join(root, bucket), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+		}
+	}
+
+	log.Printf("Local object store initialized at %s with buckets: %v", root, buckets)
+	return &Store{root: root}, nil
+}
+
+// UploadFile stores an object, reading until EOF
+func (s *Store) UploadFile(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, opts storage.PutOptions) (storage.ObjectInfo, error) {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	hash := md5.New()
+	if err := writeAtomic(path, io.TeeReader(reader, hash), size); err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	meta := metadata{ContentType: opts.ContentType, ETag: hex.EncodeToString(hash.Sum(nil)), User: opts.Metadata}
+	if err := writeMetadata(path, meta); err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	log.Printf("Successfully uploaded %s to bucket %s", objectName, bucketName)
+	return s.StatFile(ctx, bucketName, objectName)
+}
+
+// DownloadFile opens an object for reading
+func (s *Store) DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
+	file, _, err := s.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenFile opens an object for random access and returns it with its metadata
+func (s *Store) OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, fmt.Errorf("failed to open file: %w", mapError(err))
+	}
+	info, err := s.statPath(path, objectName)
+	if err != nil {
+		file.Close()
+		return nil, storage.ObjectInfo{}, err
+	}
+	return file, info, nil
+}
+
+// StatFile returns the metadata of an object
+func (s *Store) StatFile(ctx context.Context, bucketName, objectName string) (storage.ObjectInfo, error) {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	return s.statPath(path, objectName)
+}
+
+// DeleteFile removes an object. Deleting a missing object is not an error.
+func (s *Store) DeleteFile(ctx context.Context, bucketName, objectName string) error {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{path, path + metaSuffix} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+	}
+
+	log.Printf("Deleted %s from bucket %s", objectName, bucketName)
+	return nil
+}
+
+// ListFiles returns the objects whose keys start with prefix, sorted by key
+func (s *Store) ListFiles(ctx context.Context, bucketName, prefix string) ([]storage.ObjectInfo, error) {
+	bucketPath, err := s.bucketPath(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []storage.ObjectInfo
+	err = filepath.WalkDir(bucketPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, metaSuffix) || strings.Contains(d.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := s.statPath(path, key)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// UpdateMetadata rewrites the sidecar of an object
+func (s *Store) UpdateMetadata(ctx context.Context, bucketName, objectName string, meta storage.Metadata) error {
+	path, err := s.objectPath(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", mapError(err))
+	}
+
+	current := readMetadata(path)
+	current.User = meta
+	return writeMetadata(path, current)
+}
+
+// GetFileLink is not supported: files are only reachable through the gateway
+func (s *Store) GetFileLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+// GetUploadLink is not supported: files are only reachable through the gateway
+func (s *Store) GetUploadLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+// NewMultipartUpload starts a multipart upload whose parts are kept under
+// <root>/.uploads/<upload ID> until it is completed or aborted
+func (s *Store) NewMultipartUpload(ctx context.Context, bucketName, objectName string, opts storage.PutOptions) (string, error) {
+	if _, err := s.objectPath(bucketName, objectName); err != nil {
+		return "", err
+	}
+
+	uploadID := uuid.NewString()
+	dir := filepath.Join(s.root, uploadsDir, uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	data, err := json.Marshal(uploadMetadata{Bucket: bucketName, Key: objectName, ContentType: opts.ContentType, User: opts.Metadata})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	return uploadID, nil
+}
+
+// UploadPart stores one part of a multipart upload and returns its ETag
+func (s *Store) UploadPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	dir, err := s.uploadDir(bucketName, objectName, uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	hash := md5.New()
+	if err := writeAtomic(partPath(dir, partNumber), io.TeeReader(reader, hash), size); err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CompleteMultipartUpload concatenates the parts into the final object
+func (s *Store) CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, etags []string) error {
+	dir, err := s.uploadDir(bucketName, objectName, uploadID)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	var upload uploadMetadata
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	parts := make([]io.Reader, len(etags))
+	for i := range etags {
+		part, err := os.Open(partPath(dir, i+1))
+		if err != nil {
+			return fmt.Errorf("failed to complete multipart upload: %w", mapError(err))
+		}
+		defer part.Close()
+		parts[i] = part
+	}
+
+	if _, err := s.UploadFile(ctx, bucketName, objectName, io.MultiReader(parts...), -1, storage.PutOptions{ContentType: upload.ContentType, Metadata: upload.User}); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	log.Printf("Successfully assembled %s in bucket %s from %d parts", objectName, bucketName, len(etags))
+	return os.RemoveAll(dir)
+}
+
+// AbortMultipartUpload discards a multipart upload and any parts already stored
+func (s *Store) AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error {
+	dir, err := s.uploadDir(bucketName, objectName, uploadID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// bucketPath returns the directory of an existing bucket
+func (s *Store) bucketPath(bucketName string) (string, error) {
+	if bucketName == "" || bucketName == uploadsDir || !filepath.IsLocal(bucketName) || strings.ContainsAny(bucketName, `/\`) {
+		return "", fmt.Errorf("invalid bucket name %q", bucketName)
+	}
+	path := filepath.Join(s.root, bucketName)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("bucket %s: %w", bucketName, mapError(err))
+	}
+	return path, nil
+}
+
+// objectPath maps a key to a file path, refusing keys that would escape the bucket
+func (s *Store) objectPath(bucketName, objectName string) (string, error) {
+	bucketPath, err := s.bucketPath(bucketName)
+	if err != nil {
+		return "", err
+	}
+	rel := filepath.FromSlash(objectName)
+	if objectName == "" || !filepath.IsLocal(rel) || strings.HasSuffix(objectName, metaSuffix) {
+		return "", fmt.Errorf("invalid object name %q", objectName)
+	}
+	return filepath.Join(bucketPath, rel), nil
+}
+
+// uploadDir returns the directory of a multipart upload after checking it
+// belongs to the given object
+func (s *Store) uploadDir(bucketName, objectName, uploadID string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", fmt.Errorf("multipart upload %s: %w", uploadID, storage.ErrNotFound)
+	}
+	dir := filepath.Join(s.root, uploadsDir, uploadID)
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return "", fmt.Errorf("multipart upload %s: %w", uploadID, mapError(err))
+	}
+	var upload uploadMetadata
+	if err := json.Unmarshal(data, &upload); err != nil || upload.Bucket != bucketName || upload.Key != objectName {
+		return "", fmt.Errorf("multipart upload %s: %w", uploadID, storage.ErrNotFound)
+	}
+	return dir, nil
+}
+
+func (s *Store) statPath(path, objectName string) (storage.ObjectInfo, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to stat file: %w", mapError(err))
+	}
+
+	meta := readMetadata(path)
+	if meta.ContentType == "" {
+		meta.ContentType = "application/octet-stream"
+	}
+
+	return storage.ObjectInfo{
+		Key:          objectName,
+		Size:         fi.Size(),
+		ContentType:  meta.ContentType,
+		ETag:         meta.ETag,
+		LastModified: fi.ModTime(),
+		Metadata:     meta.User,
+	}, nil
+}
+
+func partPath(dir string, partNumber int) string {
+	return filepath.Join(dir, fmt.Sprintf("part-%05d", partNumber))
+}
+
+// writeAtomic writes r to a temporary file next to path and renames it into
+// place. A size of -1 accepts any length; otherwise a short or long read
+// leaves path untouched.
+func writeAtomic(path string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("read %d bytes, expected %d", written, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readMetadata returns the sidecar of the object at path, or zero values if
+// it is missing or unreadable
+func readMetadata(path string) metadata {
+	var meta metadata
+	if data, err := os.ReadFile(path + metaSuffix); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+func writeMetadata(path string, meta metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if err := writeAtomic(path+metaSuffix, bytes.NewReader(data), -1); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// mapError translates missing files into storage.ErrNotFound
+func mapError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", storage.ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+package local
+
+import (
+	"testing"
+
+	"image-processor/internal/storage"
+	"image-processor/internal/storage/storagetest"
+)
+
+func TestStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ObjectStore {
+		store, err := NewStore(t.TempDir(), []string{storagetest.Bucket})
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		return store
+	})
+}
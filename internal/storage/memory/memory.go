@@ -0,0 +1,300 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"image-processor/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+var _ storage.ObjectStore = (*Store)(nil)
+
+// Store keeps objects in memory. Nothing is persisted and nothing is shared
+// between processes, so it is meant for tests.
+// Presigned URLs are not supported.
+type Store struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]*object
+	uploads map[string]*upload
+}
+
+type object struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+	metadata     storage.Metadata
+}
+
+type upload struct {
+	bucket string
+	key    string
+	opts   storage.PutOptions
+	parts  map[int][]byte
+}
+
+// NewStore creates an empty Store with the given buckets
+func NewStore(buckets []string) *Store {
+	s := &Store{
+		buckets: make(map[string]map[string]*object, len(buckets)),
+		uploads: make(map[string]*upload),
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string]*object)
+	}
+	return s
+}
+
+// UploadFile stores an object, reading until EOF
+func (s *Store) UploadFile(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, opts storage.PutOptions) (storage.ObjectInfo, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to upload file: %w", err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to upload file: read %d bytes, expected %d", len(data), size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, err := s.bucket(bucketName)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	sum := md5.Sum(data)
+	obj := &object{
+		data:         data,
+		contentType:  opts.ContentType,
+		etag:         hex.EncodeToString(sum[:]),
+		lastModified: time.Now().UTC(),
+		metadata:     opts.Metadata,
+	}
+	bucket[objectName] = obj
+	return obj.info(objectName), nil
+}
+
+// DownloadFile opens an object for reading
+func (s *Store) DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
+	reader, _, err := s.OpenFile(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// OpenFile returns a reader over a snapshot of the object
+func (s *Store) OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	obj, err := s.object(bucketName, objectName)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	return nopCloser{bytes.NewReader(obj.data)}, obj.info(objectName), nil
+}
+
+// StatFile returns the metadata of an object
+func (s *Store) StatFile(ctx context.Context, bucketName, objectName string) (storage.ObjectInfo, error) {
+	obj, err := s.object(bucketName, objectName)
+	if err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return obj.info(objectName), nil
+}
+
+// DeleteFile removes an object. Deleting a missing object is not an error.
+func (s *Store) DeleteFile(ctx context.Context, bucketName, objectName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	delete(bucket, objectName)
+	return nil
+}
+
+// ListFiles returns the objects whose keys start with prefix, sorted by key
+func (s *Store) ListFiles(ctx context.Context, bucketName, prefix string) ([]storage.ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bucket, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var objects []storage.ObjectInfo
+	for key, obj := range bucket {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info(key))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// UpdateMetadata replaces the metadata of an object
+func (s *Store) UpdateMetadata(ctx context.Context, bucketName, objectName string, meta storage.Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	obj, ok := bucket[objectName]
+	if !ok {
+		return fmt.Errorf("%s/%s: %w", bucketName, objectName, storage.ErrNotFound)
+	}
+	// Objects are shared with open readers, so replace rather than mutate
+	updated := *obj
+	updated.metadata = meta
+	bucket[objectName] = &updated
+	return nil
+}
+
+// GetFileLink is not supported: objects are only reachable through the gateway
+func (s *Store) GetFileLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+// GetUploadLink is not supported: objects are only reachable through the gateway
+func (s *Store) GetUploadLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+// NewMultipartUpload starts a multipart upload and returns its upload ID
+func (s *Store) NewMultipartUpload(ctx context.Context, bucketName, objectName string, opts storage.PutOptions) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.bucket(bucketName); err != nil {
+		return "", err
+	}
+	uploadID := uuid.NewString()
+	s.uploads[uploadID] = &upload{
+		bucket: bucketName,
+		key:    objectName,
+		opts:   opts,
+		parts:  make(map[int][]byte),
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores one part of a multipart upload and returns its ETag
+func (s *Store) UploadPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+	if int64(len(data)) != size {
+		return "", fmt.Errorf("failed to upload part %d: read %d bytes, expected %d", partNumber, len(data), size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	up, err := s.upload(bucketName, objectName, uploadID)
+	if err != nil {
+		return "", err
+	}
+	up.parts[partNumber] = data
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CompleteMultipartUpload concatenates the parts into the final object
+func (s *Store) CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, etags []string) error {
+	s.mu.Lock()
+	up, err := s.upload(bucketName, objectName, uploadID)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	var buf bytes.Buffer
+	for i := range etags {
+		part, ok := up.parts[i+1]
+		if !ok {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to complete multipart upload: part %d: %w", i+1, storage.ErrNotFound)
+		}
+		buf.Write(part)
+	}
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+
+	_, err = s.UploadFile(ctx, bucketName, objectName, &buf, int64(buf.Len()), up.opts)
+	return err
+}
+
+// AbortMultipartUpload discards a multipart upload and any parts already stored
+func (s *Store) AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.upload(bucketName, objectName, uploadID); err != nil {
+		return err
+	}
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+// bucket returns a bucket's objects. The caller must hold mu.
+func (s *Store) bucket(bucketName string) (map[string]*object, error) {
+	bucket, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, fmt.Errorf("bucket %s: %w", bucketName, storage.ErrNotFound)
+	}
+	return bucket, nil
+}
+
+func (s *Store) object(bucketName, objectName string) (*object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bucket, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := bucket[objectName]
+	if !ok {
+		return nil, fmt.Errorf("%s/%s: %w", bucketName, objectName, storage.ErrNotFound)
+	}
+	return obj, nil
+}
+
+// upload returns a multipart upload of the given object. The caller must hold mu.
+func (s *Store) upload(bucketName, objectName, uploadID string) (*upload, error) {
+	up, ok := s.uploads[uploadID]
+	if !ok || up.bucket != bucketName || up.key != objectName {
+		return nil, fmt.Errorf("multipart upload %s: %w", uploadID, storage.ErrNotFound)
+	}
+	return up, nil
+}
+
+func (o *object) info(key string) storage.ObjectInfo {
+	return storage.ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		ContentType:  o.contentType,
+		ETag:         o.etag,
+		LastModified: o.lastModified,
+		Metadata:     o.metadata,
+	}
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
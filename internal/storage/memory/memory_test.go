@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"image-processor/internal/storage"
+	"image-processor/internal/storage/storagetest"
+)
+
+func TestStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ObjectStore {
+		return NewStore([]string{storagetest.Bucket})
+	})
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// User metadata keys attached to stored objects
+const (
+	MetaImageID  = "image-id"
+	MetaOwner    = "owner"
+	MetaFilename = "original-filename"
+	MetaChecksum = "checksum-sha256"
+	MetaPipeline = "pipeline"
+)
+
+// Metadata describes the image an object belongs to. It is stored with the
+// object so objects stay self-describing without the database.
+type Metadata struct {
+	ImageID  string `json:"image_id,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	// Checksum is the hex SHA-256 of the object content
+	Checksum string `json:"checksum,omitempty"`
+	// Pipeline is the transform options key of derived objects
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+// PutOptions describe an object being written
+type PutOptions struct {
+	ContentType string
+	Metadata    Metadata
+}
+
+// Map returns the non-empty fields as user metadata. Values are
+// percent-encoded because S3 sends them as HTTP headers.
+func (m Metadata) Map() map[string]string {
+	values := make(map[string]string)
+	for key, value := range map[string]string{
+		MetaImageID:  m.ImageID,
+		MetaOwner:    m.Owner,
+		MetaFilename: m.Filename,
+		MetaChecksum: m.Checksum,
+		MetaPipeline: m.Pipeline,
+	} {
+		if value != "" {
+			values[key] = url.PathEscape(value)
+		}
+	}
+	return values
+}
+
+// ParseMetadata reads metadata written by Map. Keys are matched without
+// regard to case since S3 canonicalises header names.
+func ParseMetadata(values map[string]string) Metadata {
+	var m Metadata
+	for key, value := range values {
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		switch strings.ToLower(key) {
+		case MetaImageID:
+			m.ImageID = value
+		case MetaOwner:
+			m.Owner = value
+		case MetaFilename:
+			m.Filename = value
+		case MetaChecksum:
+			m.Checksum = value
+		case MetaPipeline:
+			m.Pipeline = value
+		}
+	}
+	return m
+}
+
+// Checksum returns the hex SHA-256 of data, as stored in Metadata.Checksum
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,140 @@
+package minio
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"image-processor/internal/storage"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// Server-side encryption modes
+const (
+	EncryptionNone  = "none"
+	EncryptionSSES3 = "sse-s3" // keys managed by the storage service
+	EncryptionSSEC  = "sse-c"  // keys supplied with every request by a KeyProvider
+)
+
+// KeyProvider supplies SSE-C keys
+type KeyProvider interface {
+	// ObjectKeys returns the keys an object may be encrypted with. The first
+	// one is used for new writes; the rest are tried when reading.
+	ObjectKeys(bucketName, objectName string) ([]encrypt.ServerSide, error)
+}
+
+// LocalKeyProvider derives a distinct SSE-C key for every object from master
+// keys held in the process configuration. The first master key encrypts new
+// objects; keys can be rotated by prepending a new one and keeping the old
+// ones until every object has been rewritten.
+type LocalKeyProvider struct {
+	secrets [][]byte
+}
+
+// NewLocalKeyProvider parses master keys given as "id:secret" pairs
+func NewLocalKeyProvider(specs []string) (*LocalKeyProvider, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+
+	provider := &LocalKeyProvider{}
+	seen := make(map[string]bool, len(specs))
+	for i, spec := range specs {
+		id, secret, ok := strings.Cut(spec, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("encryption key %d must have the form id:secret", i+1)
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("encryption key %q must be at least 32 characters", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate encryption key id %q", id)
+		}
+		seen[id] = true
+		provider.secrets = append(provider.secrets, []byte(secret))
+	}
+	return provider, nil
+}
+
+// ObjectKeys derives the key of an object from each master key
+func (p *LocalKeyProvider) ObjectKeys(bucketName, objectName string) ([]encrypt.ServerSide, error) {
+	keys := make([]encrypt.ServerSide, len(p.secrets))
+	for i, secret := range p.secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(bucketName + "/" + objectName))
+		key, err := encrypt.NewSSEC(mac.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// writeEncryption returns the encryption applied to new objects
+func (c *Client) writeEncryption(bucketName, objectName string) (encrypt.ServerSide, error) {
+	switch c.encryption {
+	case EncryptionSSES3:
+		return encrypt.NewSSE(), nil
+	case EncryptionSSEC:
+		keys, err := c.keys.ObjectKeys(bucketName, objectName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+		}
+		return keys[0], nil
+	}
+	return nil, nil
+}
+
+// customerKey returns the SSE-C key for requests on an existing multipart
+// upload, or nil when SSE-C is not in use
+func (c *Client) customerKey(bucketName, objectName string) (encrypt.ServerSide, error) {
+	if c.encryption != EncryptionSSEC {
+		return nil, nil
+	}
+	return c.writeEncryption(bucketName, objectName)
+}
+
+// statObject returns the metadata of an object along with the SSE-C key
+// needed to read it. With SSE-C every known key is tried, then no key at all
+// for objects stored before encryption was enabled.
+func (c *Client) statObject(ctx context.Context, bucketName, objectName string) (minio.ObjectInfo, encrypt.ServerSide, error) {
+	candidates := []encrypt.ServerSide{nil}
+	if c.encryption == EncryptionSSEC {
+		keys, err := c.keys.ObjectKeys(bucketName, objectName)
+		if err != nil {
+			return minio.ObjectInfo{}, nil, fmt.Errorf("failed to derive encryption key: %w", err)
+		}
+		candidates = append(keys, nil)
+	}
+
+	var lastErr error
+	for _, sse := range candidates {
+		info, err := c.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{ServerSideEncryption: sse})
+		if err == nil {
+			return info, sse, nil
+		}
+		lastErr = mapError(err)
+		if errors.Is(lastErr, storage.ErrNotFound) {
+			break
+		}
+	}
+	return minio.ObjectInfo{}, nil, lastErr
+}
+
+// getOptions returns the options for reading an object
+func (c *Client) getOptions(ctx context.Context, bucketName, objectName string) (minio.GetObjectOptions, error) {
+	if c.encryption != EncryptionSSEC {
+		return minio.GetObjectOptions{}, nil
+	}
+	_, sse, err := c.statObject(ctx, bucketName, objectName)
+	if err != nil {
+		return minio.GetObjectOptions{}, err
+	}
+	return minio.GetObjectOptions{ServerSideEncryption: sse}, nil
+}
@@ -2,40 +2,92 @@ package minio
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"strings"
 	"time"
 
+	"image-processor/internal/storage"
+
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/sse"
 )
 
+// StreamPartSize is the multipart chunk size used when uploading objects of
+// unknown length. Each in-flight upload buffers one part in memory.
+const StreamPartSize = 16 << 20 // 16MB
+
+var _ storage.ObjectStore = (*Client)(nil)
+
+// Client is the ObjectStore backed by MinIO or any S3-compatible service
 type Client struct {
 	client *minio.Client
+	core   *minio.Core
+	region string
+
+	encryption string
+	keys       KeyProvider
 }
 
 // NewClient creates a new Minio client and ensures buckets exist
-func NewClient(endpoint, accessKey, secretKey string, useSSL bool) (*Client, error) {
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: useSSL,
+func NewClient(opts Options, buckets []string) (*Client, error) {
+	transport, err := opts.transport()
+	if err != nil {
+		return nil, err
+	}
+	creds, err := opts.credentials(transport)
+	if err != nil {
+		return nil, err
+	}
+	lookup, err := opts.bucketLookup()
+	if err != nil {
+		return nil, err
+	}
+	encryption := strings.ToLower(opts.Encryption)
+	switch encryption {
+	case "", EncryptionNone:
+		encryption = EncryptionNone
+	case EncryptionSSES3:
+	case EncryptionSSEC:
+		if opts.Keys == nil {
+			return nil, errors.New("sse-c encryption needs a key provider")
+		}
+		if !opts.UseSSL {
+			return nil, errors.New("sse-c encryption requires TLS")
+		}
+	default:
+		return nil, fmt.Errorf("unknown encryption mode %q (expected none, sse-s3 or sse-c)", opts.Encryption)
+	}
+
+	minioClient, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       opts.UseSSL,
+		Transport:    transport,
+		Region:       opts.Region,
+		BucketLookup: lookup,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create minio client: %w", err)
 	}
 
-	client := &Client{client: minioClient}
+	client := &Client{
+		client:     minioClient,
+		core:       &minio.Core{Client: minioClient},
+		region:     opts.Region,
+		encryption: encryption,
+		keys:       opts.Keys,
+	}
 
 	// Create buckets if they don't exist
-	buckets := []string{"raw-images", "processed-images"}
 	for _, bucketName := range buckets {
 		if err := client.ensureBucketExists(context.Background(), bucketName); err != nil {
 			return nil, fmt.Errorf("failed to ensure bucket %s exists: %w", bucketName, err)
 		}
 	}
 
-	log.Printf("Minio client initialized successfully with buckets: %v", buckets)
+	log.Printf("Minio client initialized successfully with buckets: %v (encryption: %s)", buckets, encryption)
 	return client, nil
 }
 
@@ -47,7 +99,7 @@ func (c *Client) ensureBucketExists(ctx context.Context, bucketName string) erro
 	}
 
 	if !exists {
-		err = c.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
+		err = c.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: c.region})
 		if err != nil {
 			return fmt.Errorf("failed to create bucket: %w", err)
 		}
@@ -56,24 +108,63 @@ func (c *Client) ensureBucketExists(ctx context.Context, bucketName string) erro
 		log.Printf("Bucket already exists: %s", bucketName)
 	}
 
+	// Default encryption also covers objects that clients PUT directly
+	// through presigned URLs, which cannot carry our headers
+	if c.encryption == EncryptionSSES3 {
+		if err := c.client.SetBucketEncryption(ctx, bucketName, sse.NewConfigurationSSES3()); err != nil {
+			log.Printf("Warning: failed to set default encryption on bucket %s: %v", bucketName, err)
+		}
+	}
+
 	return nil
 }
 
-// UploadFile uploads a file to the specified bucket
-func (c *Client) UploadFile(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, contentType string) (minio.UploadInfo, error) {
-	uploadInfo, err := c.client.PutObject(ctx, bucketName, objectName, reader, size, minio.PutObjectOptions{
-		ContentType: contentType,
-	})
+// UploadFile uploads a file to the specified bucket. A size of -1 streams the
+// reader as a multipart upload without knowing its length in advance.
+func (c *Client) UploadFile(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, put storage.PutOptions) (storage.ObjectInfo, error) {
+	opts, err := c.putOptions(bucketName, objectName, put)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	if size < 0 {
+		opts.PartSize = StreamPartSize
+	}
+
+	uploadInfo, err := c.client.PutObject(ctx, bucketName, objectName, reader, size, opts)
 	if err != nil {
-		return minio.UploadInfo{}, fmt.Errorf("failed to upload file: %w", err)
+		return storage.ObjectInfo{}, fmt.Errorf("failed to upload file: %w", err)
 	}
 
 	log.Printf("Successfully uploaded %s to bucket %s", objectName, bucketName)
-	return uploadInfo, nil
+	return storage.ObjectInfo{
+		Key:          uploadInfo.Key,
+		Size:         uploadInfo.Size,
+		ContentType:  put.ContentType,
+		ETag:         uploadInfo.ETag,
+		LastModified: uploadInfo.LastModified,
+		Metadata:     put.Metadata,
+	}, nil
+}
+
+// putOptions converts storage options into those of a new object
+func (c *Client) putOptions(bucketName, objectName string, put storage.PutOptions) (minio.PutObjectOptions, error) {
+	sse, err := c.writeEncryption(bucketName, objectName)
+	if err != nil {
+		return minio.PutObjectOptions{}, err
+	}
+	return minio.PutObjectOptions{
+		ContentType:          put.ContentType,
+		UserMetadata:         put.Metadata.Map(),
+		ServerSideEncryption: sse,
+	}, nil
 }
 
 // GetFileLink generates a presigned URL for file download
 func (c *Client) GetFileLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error) {
+	// Presigned requests cannot carry customer keys
+	if c.encryption == EncryptionSSEC {
+		return "", storage.ErrNotSupported
+	}
 	presignedURL, err := c.client.PresignedGetObject(ctx, bucketName, objectName, expires, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
@@ -84,10 +175,192 @@ func (c *Client) GetFileLink(ctx context.Context, bucketName, objectName string,
 
 // DownloadFile downloads a file from the specified bucket
 func (c *Client) DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
-	object, err := c.client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
+	opts, err := c.getOptions(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	object, err := c.client.GetObject(ctx, bucketName, objectName, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download file: %w", err)
 	}
 
 	return object, nil
 }
+
+// GetUploadLink generates a presigned URL that allows a client to PUT an object directly
+func (c *Client) GetUploadLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error) {
+	if c.encryption == EncryptionSSEC {
+		return "", storage.ErrNotSupported
+	}
+	presignedURL, err := c.client.PresignedPutObject(ctx, bucketName, objectName, expires)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned upload URL: %w", err)
+	}
+
+	return presignedURL.String(), nil
+}
+
+// StatFile returns the metadata of an object without downloading it
+func (c *Client) StatFile(ctx context.Context, bucketName, objectName string) (storage.ObjectInfo, error) {
+	info, _, err := c.statObject(ctx, bucketName, objectName)
+	if err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	return objectInfo(info), nil
+}
+
+// ListFiles returns the objects whose keys start with prefix
+func (c *Client) ListFiles(ctx context.Context, bucketName, prefix string) ([]storage.ObjectInfo, error) {
+	var objects []storage.ObjectInfo
+	for info := range c.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", info.Err)
+		}
+		objects = append(objects, objectInfo(info))
+	}
+
+	return objects, nil
+}
+
+// UpdateMetadata replaces the user metadata of an object by copying it onto
+// itself. With SSE-C the copy is encrypted with the current key.
+func (c *Client) UpdateMetadata(ctx context.Context, bucketName, objectName string, meta storage.Metadata) error {
+	info, srcKey, err := c.statObject(ctx, bucketName, objectName)
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	dstEncryption, err := c.writeEncryption(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket:          bucketName,
+		Object:          objectName,
+		Encryption:      dstEncryption,
+		UserMetadata:    meta.Map(),
+		ReplaceMetadata: true,
+		ContentType:     info.ContentType,
+	}, minio.CopySrcOptions{
+		Bucket:     bucketName,
+		Object:     objectName,
+		MatchETag:  info.ETag,
+		Encryption: srcKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", mapError(err))
+	}
+
+	return nil
+}
+
+// DeleteFile removes an object from the specified bucket
+func (c *Client) DeleteFile(ctx context.Context, bucketName, objectName string) error {
+	err := c.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	log.Printf("Deleted %s from bucket %s", objectName, bucketName)
+	return nil
+}
+
+// NewMultipartUpload starts a multipart upload and returns its upload ID
+func (c *Client) NewMultipartUpload(ctx context.Context, bucketName, objectName string, put storage.PutOptions) (string, error) {
+	opts, err := c.putOptions(bucketName, objectName, put)
+	if err != nil {
+		return "", err
+	}
+	uploadID, err := c.core.NewMultipartUpload(ctx, bucketName, objectName, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	return uploadID, nil
+}
+
+// UploadPart uploads one part of a multipart upload and returns its ETag
+func (c *Client) UploadPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	key, err := c.customerKey(bucketName, objectName)
+	if err != nil {
+		return "", err
+	}
+	part, err := c.core.PutObjectPart(ctx, bucketName, objectName, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{SSE: key})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, mapError(err))
+	}
+
+	return part.ETag, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the final object.
+// etags[i] must be the ETag returned for part number i+1.
+func (c *Client) CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, etags []string) error {
+	parts := make([]minio.CompletePart, len(etags))
+	for i, etag := range etags {
+		parts[i] = minio.CompletePart{PartNumber: i + 1, ETag: etag}
+	}
+
+	key, err := c.customerKey(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+	_, err = c.core.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, parts, minio.PutObjectOptions{ServerSideEncryption: key})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", mapError(err))
+	}
+
+	log.Printf("Successfully assembled %s in bucket %s from %d parts", objectName, bucketName, len(etags))
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and any parts already stored
+func (c *Client) AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error {
+	if err := c.core.AbortMultipartUpload(ctx, bucketName, objectName, uploadID); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", mapError(err))
+	}
+
+	return nil
+}
+
+// OpenFile opens an object for random access and returns it with its metadata.
+// Reads after a Seek are served with ranged requests.
+func (c *Client) OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	opts, err := c.getOptions(ctx, bucketName, objectName)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	object, err := c.client.GetObject(ctx, bucketName, objectName, opts)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, storage.ObjectInfo{}, fmt.Errorf("failed to stat file: %w", mapError(err))
+	}
+
+	return object, objectInfo(info), nil
+}
+
+func objectInfo(info minio.ObjectInfo) storage.ObjectInfo {
+	return storage.ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		Metadata:     storage.ParseMetadata(info.UserMetadata),
+	}
+}
+
+// mapError translates S3 "not found" responses into storage.ErrNotFound
+func mapError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket", "NoSuchUpload":
+		return fmt.Errorf("%w: %v", storage.ErrNotFound, err)
+	}
+	return err
+}
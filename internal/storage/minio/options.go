@@ -0,0 +1,146 @@
+package minio
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Credential providers that can be chained in Options.Credentials
+const (
+	CredentialsStatic      = "static"       // AccessKey and SecretKey
+	CredentialsEnv         = "env"          // AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, then MINIO_ROOT_USER/MINIO_ROOT_PASSWORD
+	CredentialsFile        = "file"         // AWS shared credentials file
+	CredentialsIAM         = "iam"          // EC2/ECS instance roles and EKS service accounts
+	CredentialsWebIdentity = "web-identity" // AssumeRoleWithWebIdentity against STSEndpoint
+)
+
+// Options configure the connection to an S3-compatible service
+type Options struct {
+	Endpoint string
+	UseSSL   bool
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string
+	Region string
+	// BucketLookup is auto, path or virtual (virtual-host style)
+	BucketLookup string
+
+	// Encryption is none, sse-s3 or sse-c. SSE-C needs Keys and UseSSL.
+	Encryption string
+	Keys       KeyProvider
+
+	// Credentials lists providers in the order they are tried; the first
+	// one that yields keys wins
+	Credentials []string
+
+	AccessKey string
+	SecretKey string
+
+	// Shared credentials file and profile; empty uses the AWS defaults
+	CredentialsFile    string
+	CredentialsProfile string
+
+	STSEndpoint          string
+	WebIdentityTokenFile string
+	RoleARN              string
+}
+
+// transport returns the HTTP transport for opts, trusting CAFile if set
+func (opts Options) transport() (*http.Transport, error) {
+	transport, err := minio.DefaultTransport(opts.UseSSL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CAFile == "" {
+		return transport, nil
+	}
+
+	pem, err := os.ReadFile(opts.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CAFile)
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	return transport, nil
+}
+
+func (opts Options) bucketLookup() (minio.BucketLookupType, error) {
+	switch strings.ToLower(opts.BucketLookup) {
+	case "", "auto":
+		return minio.BucketLookupAuto, nil
+	case "path":
+		return minio.BucketLookupPath, nil
+	case "virtual", "dns":
+		return minio.BucketLookupDNS, nil
+	default:
+		return 0, fmt.Errorf("unknown bucket lookup %q (expected auto, path or virtual)", opts.BucketLookup)
+	}
+}
+
+// credentials chains the configured providers. STS requests go through
+// transport so they trust the same CA bundle as the object store.
+func (opts Options) credentials(transport http.RoundTripper) (*credentials.Credentials, error) {
+	names := opts.Credentials
+	if len(names) == 0 {
+		names = []string{CredentialsStatic}
+	}
+
+	var providers []credentials.Provider
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case CredentialsStatic:
+			providers = append(providers, &credentials.Static{Value: credentials.Value{
+				AccessKeyID:     opts.AccessKey,
+				SecretAccessKey: opts.SecretKey,
+				SignerType:      credentials.SignatureV4,
+			}})
+		case CredentialsEnv:
+			providers = append(providers, &credentials.EnvAWS{}, &credentials.EnvMinio{})
+		case CredentialsFile:
+			providers = append(providers, &credentials.FileAWSCredentials{
+				Filename: opts.CredentialsFile,
+				Profile:  opts.CredentialsProfile,
+			})
+		case CredentialsIAM:
+			providers = append(providers, &credentials.IAM{
+				Client: &http.Client{Transport: transport},
+			})
+		case CredentialsWebIdentity:
+			if opts.STSEndpoint == "" || opts.WebIdentityTokenFile == "" {
+				return nil, fmt.Errorf("%s credentials need an STS endpoint and a token file", CredentialsWebIdentity)
+			}
+			tokenFile := opts.WebIdentityTokenFile
+			providers = append(providers, &credentials.STSWebIdentity{
+				Client:      &http.Client{Transport: transport},
+				STSEndpoint: opts.STSEndpoint,
+				RoleARN:     opts.RoleARN,
+				// The token is re-read on every refresh since it is rotated on disk
+				GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+					token, err := os.ReadFile(tokenFile)
+					if err != nil {
+						return nil, err
+					}
+					return &credentials.WebIdentityToken{Token: strings.TrimSpace(string(token))}, nil
+				},
+			})
+		default:
+			return nil, fmt.Errorf("unknown credentials provider %q", name)
+		}
+	}
+	return credentials.NewChainCredentials(providers), nil
+}
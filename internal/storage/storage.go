@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// MinPartSize is the smallest part accepted in a multipart upload, except
+// for the final part. It is S3's limit; the other backends use it too so
+// clients behave the same against every backend.
+const MinPartSize = 5 << 20 // 5MB
+
+var (
+	// ErrNotFound is returned when an object or multipart upload does not exist
+	ErrNotFound = errors.New("object not found")
+	// ErrNotSupported is returned by backends that cannot provide a feature,
+	// such as presigned URLs outside of S3
+	ErrNotSupported = errors.New("not supported by the storage backend")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Metadata     Metadata
+}
+
+// ObjectStore is the object storage used for originals, processed images
+// and renders. Objects are addressed by bucket and key; keys may contain
+// slashes.
+type ObjectStore interface {
+	// UploadFile stores an object. A size of -1 reads until EOF.
+	UploadFile(ctx context.Context, bucketName, objectName string, reader io.Reader, size int64, opts PutOptions) (ObjectInfo, error)
+	// DownloadFile opens an object for sequential reading
+	DownloadFile(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error)
+	// OpenFile opens an object for random access and returns it with its metadata
+	OpenFile(ctx context.Context, bucketName, objectName string) (io.ReadSeekCloser, ObjectInfo, error)
+	StatFile(ctx context.Context, bucketName, objectName string) (ObjectInfo, error)
+	DeleteFile(ctx context.Context, bucketName, objectName string) error
+	// ListFiles returns the objects whose keys start with prefix. Metadata
+	// is only filled in by backends that have it at hand.
+	ListFiles(ctx context.Context, bucketName, prefix string) ([]ObjectInfo, error)
+	// UpdateMetadata replaces the user metadata of an existing object
+	UpdateMetadata(ctx context.Context, bucketName, objectName string, meta Metadata) error
+
+	// GetFileLink and GetUploadLink return presigned GET and PUT URLs, or
+	// ErrNotSupported when the backend cannot be reached by clients directly
+	GetFileLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error)
+	GetUploadLink(ctx context.Context, bucketName, objectName string, expires time.Duration) (string, error)
+
+	// Multipart uploads assemble an object from parts uploaded separately.
+	// etags[i] passed to CompleteMultipartUpload must be the ETag returned
+	// for part number i+1.
+	NewMultipartUpload(ctx context.Context, bucketName, objectName string, opts PutOptions) (string, error)
+	UploadPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, etags []string) error
+	AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error
+}
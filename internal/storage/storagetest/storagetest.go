@@ -0,0 +1,247 @@
+// Package storagetest checks that an ObjectStore behaves the way the
+// services rely on, so every backend can run the same tests.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"image-processor/internal/storage"
+)
+
+// Bucket is the bucket newStore must create
+const Bucket = "test-bucket"
+
+// Run runs the contract tests against stores created by newStore, each of
+// which must be empty and contain Bucket
+func Run(t *testing.T, newStore func(t *testing.T) storage.ObjectStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store storage.ObjectStore)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"UnknownSize", testUnknownSize},
+		{"MissingObject", testMissingObject},
+		{"Delete", testDelete},
+		{"List", testList},
+		{"UpdateMetadata", testUpdateMetadata},
+		{"Multipart", testMultipart},
+		{"AbortMultipart", testAbortMultipart},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+var testMetadata = storage.Metadata{ImageID: "id", Owner: "alice", Filename: "photo.png", Checksum: "abc"}
+
+func put(t *testing.T, store storage.ObjectStore, key, content string) storage.ObjectInfo {
+	t.Helper()
+	info, err := store.UploadFile(context.Background(), Bucket, key, strings.NewReader(content), int64(len(content)), storage.PutOptions{
+		ContentType: "image/png",
+		Metadata:    testMetadata,
+	})
+	if err != nil {
+		t.Fatalf("UploadFile(%s): %v", key, err)
+	}
+	return info
+}
+
+func read(t *testing.T, store storage.ObjectStore, key string) string {
+	t.Helper()
+	reader, err := store.DownloadFile(context.Background(), Bucket, key)
+	if err != nil {
+		t.Fatalf("DownloadFile(%s): %v", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	return string(data)
+}
+
+func testRoundTrip(t *testing.T, store storage.ObjectStore) {
+	ctx := context.Background()
+	written := put(t, store, "a/b/object.png", "content")
+
+	if got := read(t, store, "a/b/object.png"); got != "content" {
+		t.Errorf("DownloadFile returned %q, want %q", got, "content")
+	}
+
+	info, err := store.StatFile(ctx, Bucket, "a/b/object.png")
+	if err != nil {
+		t.Fatalf("StatFile: %v", err)
+	}
+	if info.Key != "a/b/object.png" || info.Size != 7 || info.ContentType != "image/png" || info.Metadata != testMetadata {
+		t.Errorf("StatFile returned %+v", info)
+	}
+	if info.ETag == "" || info.ETag != written.ETag {
+		t.Errorf("StatFile returned ETag %q, UploadFile %q", info.ETag, written.ETag)
+	}
+
+	reader, opened, err := store.OpenFile(ctx, Bucket, "a/b/object.png")
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer reader.Close()
+	if opened.ETag != info.ETag || opened.Metadata != testMetadata {
+		t.Errorf("OpenFile returned %+v", opened)
+	}
+	if _, err := reader.Seek(4, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if rest, _ := io.ReadAll(reader); string(rest) != "ent" {
+		t.Errorf("read %q after seeking, want %q", rest, "ent")
+	}
+}
+
+func testUnknownSize(t *testing.T, store storage.ObjectStore) {
+	_, err := store.UploadFile(context.Background(), Bucket, "object", strings.NewReader("streamed"), -1, storage.PutOptions{})
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if got := read(t, store, "object"); got != "streamed" {
+		t.Errorf("DownloadFile returned %q, want %q", got, "streamed")
+	}
+}
+
+func testMissingObject(t *testing.T, store storage.ObjectStore) {
+	ctx := context.Background()
+	if _, err := store.StatFile(ctx, Bucket, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("StatFile returned %v, want ErrNotFound", err)
+	}
+	if _, _, err := store.OpenFile(ctx, Bucket, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("OpenFile returned %v, want ErrNotFound", err)
+	}
+	if _, err := store.DownloadFile(ctx, Bucket, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DownloadFile returned %v, want ErrNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, store storage.ObjectStore) {
+	ctx := context.Background()
+	put(t, store, "object", "content")
+
+	if err := store.DeleteFile(ctx, Bucket, "object"); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if _, err := store.StatFile(ctx, Bucket, "object"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("StatFile after DeleteFile returned %v, want ErrNotFound", err)
+	}
+	if err := store.DeleteFile(ctx, Bucket, "object"); err != nil {
+		t.Errorf("deleting a missing object returned %v, want nil", err)
+	}
+}
+
+func testList(t *testing.T, store storage.ObjectStore) {
+	for _, key := range []string{"renders/b/2.png", "renders/a/1.png", "renders/a/0.png", "originals/a.png"} {
+		put(t, store, key, key)
+	}
+
+	objects, err := store.ListFiles(context.Background(), Bucket, "renders/a/")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+		if object.Size != int64(len(object.Key)) {
+			t.Errorf("ListFiles returned size %d for %s", object.Size, object.Key)
+		}
+	}
+	if strings.Join(keys, ",") != "renders/a/0.png,renders/a/1.png" {
+		t.Errorf("ListFiles returned %v, want the keys under renders/a/ in order", keys)
+	}
+}
+
+func testUpdateMetadata(t *testing.T, store storage.ObjectStore) {
+	ctx := context.Background()
+	put(t, store, "object", "content")
+
+	updated := storage.Metadata{ImageID: "id", Checksum: "def", Pipeline: "key"}
+	if err := store.UpdateMetadata(ctx, Bucket, "object", updated); err != nil {
+		t.Fatalf("UpdateMetadata: %v", err)
+	}
+	info, err := store.StatFile(ctx, Bucket, "object")
+	if err != nil {
+		t.Fatalf("StatFile: %v", err)
+	}
+	if info.Metadata != updated || info.ContentType != "image/png" {
+		t.Errorf("StatFile returned %+v after UpdateMetadata", info)
+	}
+	if got := read(t, store, "object"); got != "content" {
+		t.Errorf("UpdateMetadata changed the content to %q", got)
+	}
+
+	if err := store.UpdateMetadata(ctx, Bucket, "missing", updated); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateMetadata of a missing object returned %v, want ErrNotFound", err)
+	}
+}
+
+func testMultipart(t *testing.T, store storage.ObjectStore) {
+	ctx := context.Background()
+	uploadID, err := store.NewMultipartUpload(ctx, Bucket, "object", storage.PutOptions{ContentType: "image/png", Metadata: testMetadata})
+	if err != nil {
+		t.Fatalf("NewMultipartUpload: %v", err)
+	}
+
+	// Parts may arrive out of order; their numbers decide where they go
+	parts := []string{"first-", "second-", "third"}
+	etags := make([]string, len(parts))
+	for _, i := range []int{1, 0, 2} {
+		etags[i], err = store.UploadPart(ctx, Bucket, "object", uploadID, i+1, bytes.NewReader([]byte(parts[i])), int64(len(parts[i])))
+		if err != nil {
+			t.Fatalf("UploadPart(%d): %v", i+1, err)
+		}
+	}
+	if _, err := store.StatFile(ctx, Bucket, "object"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("the object exists before the upload is complete: %v", err)
+	}
+
+	if err := store.CompleteMultipartUpload(ctx, Bucket, "object", uploadID, etags); err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	if got := read(t, store, "object"); got != "first-second-third" {
+		t.Errorf("DownloadFile returned %q, want the parts in order", got)
+	}
+	info, err := store.StatFile(ctx, Bucket, "object")
+	if err != nil {
+		t.Fatalf("StatFile: %v", err)
+	}
+	if info.ContentType != "image/png" || info.Metadata != testMetadata {
+		t.Errorf("StatFile returned %+v, want the options of the upload", info)
+	}
+
+	if err := store.CompleteMultipartUpload(ctx, Bucket, "object", uploadID, etags); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("completing an upload twice returned %v, want ErrNotFound", err)
+	}
+}
+
+func testAbortMultipart(t *testing.T, store storage.ObjectStore) {
+	ctx := context.Background()
+	uploadID, err := store.NewMultipartUpload(ctx, Bucket, "object", storage.PutOptions{})
+	if err != nil {
+		t.Fatalf("NewMultipartUpload: %v", err)
+	}
+	etag, err := store.UploadPart(ctx, Bucket, "object", uploadID, 1, strings.NewReader("part"), 4)
+	if err != nil {
+		t.Fatalf("UploadPart: %v", err)
+	}
+
+	if err := store.AbortMultipartUpload(ctx, Bucket, "object", uploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload: %v", err)
+	}
+	if err := store.CompleteMultipartUpload(ctx, Bucket, "object", uploadID, []string{etag}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("completing an aborted upload returned %v, want ErrNotFound", err)
+	}
+	if _, err := store.StatFile(ctx, Bucket, "object"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("an aborted upload left an object: %v", err)
+	}
+}
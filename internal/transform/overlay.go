@@ -0,0 +1,315 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Overlay positions. Tiled overlays ignore the position.
+const (
+	PositionCenter      = "center"
+	PositionTop         = "top"
+	PositionBottom      = "bottom"
+	PositionLeft        = "left"
+	PositionRight       = "right"
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+)
+
+// Overlay defaults, used for zero values
+const (
+	DefaultOverlayPosition = PositionBottomRight
+	DefaultOverlayOpacity  = 0.5
+	DefaultOverlayScale    = 0.25
+)
+
+// MaxOverlayText bounds the length of overlay text in characters
+const MaxOverlayText = 100
+
+// Bounds on the work of drawing an overlay. Marks are never narrower than
+// minOverlayWidth pixels, and tiled marks are spread out so that at most
+// maxOverlayTiles copies are drawn.
+const (
+	minOverlayWidth = 16
+	maxOverlayTiles = 400
+)
+
+// Overlay composites a logo or a line of text onto the output, such as a
+// watermark. The logo is the original of another image, uploaded once and
+// referenced by its ID.
+type Overlay struct {
+	LogoID   uuid.UUID // image whose original is composited; zero to render Text
+	Text     string
+	Position string  // empty means DefaultOverlayPosition
+	Opacity  float64 // 0 to 1; zero means DefaultOverlayOpacity
+	Scale    float64 // overlay width relative to the output width; zero means DefaultOverlayScale
+	Tile     bool    // repeat the overlay across the output instead of placing it once
+
+	// Logo is the decoded original of LogoID. It is loaded by the caller
+	// before Apply and is not part of the key.
+	Logo image.Image
+}
+
+// Validate checks that the overlay values are supported
+func (o *Overlay) Validate() error {
+	if (o.LogoID == uuid.Nil) == (o.Text == "") {
+		return fmt.Errorf("an overlay needs either a logo or text")
+	}
+	if utf8.RuneCountInString(o.Text) > MaxOverlayText {
+		return fmt.Errorf("overlay text must not exceed %d characters", MaxOverlayText)
+	}
+	switch o.Position {
+	case "", PositionCenter, PositionTop, PositionBottom, PositionLeft, PositionRight,
+		PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight:
+	default:
+		return fmt.Errorf("unsupported overlay position %q", o.Position)
+	}
+	if o.Opacity < 0 || o.Opacity > 1 {
+		return fmt.Errorf("overlay opacity must be between 0 and 1")
+	}
+	if o.Scale < 0 || o.Scale > 1 {
+		return fmt.Errorf("overlay scale must be between 0 and 1")
+	}
+	return nil
+}
+
+// canonical returns the overlay part of Options.Key, with defaults filled in
+func (o *Overlay) canonical() string {
+	source := "text:" + url.QueryEscape(o.Text)
+	if o.LogoID != uuid.Nil {
+		source = "logo:" + o.LogoID.String()
+	}
+	position, opacity, scale := o.defaults()
+	if o.Tile {
+		position = "tile"
+	}
+	return fmt.Sprintf("&overlay=%s&pos=%s&opacity=%g&scale=%g", source, position, opacity, scale)
+}
+
+func (o *Overlay) defaults() (position string, opacity, scale float64) {
+	position, opacity, scale = o.Position, o.Opacity, o.Scale
+	if position == "" {
+		position = DefaultOverlayPosition
+	}
+	if opacity == 0 {
+		opacity = DefaultOverlayOpacity
+	}
+	if scale == 0 {
+		scale = DefaultOverlayScale
+	}
+	return position, opacity, scale
+}
+
+// WithLogo returns a copy of o whose overlay composites logo, leaving o unchanged
+func (o Options) WithLogo(logo image.Image) Options {
+	if o.Overlay != nil {
+		overlay := *o.Overlay
+		overlay.Logo = logo
+		o.Overlay = &overlay
+	}
+	return o
+}
+
+// applyOverlay composites the overlay onto img. A logo overlay whose Logo
+// was not loaded leaves img unchanged.
+func applyOverlay(img image.Image, o *Overlay) image.Image {
+	bounds := img.Bounds()
+	position, opacity, scale := o.defaults()
+	width := max(minOverlayWidth, int(math.Round(float64(bounds.Dx())*scale)))
+
+	var mark *image.NRGBA
+	if o.LogoID != uuid.Nil {
+		if o.Logo == nil {
+			return img
+		}
+		mark = imaging.Resize(o.Logo, width, 0, imaging.Lanczos)
+	} else {
+		mark = renderText(o.Text, width, bounds.Dy())
+	}
+	if mark == nil || mark.Bounds().Empty() {
+		return img
+	}
+
+	if o.Tile {
+		return imaging.Overlay(img, tile(mark, bounds.Dx(), bounds.Dy()), image.Point{}, opacity)
+	}
+	margin := int(math.Round(0.02 * float64(min(bounds.Dx(), bounds.Dy()))))
+	return imaging.Overlay(img, mark, place(position, bounds.Size(), mark.Bounds().Size(), margin), opacity)
+}
+
+// place returns the top-left corner of a mark of size m at position on an
+// image of size s, margin pixels away from the edges it is aligned to
+func place(position string, s, m image.Point, margin int) image.Point {
+	p := image.Pt((s.X-m.X)/2, (s.Y-m.Y)/2)
+	switch position {
+	case PositionTopLeft, PositionLeft, PositionBottomLeft:
+		p.X = margin
+	case PositionTopRight, PositionRight, PositionBottomRight:
+		p.X = s.X - m.X - margin
+	}
+	switch position {
+	case PositionTopLeft, PositionTop, PositionTopRight:
+		p.Y = margin
+	case PositionBottomLeft, PositionBottom, PositionBottomRight:
+		p.Y = s.Y - m.Y - margin
+	}
+	return p
+}
+
+// tile repeats mark over a w x h layer, leaving half a mark between copies
+// and shifting every other row by half a step. Small marks are spaced
+// further apart so that no more than maxOverlayTiles copies are drawn.
+func tile(mark *image.NRGBA, w, h int) *image.NRGBA {
+	layer := image.NewNRGBA(image.Rect(0, 0, w, h))
+	size := mark.Bounds().Size()
+	stepX, stepY := max(1, size.X+size.X/2), max(1, size.Y+size.Y/2)
+	if copies := float64(w/stepX+2) * float64(h/stepY+1); copies > maxOverlayTiles {
+		spread := math.Sqrt(copies / maxOverlayTiles)
+		stepX = int(math.Ceil(float64(stepX) * spread))
+		stepY = int(math.Ceil(float64(stepY) * spread))
+	}
+	drawn := 0
+	for row, y := 0, 0; y < h && drawn < maxOverlayTiles; row, y = row+1, y+stepY {
+		x := 0
+		if row%2 == 1 {
+			x = -stepX / 2
+		}
+		for ; x < w && drawn < maxOverlayTiles; x, drawn = x+stepX, drawn+1 {
+			draw.Draw(layer, image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x+size.X, y+size.Y)}, mark, image.Point{}, draw.Src)
+		}
+	}
+	return layer
+}
+
+// overlayFont renders overlay text. It is embedded, so parsing cannot fail.
+var overlayFont, _ = sfnt.Parse(gobold.TTF)
+
+// renderText draws text in white with a dark shadow, which stays legible on
+// any background, sized to be width pixels wide and at most maxHeight high
+func renderText(text string, width, maxHeight int) *image.NRGBA {
+	var buf sfnt.Buffer
+	const reference = 100
+	advance := textAdvance(&buf, text, fixed.I(reference))
+	if advance <= 0 {
+		return nil
+	}
+	ppem := float64(reference) * float64(width) / (float64(advance) / 64)
+
+	metrics, err := overlayFont.Metrics(&buf, fixed.Int26_6(ppem*64), font.HintingNone)
+	if err != nil {
+		return nil
+	}
+	if lineHeight := float64(metrics.Ascent+metrics.Descent) / 64; lineHeight > float64(maxHeight) {
+		ppem *= float64(maxHeight) / lineHeight
+		if metrics, err = overlayFont.Metrics(&buf, fixed.Int26_6(ppem*64), font.HintingNone); err != nil {
+			return nil
+		}
+	}
+	size := fixed.Int26_6(ppem * 64)
+	ascent := float32(metrics.Ascent) / 64
+	shadow := max(1, int(ppem/24))
+
+	w := int(math.Ceil(float64(textAdvance(&buf, text, size))/64)) + shadow
+	h := int(math.Ceil(float64(metrics.Ascent+metrics.Descent)/64)) + shadow
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	raster := vector.NewRasterizer(w, h)
+	var x float32
+	previous := sfnt.GlyphIndex(0)
+	for _, r := range text {
+		index, err := overlayFont.GlyphIndex(&buf, r)
+		if err != nil {
+			continue
+		}
+		if kern, err := overlayFont.Kern(&buf, previous, index, size, font.HintingNone); previous != 0 && err == nil {
+			x += float32(kern) / 64
+		}
+		segments, err := overlayFont.LoadGlyph(&buf, index, size, nil)
+		if err != nil {
+			continue
+		}
+		drawGlyph(raster, segments, x, ascent)
+		glyphAdvance, err := overlayFont.GlyphAdvance(&buf, index, size, font.HintingNone)
+		if err == nil {
+			x += float32(glyphAdvance) / 64
+		}
+		previous = index
+	}
+	mask := image.NewAlpha(raster.Bounds())
+	raster.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	shadowRect := out.Bounds().Add(image.Pt(shadow, shadow))
+	draw.DrawMask(out, shadowRect, image.NewUniform(color.NRGBA{0, 0, 0, 160}), image.Point{}, mask, image.Point{}, draw.Over)
+	draw.DrawMask(out, out.Bounds(), image.White, image.Point{}, mask, image.Point{}, draw.Over)
+	return out
+}
+
+// textAdvance returns the width of text at size, including kerning
+func textAdvance(buf *sfnt.Buffer, text string, size fixed.Int26_6) fixed.Int26_6 {
+	var advance fixed.Int26_6
+	previous := sfnt.GlyphIndex(0)
+	for _, r := range text {
+		index, err := overlayFont.GlyphIndex(buf, r)
+		if err != nil {
+			continue
+		}
+		if kern, err := overlayFont.Kern(buf, previous, index, size, font.HintingNone); previous != 0 && err == nil {
+			advance += kern
+		}
+		if glyphAdvance, err := overlayFont.GlyphAdvance(buf, index, size, font.HintingNone); err == nil {
+			advance += glyphAdvance
+		}
+		previous = index
+	}
+	return advance
+}
+
+// drawGlyph adds the outline of a glyph with its origin at (x, baseline)
+func drawGlyph(raster *vector.Rasterizer, segments []sfnt.Segment, x, baseline float32) {
+	point := func(p fixed.Point26_6) (float32, float32) {
+		return x + float32(p.X)/64, baseline + float32(p.Y)/64
+	}
+	open := false
+	for _, s := range segments {
+		switch s.Op {
+		case sfnt.SegmentOpMoveTo:
+			// Contours are not closed by the font
+			if open {
+				raster.ClosePath()
+			}
+			raster.MoveTo(point(s.Args[0]))
+			open = true
+		case sfnt.SegmentOpLineTo:
+			raster.LineTo(point(s.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			bx, by := point(s.Args[0])
+			cx, cy := point(s.Args[1])
+			raster.QuadTo(bx, by, cx, cy)
+		case sfnt.SegmentOpCubeTo:
+			bx, by := point(s.Args[0])
+			cx, cy := point(s.Args[1])
+			dx, dy := point(s.Args[2])
+			raster.CubeTo(bx, by, cx, cy, dx, dy)
+		}
+	}
+	if open {
+		raster.ClosePath()
+	}
+}
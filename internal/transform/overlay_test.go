@@ -0,0 +1,56 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+)
+
+func TestTileLimitsCopiesOfSmallMarks(t *testing.T) {
+	mark := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	mark.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+
+	layer := tile(mark, 3000, 3000)
+	copies, lowest := 0, 0
+	for y := 0; y < 3000; y++ {
+		for x := 0; x < 3000; x++ {
+			if layer.NRGBAAt(x, y).A != 0 {
+				copies++
+				lowest = y
+			}
+		}
+	}
+	if copies == 0 || copies > maxOverlayTiles {
+		t.Errorf("got %d copies, want between 1 and %d", copies, maxOverlayTiles)
+	}
+	// The copies are spread out rather than cut off after the first rows
+	if lowest < 2000 {
+		t.Errorf("lowest copy is at y=%d, want the tiles to reach the bottom of the layer", lowest)
+	}
+}
+
+func TestOverlayMarksHaveAMinimumWidth(t *testing.T) {
+	logo := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for i := range logo.Pix {
+		logo.Pix[i] = 255
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 1000, 1000))
+	overlay := &Overlay{LogoID: uuid.New(), Scale: 0.0001, Opacity: 1, Logo: logo}
+	if err := overlay.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	out := imaging.Clone(applyOverlay(img, overlay))
+	width := 0
+	for x := 0; x < 1000; x++ {
+		if out.NRGBAAt(x, 975).R != 0 {
+			width++
+		}
+	}
+	if width < minOverlayWidth {
+		t.Errorf("got a mark %dpx wide, want at least %dpx", width, minOverlayWidth)
+	}
+}
@@ -0,0 +1,150 @@
+package transform
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// analysisSize bounds the side of the thumbnail crops are scored on
+	analysisSize = 256
+	// entropyCell is the side of the blocks whose luminance entropy is scored
+	entropyCell = 8
+	// centerBias is the share of its score a window at the edge loses, so
+	// that featureless images are cropped from the center
+	centerBias = 0.1
+)
+
+// smartCrop scales img to cover a w x h box like FitFill, but places the
+// crop window where the image is most interesting instead of in the center.
+// Regions score by edge density, color saturation and luminance entropy,
+// which are high on faces, products and text and low on sky, walls and
+// studio backdrops.
+func smartCrop(img image.Image, w, h int) *image.NRGBA {
+	bounds := img.Bounds()
+	ow, oh := bounds.Dx(), bounds.Dy()
+	if ow == 0 || oh == 0 {
+		return imaging.Fill(img, w, h, imaging.Center, imaging.Lanczos)
+	}
+
+	// The crop keeps the full extent of one axis and slides along the other
+	horizontal := ow*h > oh*w
+	crop := image.Rect(0, 0, ow, oh)
+	if horizontal {
+		crop.Max.X = max(1, int(math.Round(float64(oh)*float64(w)/float64(h))))
+	} else {
+		crop.Max.Y = max(1, int(math.Round(float64(ow)*float64(h)/float64(w))))
+	}
+
+	if crop.Dx() < ow || crop.Dy() < oh {
+		sample := imaging.Fit(img, analysisSize, analysisSize, imaging.Box)
+		profile := scoreProfile(sample, horizontal)
+		length, window := oh, crop.Dy()
+		if horizontal {
+			length, window = ow, crop.Dx()
+		}
+		offset := bestWindow(profile, float64(window)/float64(length))
+		start := min(int(math.Round(offset*float64(length))), length-window)
+		if horizontal {
+			crop = crop.Add(image.Pt(start, 0))
+		} else {
+			crop = crop.Add(image.Pt(0, start))
+		}
+	}
+	return imaging.Resize(imaging.Crop(img, crop.Add(bounds.Min)), w, h, imaging.Lanczos)
+}
+
+// scoreProfile sums the score of every pixel of img by column, or by row
+// when horizontal is false
+func scoreProfile(img *image.NRGBA, horizontal bool) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	luma := make([]float64, w*h)
+	chroma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pix := img.Pix[y*img.Stride+x*4:]
+			r, g, b := float64(pix[0]), float64(pix[1]), float64(pix[2])
+			luma[y*w+x] = 0.299*r + 0.587*g + 0.114*b
+			chroma[y*w+x] = (max(r, g, b) - min(r, g, b)) / 255
+		}
+	}
+	entropy := cellEntropy(luma, w, h)
+
+	profile := make([]float64, h)
+	if horizontal {
+		profile = make([]float64, w)
+	}
+	cellsX := (w + entropyCell - 1) / entropyCell
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Central differences, normalised to 0-1
+			dx := luma[y*w+min(x+1, w-1)] - luma[y*w+max(x-1, 0)]
+			dy := luma[min(y+1, h-1)*w+x] - luma[max(y-1, 0)*w+x]
+			edge := math.Min(1, (math.Abs(dx)+math.Abs(dy))/255)
+
+			score := edge + 0.5*chroma[y*w+x] + 0.5*entropy[(y/entropyCell)*cellsX+x/entropyCell]
+			if horizontal {
+				profile[x] += score
+			} else {
+				profile[y] += score
+			}
+		}
+	}
+	return profile
+}
+
+// cellEntropy returns the Shannon entropy of the luminance histogram of each
+// entropyCell block, normalised to 0-1
+func cellEntropy(luma []float64, w, h int) []float64 {
+	const bins = 16
+	cellsX, cellsY := (w+entropyCell-1)/entropyCell, (h+entropyCell-1)/entropyCell
+	entropy := make([]float64, cellsX*cellsY)
+	for cy := 0; cy < cellsY; cy++ {
+		for cx := 0; cx < cellsX; cx++ {
+			var histogram [bins]int
+			n := 0
+			for y := cy * entropyCell; y < min((cy+1)*entropyCell, h); y++ {
+				for x := cx * entropyCell; x < min((cx+1)*entropyCell, w); x++ {
+					histogram[min(int(luma[y*w+x])*bins/256, bins-1)]++
+					n++
+				}
+			}
+			e := 0.0
+			for _, count := range histogram {
+				if count > 0 {
+					p := float64(count) / float64(n)
+					e -= p * math.Log2(p)
+				}
+			}
+			entropy[cy*cellsX+cx] = e / math.Log2(bins)
+		}
+	}
+	return entropy
+}
+
+// bestWindow returns the start, as a fraction of the profile, of the window
+// covering fraction size of it with the highest total score
+func bestWindow(profile []float64, size float64) float64 {
+	n := len(profile)
+	window := min(n, max(1, int(math.Round(size*float64(n)))))
+	if window >= n {
+		return 0
+	}
+
+	prefix := make([]float64, n+1)
+	for i, v := range profile {
+		prefix[i+1] = prefix[i] + v
+	}
+	last := n - window
+	best, bestScore := last/2, math.Inf(-1)
+	for start := 0; start <= last; start++ {
+		distance := math.Abs(float64(start)-float64(last)/2) / (float64(last) / 2)
+		score := (prefix[start+window] - prefix[start]) * (1 - centerBias*distance)
+		if score > bestScore {
+			best, bestScore = start, score
+		}
+	}
+	return float64(best) / float64(n)
+}
@@ -0,0 +1,74 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// detailed returns a flat gray w x h image with a saturated checkerboard
+// over rect
+func detailed(w, h int, rect image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{128, 128, 128, 255}
+			if (image.Point{x, y}).In(rect) {
+				c = color.NRGBA{220, 20, 20, 255}
+				if (x/2+y/2)%2 == 1 {
+					c = color.NRGBA{20, 20, 220, 255}
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// saturated counts the pixels of img within rect that are not gray
+func saturated(img *image.NRGBA, rect image.Rectangle) int {
+	n := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if max(c.R, c.G, c.B)-min(c.R, c.G, c.B) > 64 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestSmartCropKeepsTheDetailedRegion(t *testing.T) {
+	tests := []struct {
+		name   string
+		img    *image.NRGBA
+		detail image.Rectangle // where the detail must end up in the 100x100 output
+	}{
+		// A center crop of either would only show the gray background
+		{"wide", detailed(300, 100, image.Rect(230, 20, 290, 80)), image.Rect(30, 20, 90, 80)},
+		{"tall", detailed(100, 300, image.Rect(20, 10, 80, 70)), image.Rect(20, 10, 80, 70)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := smartCrop(tt.img, 100, 100)
+			if out.Bounds().Size() != image.Pt(100, 100) {
+				t.Fatalf("got size %v, want 100x100", out.Bounds().Size())
+			}
+			// Resampling blurs the edges of the checkerboard, so most but not all of it stays saturated
+			if n, want := saturated(out, tt.detail), tt.detail.Dx()*tt.detail.Dy()/2; n < want {
+				t.Errorf("got %d saturated pixels where the detail should be, want at least %d", n, want)
+			}
+		})
+	}
+}
+
+func TestSmartCropCentersFeaturelessImages(t *testing.T) {
+	profile := make([]float64, 200)
+	for i := range profile {
+		profile[i] = 1
+	}
+	if got := bestWindow(profile, 0.5); got != 0.25 {
+		t.Errorf("got window at %g, want the centered window at 0.25", got)
+	}
+}
@@ -0,0 +1,292 @@
+package transform
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"image-processor/internal/config"
+	"image-processor/internal/imagemeta"
+
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+)
+
+// Fit modes control how an image is resized when both width and height are given
+const (
+	// FitContain scales the image to fit inside the box, keeping its aspect ratio
+	FitContain = "fit"
+	// FitFill scales the image to cover the box and crops the overflow from the center
+	FitFill = "fill"
+	// FitPad scales like FitContain and pads the remainder of the box
+	FitPad = "pad"
+	// FitStretch scales to the exact box, ignoring aspect ratio
+	FitStretch = "stretch"
+	// FitSmart scales the image to cover the box like FitFill and crops
+	// around its most detailed and colorful region
+	FitSmart = "smart"
+)
+
+// Metadata modes control what an output keeps of the original's metadata
+const (
+	// MetadataStrip removes all metadata
+	MetadataStrip = "strip"
+	// MetadataStripPrivate keeps EXIF and the color profile but removes GPS
+	// and tags identifying the camera or its owner
+	MetadataStripPrivate = "strip-private"
+	// MetadataPreserve keeps EXIF and the color profile
+	MetadataPreserve = "preserve"
+)
+
+// Output formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// DefaultQuality is the JPEG quality used when none is given
+const DefaultQuality = 85
+
+// Pipeline is applied by the worker to every uploaded image, together with
+// the configured watermark (see NewPipeline). Its Key is stored with
+// processed images so outputs are only shared between images processed the
+// same way.
+var Pipeline = Options{
+	Width:     800,
+	Grayscale: true,
+	Format:    FormatPNG,
+}
+
+// NewPipeline returns Pipeline with the configured watermark, which the
+// gateway needs as well as the worker to match duplicates by pipeline key
+func NewPipeline(cfg *config.Config) (Options, error) {
+	pipeline := Pipeline
+	if cfg.WatermarkLogoID == "" && cfg.WatermarkText == "" {
+		return pipeline, nil
+	}
+	pipeline.Overlay = &Overlay{
+		Text:     cfg.WatermarkText,
+		Position: cfg.WatermarkPosition,
+		Opacity:  cfg.WatermarkOpacity,
+		Scale:    cfg.WatermarkScale,
+		Tile:     cfg.WatermarkTile,
+	}
+	if cfg.WatermarkLogoID != "" {
+		id, err := uuid.Parse(cfg.WatermarkLogoID)
+		if err != nil {
+			return pipeline, fmt.Errorf("invalid watermark logo ID: %w", err)
+		}
+		pipeline.Overlay.LogoID = id
+		// A logo takes the place of text
+		pipeline.Overlay.Text = ""
+	}
+	if err := pipeline.Validate(); err != nil {
+		return pipeline, fmt.Errorf("invalid watermark: %w", err)
+	}
+	return pipeline, nil
+}
+
+// Options describes the operations applied to an image. Zero values mean
+// "leave unchanged": a zero Width or Height is derived from the aspect ratio.
+type Options struct {
+	Width     int
+	Height    int
+	Fit       string
+	Grayscale bool
+	Format    string
+	Quality   int
+	Metadata  string // empty means MetadataStrip
+	Overlay   *Overlay
+}
+
+// Validate checks that the option values are supported
+func (o Options) Validate() error {
+	if o.Width < 0 || o.Height < 0 {
+		return fmt.Errorf("width and height must not be negative")
+	}
+	switch o.Fit {
+	case "", FitContain, FitFill, FitPad, FitStretch, FitSmart:
+	default:
+		return fmt.Errorf("unsupported fit mode %q", o.Fit)
+	}
+	switch o.Format {
+	case FormatJPEG, FormatPNG:
+	default:
+		return fmt.Errorf("unsupported format %q", o.Format)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+	switch o.Metadata {
+	case "", MetadataStrip, MetadataStripPrivate, MetadataPreserve:
+	default:
+		return fmt.Errorf("unsupported metadata mode %q", o.Metadata)
+	}
+	if o.Overlay != nil {
+		return o.Overlay.Validate()
+	}
+	return nil
+}
+
+// Key returns a stable hash of the options, suitable for cache object names
+func (o Options) Key() string {
+	fit := o.Fit
+	if fit == "" {
+		fit = FitContain
+	}
+	quality := o.Quality
+	if o.Format != FormatJPEG {
+		quality = 0
+	} else if quality == 0 {
+		quality = DefaultQuality
+	}
+	metadata := o.Metadata
+	if metadata == "" {
+		metadata = MetadataStrip
+	}
+	// The key covers every option that changes the output bytes, with defaults
+	// filled in so equivalent options share a key. orient=auto records that
+	// Decode rotates outputs upright, so they never share a key with outputs
+	// of unrotated originals.
+	canonical := fmt.Sprintf("w=%d&h=%d&fit=%s&gray=%t&format=%s&q=%d&orient=auto&meta=%s",
+		o.Width, o.Height, fit, o.Grayscale, o.Format, quality, metadata)
+	// Overlays are only part of the key when there is one
+	if o.Overlay != nil {
+		canonical += o.Overlay.canonical()
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Extension returns the file extension for the output format
+func (o Options) Extension() string {
+	if o.Format == FormatJPEG {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// ContentType returns the MIME type for the output format
+func (o Options) ContentType() string {
+	if o.Format == FormatJPEG {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// ErrTooManyPixels is returned by CheckPixels for images larger than allowed
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+// Decode reads an image in any format supported by imaging and rotates it
+// upright according to its EXIF orientation
+func Decode(r io.Reader) (image.Image, error) {
+	return imaging.Decode(r, imaging.AutoOrientation(true))
+}
+
+// CheckPixels reads only the header of the image in r and returns
+// ErrTooManyPixels if decoding it would exceed maxPixels, so that small files
+// declaring huge dimensions are refused before they are decoded. A maxPixels
+// of 0 allows any size. r is rewound afterwards.
+func CheckPixels(r io.ReadSeeker, maxPixels int64) error {
+	if maxPixels <= 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > maxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrTooManyPixels, cfg.Width, cfg.Height, maxPixels)
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
+
+// ReadMetadata returns the metadata of the original that o keeps in the
+// output. r is left at an arbitrary position.
+func ReadMetadata(r io.ReadSeeker, o Options) (imagemeta.Embedded, error) {
+	switch o.Metadata {
+	case MetadataStripPrivate:
+		return imagemeta.ReadEmbedded(r, false)
+	case MetadataPreserve:
+		return imagemeta.ReadEmbedded(r, true)
+	}
+	return imagemeta.Embedded{}, nil
+}
+
+// Apply resizes and filters img according to o. The overlay is composited
+// last, so it keeps its colors on grayscale outputs.
+func Apply(img image.Image, o Options) image.Image {
+	img = resize(img, o)
+	if o.Grayscale {
+		img = imaging.Grayscale(img)
+	}
+	if o.Overlay != nil {
+		img = applyOverlay(img, o.Overlay)
+	}
+	return img
+}
+
+// Encode writes img in the output format described by o, together with
+// metadata read by ReadMetadata
+func Encode(w io.Writer, img image.Image, o Options, metadata imagemeta.Embedded) error {
+	var buf bytes.Buffer
+	var err error
+	switch o.Format {
+	case FormatJPEG:
+		quality := o.Quality
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		err = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(quality))
+	case FormatPNG:
+		err = imaging.Encode(&buf, img, imaging.PNG)
+	default:
+		return fmt.Errorf("unsupported format %q", o.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoded, err := imagemeta.Embed(buf.Bytes(), metadata)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+func resize(img image.Image, o Options) image.Image {
+	if o.Width == 0 && o.Height == 0 {
+		return img
+	}
+	// With a single dimension the other follows the aspect ratio
+	if o.Width == 0 || o.Height == 0 {
+		return imaging.Resize(img, o.Width, o.Height, imaging.Lanczos)
+	}
+
+	switch o.Fit {
+	case FitFill:
+		return imaging.Fill(img, o.Width, o.Height, imaging.Center, imaging.Lanczos)
+	case FitSmart:
+		return smartCrop(img, o.Width, o.Height)
+	case FitPad:
+		fitted := imaging.Fit(img, o.Width, o.Height, imaging.Lanczos)
+		// JPEG has no alpha channel, so pad with white instead of transparency
+		var background color.Color = color.Transparent
+		if o.Format == FormatJPEG {
+			background = color.White
+		}
+		canvas := imaging.New(o.Width, o.Height, background)
+		return imaging.PasteCenter(canvas, fitted)
+	case FitStretch:
+		return imaging.Resize(img, o.Width, o.Height, imaging.Lanczos)
+	default:
+		return imaging.Fit(img, o.Width, o.Height, imaging.Lanczos)
+	}
+}
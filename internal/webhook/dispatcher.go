@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"image-processor/internal/config"
+	"image-processor/internal/models"
+	"image-processor/internal/netguard"
+	"image-processor/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// batchSize is the number of due deliveries claimed per poll
+const batchSize = 20
+
+// claimTimeout is how long a claimed delivery stays hidden from other
+// dispatchers. It only matters if a dispatcher dies mid-delivery.
+const claimTimeout = 5 * time.Minute
+
+// Dispatcher sends pending deliveries. Any number of dispatchers can share
+// a repository; each claims the deliveries it sends.
+type Dispatcher struct {
+	webhooks     repository.WebhookRepository
+	client       *http.Client
+	maxAttempts  int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+	pollInterval time.Duration
+}
+
+func NewDispatcher(cfg *config.Config, webhooks repository.WebhookRepository) *Dispatcher {
+	client := &http.Client{
+		Transport: netguard.NewTransport(cfg.WebhookAllowPrivate),
+		Timeout:   cfg.WebhookTimeout,
+		// Redirects are not followed: the receiver must be configured with its final URL
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Dispatcher{
+		webhooks:     webhooks,
+		client:       client,
+		maxAttempts:  cfg.WebhookMaxAttempts,
+		backoffBase:  cfg.WebhookBackoffBase,
+		backoffMax:   cfg.WebhookBackoffMax,
+		pollInterval: cfg.WebhookPollInterval,
+	}
+}
+
+// Run delivers due webhooks until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.dispatchBatch(ctx)
+			if err != nil {
+				log.Printf("Warning: webhook dispatch failed: %v", err)
+			}
+			// Keep going while full batches are due
+			if err != nil || n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchBatch claims up to batchSize due deliveries and sends them
+func (d *Dispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	claimed, err := d.webhooks.ClaimDeliveries(ctx, batchSize, claimTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range claimed {
+		d.deliver(ctx, delivery)
+	}
+	return len(claimed), nil
+}
+
+// deliver sends one delivery and records the outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery repository.ClaimedDelivery) {
+	code, err := d.send(ctx, delivery)
+	attempts := delivery.Attempts + 1
+
+	// The outcome is recorded even if ctx was cancelled during the request
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if err == nil {
+		d.record(ctx, delivery.ID, models.DeliveryStatusDelivered, attempts, code, "", time.Now())
+		return
+	}
+
+	if attempts >= d.maxAttempts {
+		log.Printf("Webhook delivery %s to %s failed permanently after %d attempts: %v", delivery.ID, delivery.URL, attempts, err)
+		d.record(ctx, delivery.ID, models.DeliveryStatusFailed, attempts, code, err.Error(), time.Now())
+		return
+	}
+
+	next := time.Now().Add(d.backoff(attempts))
+	log.Printf("Webhook delivery %s to %s failed (attempt %d), retrying at %s: %v", delivery.ID, delivery.URL, attempts, next.Format(time.RFC3339), err)
+	d.record(ctx, delivery.ID, models.DeliveryStatusPending, attempts, code, err.Error(), next)
+}
+
+// send POSTs the payload and returns the response code. Any 2xx counts as delivered.
+func (d *Dispatcher) send(ctx context.Context, delivery repository.ClaimedDelivery) (int, error) {
+	if delivery.Secret == "" {
+		return 0, fmt.Errorf("no signing secret recorded")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("invalid request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "image-processor-webhooks/1.0")
+	req.Header.Set(HeaderDeliveryID, delivery.ID.String())
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt: exponential in the
+// number of attempts so far, capped, with up to 20% jitter so receivers
+// recovering from an outage are not hit by every retry at once
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.backoffMax
+	if shift := attempts - 1; shift < 32 {
+		if exp := d.backoffBase << shift; exp > 0 && exp < d.backoffMax {
+			delay = exp
+		}
+	}
+	return delay + rand.N(delay/5+1)
+}
+
+func (d *Dispatcher) record(ctx context.Context, id uuid.UUID, status models.DeliveryStatus, attempts, code int, lastError string, next time.Time) {
+	err := d.webhooks.RecordAttempt(ctx, id, repository.DeliveryAttempt{
+		Status:        status,
+		Attempts:      attempts,
+		ResponseCode:  code,
+		LastError:     lastError,
+		NextAttemptAt: next,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to record webhook delivery %s: %v", id, err)
+	}
+}
@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"image-processor/internal/config"
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/repository/memory"
+
+	"github.com/google/uuid"
+)
+
+func newTestDispatcher(t *testing.T, webhooks repository.WebhookRepository) *Dispatcher {
+	t.Helper()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	// The receivers run on loopback
+	cfg.WebhookAllowPrivate = true
+	return NewDispatcher(cfg, webhooks)
+}
+
+// subscribe records a delivery for a subscription of alice to url
+func subscribe(t *testing.T, webhooks *memory.WebhookRepository, url string) models.WebhookSubscription {
+	t.Helper()
+	ctx := context.Background()
+	subscription := models.WebhookSubscription{ID: uuid.New(), Owner: "alice", URL: url, Secret: "whsec_test"}
+	if err := webhooks.CreateSubscription(ctx, &subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	image := &models.Image{ID: uuid.New(), Owner: "alice"}
+	if _, err := webhooks.RecordDeliveries(ctx, image, EventCompleted, []byte(`{"event":"image.completed"}`)); err != nil {
+		t.Fatalf("failed to record delivery: %v", err)
+	}
+	return subscription
+}
+
+func deliveries(t *testing.T, webhooks *memory.WebhookRepository) []models.WebhookDelivery {
+	t.Helper()
+	found, err := webhooks.ListDeliveries(context.Background(), repository.DeliveryListOptions{Owner: "alice"})
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	return found
+}
+
+func TestDispatchSignsWithSubscriptionSecret(t *testing.T) {
+	var signature, timestamp string
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, timestamp = r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer receiver.Close()
+
+	webhooks := memory.NewWebhookRepository()
+	subscription := subscribe(t, webhooks, receiver.URL)
+	if n, err := newTestDispatcher(t, webhooks).dispatchBatch(context.Background()); n != 1 || err != nil {
+		t.Fatalf("dispatchBatch sent %d deliveries: %v", n, err)
+	}
+
+	ts, _ := strconv.ParseInt(timestamp, 10, 64)
+	if want := Sign(subscription.Secret, ts, body); signature != want {
+		t.Errorf("got signature %q, want %q", signature, want)
+	}
+	if found := deliveries(t, webhooks); len(found) != 1 || found[0].Status != models.DeliveryStatusDelivered || found[0].Attempts != 1 {
+		t.Errorf("got deliveries %+v, want one delivered after one attempt", found)
+	}
+}
+
+func TestDispatchSignsCallbacksWithUploadSecret(t *testing.T) {
+	var signature, timestamp string
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, timestamp = r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer receiver.Close()
+
+	webhooks := memory.NewWebhookRepository()
+	image := &models.Image{ID: uuid.New(), CallbackURL: receiver.URL, CallbackSecret: "whsec_upload"}
+	if _, err := webhooks.RecordDeliveries(context.Background(), image, EventCompleted, []byte(`{}`)); err != nil {
+		t.Fatalf("failed to record delivery: %v", err)
+	}
+	if n, err := newTestDispatcher(t, webhooks).dispatchBatch(context.Background()); n != 1 || err != nil {
+		t.Fatalf("dispatchBatch sent %d deliveries: %v", n, err)
+	}
+
+	ts, _ := strconv.ParseInt(timestamp, 10, 64)
+	if want := Sign(image.CallbackSecret, ts, body); signature != want {
+		t.Errorf("got signature %q, want %q", signature, want)
+	}
+}
+
+func TestDispatchRetriesFailedDeliveries(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	webhooks := memory.NewWebhookRepository()
+	subscribe(t, webhooks, receiver.URL)
+	dispatcher := newTestDispatcher(t, webhooks)
+	if _, err := dispatcher.dispatchBatch(context.Background()); err != nil {
+		t.Fatalf("dispatchBatch: %v", err)
+	}
+
+	found := deliveries(t, webhooks)
+	if len(found) != 1 || found[0].Status != models.DeliveryStatusPending || found[0].Attempts != 1 || found[0].ResponseCode != http.StatusBadGateway {
+		t.Fatalf("got deliveries %+v, want one pending after a 502", found)
+	}
+	if !found[0].NextAttemptAt.After(time.Now()) {
+		t.Errorf("retry is due at %s, want a backoff", found[0].NextAttemptAt)
+	}
+	// The retry is not due yet, so nothing is claimed
+	if n, err := dispatcher.dispatchBatch(context.Background()); n != 0 || err != nil {
+		t.Errorf("dispatchBatch sent %d deliveries before the retry was due: %v", n, err)
+	}
+}
@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/pkg/security"
+
+	"github.com/google/uuid"
+)
+
+// Events sent to callbacks
+const (
+	EventCompleted = "image.completed"
+	EventFailed    = "image.failed"
+)
+
+// Headers set on every delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription secret, or for per-upload
+// callbacks with the secret returned when the upload was created.
+const (
+	HeaderDeliveryID = "X-Webhook-ID"
+	HeaderEvent      = "X-Webhook-Event"
+	HeaderTimestamp  = "X-Webhook-Timestamp"
+	HeaderSignature  = "X-Webhook-Signature"
+)
+
+type Variant struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Payload is the JSON body POSTed to callbacks
+type Payload struct {
+	Event     string    `json:"event"`
+	ImageID   string    `json:"image_id"`
+	Filename  string    `json:"filename"`
+	Status    string    `json:"status"`
+	Variants  []Variant `json:"variants,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Sign computes the value of the signature header for a request body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a random signing secret for a subscription or callback
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Notifier records deliveries when images reach a terminal status
+type Notifier struct {
+	images   repository.ImageRepository
+	webhooks repository.WebhookRepository
+	signer   *security.URLSigner
+	baseURL  string
+	urlTTL   time.Duration
+}
+
+// NewNotifier creates a Notifier. With a signer, variant URLs in payloads are
+// signed public links valid for urlTTL; without one they point at the
+// authenticated API.
+func NewNotifier(images repository.ImageRepository, webhooks repository.WebhookRepository, signer *security.URLSigner, publicBaseURL string, urlTTL time.Duration) *Notifier {
+	return &Notifier{
+		images:   images,
+		webhooks: webhooks,
+		signer:   signer,
+		baseURL:  strings.TrimSuffix(publicBaseURL, "/"),
+		urlTTL:   urlTTL,
+	}
+}
+
+// Enqueue records a delivery for the image's own callback URL and for every
+// subscription of its owner, returning how many were recorded. The
+// Dispatcher performs the actual requests.
+func (n *Notifier) Enqueue(ctx context.Context, imageID uuid.UUID) (int, error) {
+	image, err := n.images.Get(ctx, imageID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load image: %w", err)
+	}
+
+	payload := Payload{
+		ImageID:   image.ID.String(),
+		Filename:  image.Filename,
+		Status:    string(image.Status),
+		Timestamp: time.Now().UTC(),
+	}
+	switch image.Status {
+	case models.ImageStatusCompleted:
+		payload.Event = EventCompleted
+		payload.Variants = []Variant{
+			{Name: "processed", URL: n.variantURL(image.ID, "processed")},
+			{Name: "original", URL: n.variantURL(image.ID, "original")},
+		}
+	case models.ImageStatusFailed:
+		payload.Event = EventFailed
+		payload.Error = image.Error
+	default:
+		return 0, fmt.Errorf("image %s is not in a terminal status (%s)", imageID, image.Status)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	return n.webhooks.RecordDeliveries(ctx, image, payload.Event, body)
+}
+
+func (n *Notifier) variantURL(imageID uuid.UUID, variant string) string {
+	params := url.Values{"variant": {variant}}
+	if n.signer == nil {
+		return fmt.Sprintf("%s/api/v1/images/%s/content?%s", n.baseURL, imageID, params.Encode())
+	}
+
+	path := fmt.Sprintf("/public/images/%s/content", imageID)
+	signed := n.signer.Sign(path, params, time.Now().Add(n.urlTTL).UTC().Truncate(time.Second))
+	return n.baseURL + path + "?" + signed.Encode()
+}
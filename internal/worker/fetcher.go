@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"image-processor/internal/imageformat"
+	"image-processor/internal/netguard"
+)
+
+var errRemoteTooLarge = errors.New("remote image exceeds maximum allowed size")
+
+// RemoteImage is a validated image body being streamed from a remote server
+type RemoteImage struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64 // -1 when the server did not send Content-Length
+}
+
+// Fetcher downloads images from remote URLs through a netguard transport,
+// so internal addresses cannot be reached
+type Fetcher struct {
+	client  *http.Client
+	maxSize int64
+}
+
+func NewFetcher(maxSize int64, timeout time.Duration, maxRedirects int, allowPrivate bool) *Fetcher {
+	client := &http.Client{
+		Transport: netguard.NewTransport(allowPrivate),
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+
+	return &Fetcher{client: client, maxSize: maxSize}
+}
+
+// Fetch requests rawURL and returns its body once the response has been
+// checked to be an accepted image type within the size limit. The caller
+// must close the returned body.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*RemoteImage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source URL: %w", err)
+	}
+	req.Header.Set("Accept", "image/jpeg, image/png, image/tiff")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch source URL: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("source URL returned status %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength > f.maxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w (%d bytes)", errRemoteTooLarge, resp.ContentLength)
+	}
+
+	// Servers often label images generically, so only reject types that are clearly not images
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != "application/octet-stream" && mediaType != "image/jpeg" &&
+		mediaType != "image/png" && mediaType != "image/tiff" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("source URL returned unsupported content type %q", mediaType)
+	}
+
+	body := bufio.NewReaderSize(&maxSizeReader{r: resp.Body, remaining: f.maxSize}, imageformat.SniffLen)
+	header, _ := body.Peek(imageformat.SniffLen)
+	contentType := imageformat.Detect(header)
+	if contentType == "" {
+		resp.Body.Close()
+		return nil, errors.New("source URL is not a JPEG, PNG or TIFF image")
+	}
+
+	return &RemoteImage{
+		Body:        readCloser{Reader: body, Closer: resp.Body},
+		ContentType: contentType,
+		Size:        resp.ContentLength,
+	}, nil
+}
+
+// maxSizeReader returns errRemoteTooLarge once more than remaining bytes have been read
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	if int64(n) > m.remaining {
+		return int(m.remaining), errRemoteTooLarge
+	}
+	m.remaining -= int64(n)
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
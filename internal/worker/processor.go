@@ -3,105 +3,364 @@ package worker
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"image/png"
+	"io"
 	"log"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"image-processor/internal/config"
+	"image-processor/internal/dedup"
+	"image-processor/internal/events"
+	"image-processor/internal/imageformat"
+	"image-processor/internal/imagemeta"
+	"image-processor/internal/logo"
 	"image-processor/internal/models"
-	minioclient "image-processor/internal/storage/minio"
+	"image-processor/internal/phash"
+	"image-processor/internal/placeholder"
+	"image-processor/internal/repository"
+	"image-processor/internal/storage"
+	"image-processor/internal/transform"
+	"image-processor/internal/webhook"
 	redisclient "image-processor/pkg/database/redis"
 
-	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Processor struct {
-	pgPool      *pgxpool.Pool
-	minioClient *minioclient.Client
+	images      repository.ImageRepository
+	store       storage.ObjectStore
+	layout      *storage.Layout
+	pipeline    transform.Options // applied to every upload
+	logos       *logo.Loader
 	redisClient *redisclient.Client
+	fetcher     *Fetcher
+	notifier    *webhook.Notifier
+
+	dedup        *dedup.Deduplicator
+	dedupEnabled bool
+	maxPixels    int64
 }
 
-func NewProcessor(pg *pgxpool.Pool, minio *minioclient.Client, redis *redisclient.Client) *Processor {
+func NewProcessor(cfg *config.Config, images repository.ImageRepository, store storage.ObjectStore, layout *storage.Layout, pipeline transform.Options, redis *redisclient.Client, notifier *webhook.Notifier) *Processor {
 	return &Processor{
-		pgPool:      pg,
-		minioClient: minio,
-		redisClient: redis,
+		images:       images,
+		store:        store,
+		layout:       layout,
+		pipeline:     pipeline,
+		logos:        logo.NewLoader(images, store, cfg.MaxImagePixels),
+		redisClient:  redis,
+		notifier:     notifier,
+		dedup:        dedup.NewDeduplicator(images, store),
+		dedupEnabled: cfg.DedupEnabled,
+		maxPixels:    cfg.MaxImagePixels,
+		fetcher:      NewFetcher(cfg.ImportMaxSize, cfg.ImportTimeout, cfg.ImportMaxRedirects, cfg.ImportAllowPrivate),
 	}
 }
 
+// ImportImage downloads an image from sourceURL into bucketName and then
+// processes it like an uploaded original
+func (p *Processor) ImportImage(ctx context.Context, imageID uuid.UUID, bucketName, sourceURL string) error {
+	log.Printf("Starting import for image %s from %s", imageID, sourceURL)
+
+	if err := p.updateStatus(ctx, imageID, models.ImageStatusPending, models.ImageStatusProcessing); err != nil {
+		log.Printf("Failed to update status to processing: %v", err)
+		return err
+	}
+
+	remote, err := p.fetcher.Fetch(ctx, sourceURL)
+	if err != nil {
+		err = fmt.Errorf("failed to import image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
+	defer remote.Body.Close()
+
+	objectName, meta, err := p.importObjectName(ctx, imageID, remote.ContentType)
+	if err != nil {
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
+
+	log.Printf("Storing imported image in Minio: %s/%s", bucketName, objectName)
+	_, err = p.store.UploadFile(ctx, bucketName, objectName, remote.Body, remote.Size, storage.PutOptions{
+		ContentType: remote.ContentType,
+		Metadata:    meta,
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to store imported image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
+
+	return p.process(ctx, imageID, bucketName, objectName)
+}
+
+// ProcessImage processes an uploaded original. Images that are no longer
+// pending, such as ones another worker picked up or the reconciler failed,
+// are left alone.
 func (p *Processor) ProcessImage(ctx context.Context, imageID uuid.UUID, bucketName, objectName string) error {
 	log.Printf("Starting processing for image %s", imageID)
 
 	// Update status to processing
-	if err := p.updateStatus(ctx, imageID, models.ImageStatusProcessing); err != nil {
+	if err := p.updateStatus(ctx, imageID, models.ImageStatusPending, models.ImageStatusProcessing); err != nil {
 		log.Printf("Failed to update status to processing: %v", err)
 		return err
 	}
+	return p.process(ctx, imageID, bucketName, objectName)
+}
+
+// process processes an original of an image that is processing
+func (p *Processor) process(ctx context.Context, imageID uuid.UUID, bucketName, objectName string) error {
+	params, meta, err := p.imageParams(ctx, imageID)
+	if err != nil {
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
 
 	// Download image from Minio
 	log.Printf("Downloading image from Minio: %s/%s", bucketName, objectName)
-	obj, err := p.minioClient.DownloadFile(ctx, bucketName, objectName)
+	obj, info, err := p.store.OpenFile(ctx, bucketName, objectName)
 	if err != nil {
-		p.updateStatus(ctx, imageID, models.ImageStatusFailed)
-		return fmt.Errorf("failed to download image: %w", err)
+		err = fmt.Errorf("failed to download image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
 	}
 	defer obj.Close()
 
-	// Decode image
-	img, err := imaging.Decode(obj)
+	// Hash the original first so duplicates are not decoded at all
+	hash := sha256.New()
+	if _, err := io.Copy(hash, obj); err != nil {
+		err = fmt.Errorf("failed to download image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
+	meta.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	// Metadata only needs the headers; an unreadable file fails at decoding below
+	if details, err := imagemeta.Extract(obj, info.Size); err != nil {
+		log.Printf("Warning: failed to read metadata of image %s: %v", imageID, err)
+	} else if err := p.images.SetMetadata(ctx, imageID, details); err != nil {
+		log.Printf("Warning: failed to record metadata of image %s: %v", imageID, err)
+	}
+
+	if err := p.images.SetChecksum(ctx, imageID, meta.Checksum); err != nil {
+		log.Printf("Warning: failed to record checksum of image %s: %v", imageID, err)
+	} else if p.dedupEnabled {
+		linked, err := p.dedup.Link(ctx, imageID, meta.Checksum, p.pipeline.Key())
+		if err != nil {
+			log.Printf("Warning: failed to deduplicate image %s: %v", imageID, err)
+		}
+		if linked {
+			// Linking already marked the image completed
+			p.announce(ctx, imageID, models.ImageStatusCompleted)
+			p.notify(ctx, imageID)
+			return nil
+		}
+	}
+
+	// Originals uploaded by clients are stored before their checksum is known
+	if info.Metadata != meta {
+		if err := p.store.UpdateMetadata(ctx, bucketName, objectName, meta); err != nil {
+			log.Printf("Warning: failed to update metadata of %s/%s: %v", bucketName, objectName, err)
+		}
+	}
+
+	// Metadata the pipeline keeps; outputs without it are still usable
+	embedded, err := transform.ReadMetadata(obj, p.pipeline)
 	if err != nil {
-		p.updateStatus(ctx, imageID, models.ImageStatusFailed)
-		return fmt.Errorf("failed to decode image: %w", err)
+		log.Printf("Warning: failed to read metadata to keep for image %s: %v", imageID, err)
 	}
 
-	// Resize to 800px width (maintain aspect ratio)
-	log.Printf("Resizing image to 800px width")
-	img = imaging.Resize(img, 800, 0, imaging.Lanczos)
+	// Decode image, rotated upright
+	if _, err := obj.Seek(0, io.SeekStart); err != nil {
+		err = fmt.Errorf("failed to download image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
+	// Decoding allocates for every pixel, so the size is checked from the
+	// header first
+	if err := transform.CheckPixels(obj, p.maxPixels); err != nil {
+		err = fmt.Errorf("failed to decode image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
+	img, err := transform.Decode(obj)
+	if err != nil {
+		err = fmt.Errorf("failed to decode image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
 
-	// Apply grayscale filter
-	log.Printf("Applying grayscale filter")
-	img = imaging.Grayscale(img)
+	// Hashes are only used to find similar images, so failing to record them is not fatal
+	if err := p.images.SetHashes(ctx, imageID, phash.Compute(img)); err != nil {
+		log.Printf("Warning: failed to record perceptual hashes of image %s: %v", imageID, err)
+	}
+	// Placeholders come from the original colors, before the pipeline turns them gray
+	if err := p.images.SetPlaceholder(ctx, imageID, placeholder.Compute(img)); err != nil {
+		log.Printf("Warning: failed to record placeholder of image %s: %v", imageID, err)
+	}
+
+	// Watermarks must not be left out, so a logo that cannot be loaded fails processing
+	pipeline := p.pipeline
+	if pipeline.Overlay != nil && pipeline.Overlay.LogoID != uuid.Nil {
+		watermark, err := p.logos.Load(ctx, pipeline.Overlay.LogoID)
+		if err != nil {
+			err = fmt.Errorf("failed to load watermark logo: %w", err)
+			p.markFailed(ctx, imageID, err)
+			return err
+		}
+		pipeline = pipeline.WithLogo(watermark.Image)
+	}
+
+	// Resize to 800px width (maintain aspect ratio) and apply grayscale filter
+	log.Printf("Resizing image to %dpx width and applying grayscale filter", pipeline.Width)
+	img = transform.Apply(img, pipeline)
 
 	// Encode to PNG
 	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		p.updateStatus(ctx, imageID, models.ImageStatusFailed)
-		return fmt.Errorf("failed to encode image: %w", err)
+	if err := transform.Encode(&buf, img, pipeline, embedded); err != nil {
+		err = fmt.Errorf("failed to encode image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
 	}
 
-	// Upload to processed-images bucket
-	processedObjectName := fmt.Sprintf("%s.png", imageID.String())
-	log.Printf("Uploading processed image to Minio: processed-images/%s", processedObjectName)
-	_, err = p.minioClient.UploadFile(ctx, "processed-images", processedObjectName, &buf, int64(buf.Len()), "image/png")
+	// Upload to the processed bucket and record where the result went
+	params.Ext = p.pipeline.Extension()
+	processedBucket, processedObjectName := p.layout.ProcessedBucket, p.layout.ProcessedKey(params)
+	meta.Checksum = storage.Checksum(buf.Bytes())
+	meta.Pipeline = p.pipeline.Key()
+	log.Printf("Uploading processed image: %s/%s", processedBucket, processedObjectName)
+	_, err = p.store.UploadFile(ctx, processedBucket, processedObjectName, &buf, int64(buf.Len()), storage.PutOptions{
+		ContentType: p.pipeline.ContentType(),
+		Metadata:    meta,
+	})
 	if err != nil {
-		p.updateStatus(ctx, imageID, models.ImageStatusFailed)
-		return fmt.Errorf("failed to upload processed image: %w", err)
+		err = fmt.Errorf("failed to upload processed image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
+	}
+	if err := p.images.SetProcessed(ctx, imageID, processedBucket, processedObjectName, meta.Pipeline); err != nil {
+		err = fmt.Errorf("failed to record processed image: %w", err)
+		p.markFailed(ctx, imageID, err)
+		return err
 	}
 
 	// Update status to completed
-	if err := p.updateStatus(ctx, imageID, models.ImageStatusCompleted); err != nil {
+	if err := p.updateStatus(ctx, imageID, models.ImageStatusProcessing, models.ImageStatusCompleted); err != nil {
 		log.Printf("Failed to update status to completed: %v", err)
 		return err
 	}
-
-	// Invalidate Redis cache
-	cacheKey := fmt.Sprintf("image:%s", imageID.String())
-	if err := p.redisClient.Delete(ctx, cacheKey); err != nil {
-		log.Printf("Warning: failed to invalidate cache for %s: %v", cacheKey, err)
-		// Don't fail the entire operation if cache invalidation fails
-	}
+	p.notify(ctx, imageID)
 
 	log.Printf("Successfully processed image %s", imageID)
 	return nil
 }
 
-func (p *Processor) updateStatus(ctx context.Context, imageID uuid.UUID, status models.ImageStatus) error {
-	query := `UPDATE images SET status = $1, updated_at = NOW() WHERE id = $2`
-	_, err := p.pgPool.Exec(ctx, query, status, imageID)
+// importObjectName resolves and records the key of an imported original and
+// returns it with the metadata to store alongside. The type of the original
+// is read from the extension of the record's filename, so a filename whose
+// extension does not match the downloaded type gets the canonical one appended.
+func (p *Processor) importObjectName(ctx context.Context, imageID uuid.UUID, contentType string) (string, storage.Metadata, error) {
+	params, meta, err := p.imageParams(ctx, imageID)
+	if err != nil {
+		return "", meta, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(meta.Filename))
+	if imageformat.FromExtension(ext) != contentType {
+		ext = imageformat.Extension(contentType)
+		meta.Filename += ext
+	}
+	params.Ext = ext
+	objectName := p.layout.OriginalKey(params)
+
+	if err := p.images.SetOriginal(ctx, imageID, meta.Filename, objectName); err != nil {
+		return "", meta, fmt.Errorf("failed to record original: %w", err)
+	}
+	p.invalidateCache(ctx, imageID)
+
+	return objectName, meta, nil
+}
+
+// imageParams returns the values the layout needs to resolve an image's keys
+// and the metadata stored with its objects
+func (p *Processor) imageParams(ctx context.Context, imageID uuid.UUID) (storage.KeyParams, storage.Metadata, error) {
+	params := storage.KeyParams{ID: imageID}
+	meta := storage.Metadata{ImageID: imageID.String()}
+	image, err := p.images.Get(ctx, imageID)
+	if err != nil {
+		return params, meta, fmt.Errorf("failed to load image: %w", err)
+	}
+	params.Tenant, params.Created = image.Owner, image.CreatedAt
+	meta.Owner, meta.Filename = image.Owner, image.Filename
+	return params, meta, nil
+}
+
+// markFailed records a failure and its cause. It uses a fresh deadline
+// because the task context has often expired by the time processing gives up.
+func (p *Processor) markFailed(ctx context.Context, imageID uuid.UUID, cause error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	err := p.transition(ctx, imageID, repository.Transition{
+		From:  []models.ImageStatus{models.ImageStatusProcessing},
+		To:    models.ImageStatusFailed,
+		Error: cause.Error(),
+	})
 	if err != nil {
+		log.Printf("Failed to mark image %s as failed: %v", imageID, err)
+		return
+	}
+	p.notify(ctx, imageID)
+}
+
+// notify queues webhook deliveries for an image that reached a terminal status
+func (p *Processor) notify(ctx context.Context, imageID uuid.UUID) {
+	n, err := p.notifier.Enqueue(ctx, imageID)
+	if err != nil {
+		log.Printf("Warning: failed to queue webhooks for image %s: %v", imageID, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Queued %d webhook deliveries for image %s", n, imageID)
+	}
+}
+
+// updateStatus moves an image from one status to another. It returns
+// repository.ErrConflict, wrapped, if the image is no longer in from.
+func (p *Processor) updateStatus(ctx context.Context, imageID uuid.UUID, from, to models.ImageStatus) error {
+	return p.transition(ctx, imageID, repository.Transition{From: []models.ImageStatus{from}, To: to})
+}
+
+// transition applies a status change, which also replaces the image's error
+func (p *Processor) transition(ctx context.Context, imageID uuid.UUID, t repository.Transition) error {
+	if _, err := p.images.Transition(ctx, imageID, t); err != nil {
 		return fmt.Errorf("failed to update status: %w", err)
 	}
-	log.Printf("Updated image %s status to: %s", imageID, status)
+	log.Printf("Updated image %s status to: %s", imageID, t.To)
+	p.announce(ctx, imageID, t.To)
 	return nil
 }
+
+// announce invalidates the cached metadata of an image whose status changed
+// and tells listening clients about it
+func (p *Processor) announce(ctx context.Context, imageID uuid.UUID, status models.ImageStatus) {
+	p.invalidateCache(ctx, imageID)
+	event := events.StatusEvent{ImageID: imageID.String(), Status: string(status)}
+	if err := events.PublishStatus(ctx, p.redisClient, event); err != nil {
+		log.Printf("Warning: failed to publish status event for image %s: %v", imageID, err)
+	}
+}
+
+// invalidateCache drops the cached metadata of an image
+func (p *Processor) invalidateCache(ctx context.Context, imageID uuid.UUID) {
+	cacheKey := redisclient.ImageKey(imageID.String())
+	if err := p.redisClient.Delete(ctx, cacheKey); err != nil {
+		log.Printf("Warning: failed to invalidate cache for %s: %v", cacheKey, err)
+		// Don't fail the entire operation if cache invalidation fails
+	}
+}
@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"image-processor/internal/config"
+	"image-processor/internal/events"
+	"image-processor/internal/models"
+	"image-processor/internal/repository"
+	"image-processor/internal/repository/memory"
+	"image-processor/internal/storage"
+	memstore "image-processor/internal/storage/memory"
+	"image-processor/internal/transform"
+	"image-processor/internal/webhook"
+	"image-processor/pkg/database/redis/redistest"
+
+	"github.com/google/uuid"
+)
+
+type testEnv struct {
+	processor *Processor
+	images    *memory.ImageRepository
+	webhooks  *memory.WebhookRepository
+	store     *memstore.Store
+	layout    *storage.Layout
+	redis     *redistest.Server
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	layout, err := storage.NewLayout(cfg)
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+	pipeline, err := transform.NewPipeline(cfg)
+	if err != nil {
+		t.Fatalf("failed to create pipeline: %v", err)
+	}
+	client, server := redistest.NewClient(t)
+
+	env := &testEnv{
+		images:   memory.NewImageRepository(),
+		webhooks: memory.NewWebhookRepository(),
+		store:    memstore.NewStore(layout.Buckets()),
+		layout:   layout,
+		redis:    server,
+	}
+	notifier := webhook.NewNotifier(env.images, env.webhooks, nil, "http://gateway", time.Hour)
+	env.processor = NewProcessor(cfg, env.images, env.store, layout, pipeline, client, notifier)
+	return env
+}
+
+// upload stores data as the original of a pending image of owner, the way
+// the gateway does, and returns the image
+func (e *testEnv) upload(t *testing.T, owner string, data []byte) *models.Image {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	img := &models.Image{
+		ID:         uuid.New(),
+		Filename:   "photo.png",
+		Status:     models.ImageStatusPending,
+		BucketName: e.layout.RawBucket,
+		Owner:      owner,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	img.OriginalKey = e.layout.OriginalKey(storage.KeyParams{ID: img.ID, Tenant: owner, Created: now, Ext: ".png"})
+	if _, err := e.store.UploadFile(ctx, img.BucketName, img.OriginalKey, bytes.NewReader(data), int64(len(data)), storage.PutOptions{ContentType: "image/png"}); err != nil {
+		t.Fatalf("failed to store original: %v", err)
+	}
+	if err := e.images.Create(ctx, img); err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	return img
+}
+
+func (e *testEnv) subscribe(t *testing.T, owner string) {
+	t.Helper()
+	subscription := models.WebhookSubscription{ID: uuid.New(), Owner: owner, URL: "https://example.com/hook"}
+	if err := e.webhooks.CreateSubscription(context.Background(), &subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+}
+
+func testPNG(t *testing.T, shade uint8) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 10), shade, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImageCompletes(t *testing.T) {
+	env := newTestEnv(t)
+	env.subscribe(t, "alice")
+	img := env.upload(t, "alice", testPNG(t, 128))
+	ctx := context.Background()
+
+	if err := env.processor.ProcessImage(ctx, img.ID, img.BucketName, img.OriginalKey); err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+
+	processed, err := env.images.Get(ctx, img.ID)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if processed.Status != models.ImageStatusCompleted {
+		t.Fatalf("got status %s (%s), want completed", processed.Status, processed.Error)
+	}
+	if processed.Checksum == "" || processed.Width != 32 || processed.Height != 24 {
+		t.Errorf("got checksum %q and size %dx%d, want a checksum and 32x24", processed.Checksum, processed.Width, processed.Height)
+	}
+	if _, err := env.store.StatFile(ctx, processed.ProcessedBucket, processed.ProcessedKey); err != nil {
+		t.Errorf("processed object was not stored: %v", err)
+	}
+
+	deliveries, err := env.webhooks.ListDeliveries(ctx, repository.DeliveryListOptions{Owner: "alice", ImageID: img.ID})
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event != webhook.EventCompleted {
+		t.Errorf("got deliveries %+v, want one %s delivery", deliveries, webhook.EventCompleted)
+	}
+
+	var statuses []string
+	for _, message := range env.redis.Published(events.StatusChannel) {
+		var event events.StatusEvent
+		if err := json.Unmarshal([]byte(message), &event); err != nil {
+			t.Fatalf("invalid status event %s: %v", message, err)
+		}
+		statuses = append(statuses, event.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != string(models.ImageStatusProcessing) || statuses[1] != string(models.ImageStatusCompleted) {
+		t.Errorf("got status events %v, want processing then completed", statuses)
+	}
+}
+
+func TestProcessImageSharesObjectsOfDuplicates(t *testing.T) {
+	env := newTestEnv(t)
+	data := testPNG(t, 64)
+	first := env.upload(t, "alice", data)
+	second := env.upload(t, "bob", data)
+	ctx := context.Background()
+
+	for _, img := range []*models.Image{first, second} {
+		if err := env.processor.ProcessImage(ctx, img.ID, img.BucketName, img.OriginalKey); err != nil {
+			t.Fatalf("ProcessImage: %v", err)
+		}
+	}
+
+	source, _ := env.images.Get(ctx, first.ID)
+	duplicate, err := env.images.Get(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if duplicate.Status != models.ImageStatusCompleted || duplicate.Owner != "bob" {
+		t.Errorf("got status %s and owner %q, want completed and bob", duplicate.Status, duplicate.Owner)
+	}
+	if duplicate.ProcessedKey != source.ProcessedKey || duplicate.OriginalKey != source.OriginalKey {
+		t.Errorf("duplicate does not share the objects of %s", first.ID)
+	}
+	if _, err := env.store.StatFile(ctx, second.BucketName, second.OriginalKey); err == nil {
+		t.Errorf("the duplicate's own original was not deleted")
+	}
+}
+
+func TestProcessImageFailsOnUndecodableFile(t *testing.T) {
+	env := newTestEnv(t)
+	env.subscribe(t, "alice")
+	img := env.upload(t, "alice", []byte("not an image"))
+	ctx := context.Background()
+
+	if err := env.processor.ProcessImage(ctx, img.ID, img.BucketName, img.OriginalKey); err == nil {
+		t.Fatal("ProcessImage succeeded, want an error")
+	}
+
+	failed, err := env.images.Get(ctx, img.ID)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if failed.Status != models.ImageStatusFailed || failed.Error == "" {
+		t.Errorf("got status %s and error %q, want failed with an error", failed.Status, failed.Error)
+	}
+	deliveries, _ := env.webhooks.ListDeliveries(ctx, repository.DeliveryListOptions{Owner: "alice"})
+	if len(deliveries) != 1 || deliveries[0].Event != webhook.EventFailed {
+		t.Errorf("got deliveries %+v, want one %s delivery", deliveries, webhook.EventFailed)
+	}
+}
+
+func TestProcessImageFailsOverPixelLimit(t *testing.T) {
+	env := newTestEnv(t)
+	env.processor.maxPixels = 32*24 - 1
+	img := env.upload(t, "alice", testPNG(t, 128))
+	ctx := context.Background()
+
+	if err := env.processor.ProcessImage(ctx, img.ID, img.BucketName, img.OriginalKey); !errors.Is(err, transform.ErrTooManyPixels) {
+		t.Fatalf("ProcessImage returned %v, want ErrTooManyPixels", err)
+	}
+	failed, err := env.images.Get(ctx, img.ID)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if failed.Status != models.ImageStatusFailed || !strings.Contains(failed.Error, "too many pixels") {
+		t.Errorf("got status %s and error %q, want failed for too many pixels", failed.Status, failed.Error)
+	}
+}
+
+func TestProcessImageLeavesImagesThatAreNoLongerPending(t *testing.T) {
+	env := newTestEnv(t)
+	img := env.upload(t, "alice", testPNG(t, 128))
+	ctx := context.Background()
+	// The reconciler failed the image while its task was still queued
+	if _, err := env.images.Transition(ctx, img.ID, repository.Transition{To: models.ImageStatusFailed, Error: "Reconciliation"}); err != nil {
+		t.Fatalf("failed to fail image: %v", err)
+	}
+
+	if err := env.processor.ProcessImage(ctx, img.ID, img.BucketName, img.OriginalKey); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("ProcessImage returned %v, want ErrConflict", err)
+	}
+	failed, err := env.images.Get(ctx, img.ID)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if failed.Status != models.ImageStatusFailed || failed.ProcessedKey != "" {
+		t.Errorf("got status %s and processed key %q, want the failed image untouched", failed.Status, failed.ProcessedKey)
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS images (
+	id UUID PRIMARY KEY,
+	filename TEXT NOT NULL,
+	status TEXT NOT NULL,
+	bucket_name TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	image_id UUID PRIMARY KEY REFERENCES images(id) ON DELETE CASCADE,
+	upload_id TEXT NOT NULL,
+	bucket_name TEXT NOT NULL,
+	object_name TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	total_size BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	part_etags TEXT[] NOT NULL DEFAULT '{}',
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires_at ON upload_sessions (expires_at);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
+
+ALTER TABLE images DROP COLUMN IF EXISTS error;
+ALTER TABLE images DROP COLUMN IF EXISTS callback_url;
+ALTER TABLE images DROP COLUMN IF EXISTS owner;
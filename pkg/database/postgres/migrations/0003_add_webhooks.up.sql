@@ -0,0 +1,31 @@
+ALTER TABLE images ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS callback_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS error TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+	id UUID PRIMARY KEY,
+	owner TEXT NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_owner ON webhook_subscriptions (owner);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id UUID PRIMARY KEY,
+	image_id UUID NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+	subscription_id UUID REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+	owner TEXT NOT NULL,
+	url TEXT NOT NULL,
+	event TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	response_code INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_owner ON webhook_deliveries (owner, created_at);
@@ -0,0 +1,5 @@
+-- Keys are derived from the image ID again, so images whose keys came from
+-- custom templates lose track of their objects
+ALTER TABLE images DROP COLUMN IF EXISTS processed_key;
+ALTER TABLE images DROP COLUMN IF EXISTS processed_bucket;
+ALTER TABLE images DROP COLUMN IF EXISTS original_key;
@@ -0,0 +1,21 @@
+-- Object keys used to be derived from the image ID; backfill them for
+-- existing rows so every image has its keys stored. The backfill only runs
+-- when the columns are added, since keys are legitimately empty later on
+-- (imports not fetched yet, originals removed by retention).
+DO $$
+BEGIN
+	IF NOT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'images' AND column_name = 'original_key'
+	) THEN
+		ALTER TABLE images ADD COLUMN original_key TEXT NOT NULL DEFAULT '';
+		ALTER TABLE images ADD COLUMN processed_bucket TEXT NOT NULL DEFAULT '';
+		ALTER TABLE images ADD COLUMN processed_key TEXT NOT NULL DEFAULT '';
+
+		UPDATE images
+		SET original_key = id::text || lower(COALESCE(substring(filename from '(\.[^.]*)$'), ''));
+		UPDATE images
+		SET processed_bucket = 'processed-images', processed_key = id::text || '.png'
+		WHERE status = 'completed';
+	END IF;
+END $$;
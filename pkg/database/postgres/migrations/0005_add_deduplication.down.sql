@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS object_refs;
+DROP INDEX IF EXISTS idx_images_checksum;
+ALTER TABLE upload_sessions DROP COLUMN IF EXISTS hash_state;
+ALTER TABLE images DROP COLUMN IF EXISTS pipeline;
+ALTER TABLE images DROP COLUMN IF EXISTS checksum;
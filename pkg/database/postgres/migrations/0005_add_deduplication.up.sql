@@ -0,0 +1,13 @@
+-- The SHA-256 of each original, the pipeline that produced the processed
+-- object, and reference counts of objects shared by images
+ALTER TABLE images ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS pipeline TEXT NOT NULL DEFAULT '';
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS hash_state BYTEA NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_images_checksum ON images (checksum, pipeline) WHERE status = 'completed';
+
+CREATE TABLE IF NOT EXISTS object_refs (
+	bucket_name TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	refs INT NOT NULL,
+	PRIMARY KEY (bucket_name, object_key)
+);
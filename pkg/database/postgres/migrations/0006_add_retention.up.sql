@@ -0,0 +1,5 @@
+-- Last access for expiring inactive images, and soft deletes purged by the
+-- janitor after a grace period
+ALTER TABLE images ADD COLUMN IF NOT EXISTS accessed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_images_deleted_at ON images (deleted_at) WHERE deleted_at IS NOT NULL;
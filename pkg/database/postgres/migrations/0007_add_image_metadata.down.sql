@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_images_owner_created_at;
+ALTER TABLE images DROP COLUMN IF EXISTS exif;
+ALTER TABLE images DROP COLUMN IF EXISTS has_icc_profile;
+ALTER TABLE images DROP COLUMN IF EXISTS gps_longitude;
+ALTER TABLE images DROP COLUMN IF EXISTS gps_latitude;
+ALTER TABLE images DROP COLUMN IF EXISTS taken_at;
+ALTER TABLE images DROP COLUMN IF EXISTS camera_model;
+ALTER TABLE images DROP COLUMN IF EXISTS camera_make;
+ALTER TABLE images DROP COLUMN IF EXISTS orientation;
+ALTER TABLE images DROP COLUMN IF EXISTS size_bytes;
+ALTER TABLE images DROP COLUMN IF EXISTS format;
+ALTER TABLE images DROP COLUMN IF EXISTS height;
+ALTER TABLE images DROP COLUMN IF EXISTS width;
@@ -0,0 +1,17 @@
+-- Properties of the original read by the worker. Zero values and NULLs mean
+-- unknown: not extracted yet, or absent from the file.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS size_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS orientation SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS camera_make TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS camera_model TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS taken_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS gps_latitude DOUBLE PRECISION;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS gps_longitude DOUBLE PRECISION;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS has_icc_profile BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS exif JSONB;
+
+-- Listings page through an owner's images newest first
+CREATE INDEX IF NOT EXISTS idx_images_owner_created_at ON images (owner, created_at DESC) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE images DROP COLUMN IF EXISTS phash;
+ALTER TABLE images DROP COLUMN IF EXISTS dhash;
+ALTER TABLE images DROP COLUMN IF EXISTS ahash;
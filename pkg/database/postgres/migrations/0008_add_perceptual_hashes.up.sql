@@ -0,0 +1,5 @@
+-- Perceptual hashes of the decoded original, stored as the bits of a uint64.
+-- Similar images are found by the Hamming distance between hashes.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS ahash BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS dhash BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS phash BIGINT;
@@ -0,0 +1,4 @@
+-- What clients show while an image loads, computed from the decoded original.
+-- Empty and NULL until the worker has decoded it.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS blurhash TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS palette TEXT[];
@@ -0,0 +1,2 @@
+-- Per-upload callbacks are signed with a secret of their own
+ALTER TABLE images ADD COLUMN IF NOT EXISTS callback_secret TEXT NOT NULL DEFAULT '';
@@ -3,7 +3,6 @@ package postgres
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -36,23 +35,3 @@ func NewClient(ctx context.Context, connectionString string) (*pgxpool.Pool, err
 
 	return pool, nil
 }
-
-// RunMigrations creates necessary tables if they don't exist
-func RunMigrations(ctx context.Context, pool *pgxpool.Pool) error {
-	query := `
-	CREATE TABLE IF NOT EXISTS images (
-		id UUID PRIMARY KEY,
-		filename TEXT NOT NULL,
-		status TEXT NOT NULL,
-		bucket_name TEXT NOT NULL,
-		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-	);
-	`
-	_, err := pool.Exec(ctx, query)
-	if err != nil {
-		return fmt.Errorf("failed to create images table: %w", err)
-	}
-	log.Println("Migrations executed successfully")
-	return nil
-}
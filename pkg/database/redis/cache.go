@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheSchemaVersion is part of every cache key. Bump it whenever the shape of
+// a cached value changes so new code never deserializes blobs written by old code.
+const CacheSchemaVersion = "v8"
+
+// ErrNotFound is returned by a loader when the entity does not exist. The
+// cache remembers it for the negative TTL and returns it to later callers.
+var ErrNotFound = errors.New("not found")
+
+// notFoundMarker is stored in place of a value for negatively cached keys
+const notFoundMarker = "\x00notfound"
+
+const (
+	lockTTL      = 5 * time.Second
+	lockWait     = 100 * time.Millisecond
+	lockAttempts = 10
+)
+
+// Loader fetches a value on a cache miss and returns it with the TTL to cache it for
+type Loader func(ctx context.Context) ([]byte, time.Duration, error)
+
+// Cache implements cache-aside on top of Client. Concurrent misses for the
+// same key are collapsed into one load per process, and a short Redis lock
+// keeps other instances from loading the same key at the same time.
+type Cache struct {
+	client      *Client
+	negativeTTL time.Duration
+	group       singleflight.Group
+}
+
+func NewCache(client *Client, negativeTTL time.Duration) *Cache {
+	return &Cache{client: client, negativeTTL: negativeTTL}
+}
+
+// ImageKey returns the cache key for an image's metadata
+func ImageKey(imageID string) string {
+	return fmt.Sprintf("image:%s:%s", CacheSchemaVersion, imageID)
+}
+
+// GetOrLoad returns the cached value for key, calling load on a miss. Redis
+// errors are logged and treated as misses so the cache never takes the
+// caller down with it.
+func (c *Cache) GetOrLoad(ctx context.Context, key string, load Loader) ([]byte, error) {
+	if value, ok, err := c.lookup(ctx, key); ok {
+		return value, err
+	}
+
+	value, err, _ := c.group.Do(key, func() (interface{}, error) {
+		return c.loadLocked(ctx, key, load)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]byte), nil
+}
+
+// Invalidate removes key so the next read reloads it
+func (c *Cache) Invalidate(ctx context.Context, key string) error {
+	return c.client.Delete(ctx, key)
+}
+
+// lookup reads key from Redis. ok is false on a miss.
+func (c *Cache) lookup(ctx context.Context, key string) ([]byte, bool, error) {
+	cached, err := c.client.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrKeyNotFound) {
+			log.Printf("Warning: cache read for %s failed: %v", key, err)
+		}
+		return nil, false, nil
+	}
+	if cached == notFoundMarker {
+		return nil, true, ErrNotFound
+	}
+	return []byte(cached), true, nil
+}
+
+// loadLocked loads key while holding a short-lived Redis lock. If another
+// instance holds the lock it waits for that instance to fill the cache,
+// falling back to loading itself if it does not do so in time.
+func (c *Cache) loadLocked(ctx context.Context, key string, load Loader) ([]byte, error) {
+	lockKey := "lock:" + key
+	acquired, err := c.client.SetNX(ctx, lockKey, "1", lockTTL)
+	if err != nil {
+		log.Printf("Warning: cache lock for %s failed: %v", key, err)
+	}
+
+	if err == nil && !acquired {
+		for i := 0; i < lockAttempts; i++ {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(lockWait):
+			}
+			if value, ok, err := c.lookup(ctx, key); ok {
+				return value, err
+			}
+		}
+	}
+	if acquired {
+		defer c.client.Delete(context.WithoutCancel(ctx), lockKey)
+	}
+
+	value, ttl, err := load(ctx)
+	if errors.Is(err, ErrNotFound) {
+		c.store(ctx, key, notFoundMarker, c.negativeTTL)
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(ctx, key, string(value), ttl)
+	return value, nil
+}
+
+func (c *Cache) store(ctx context.Context, key, value string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	if err := c.client.Set(ctx, key, value, ttl); err != nil {
+		log.Printf("Warning: cache write for %s failed: %v", key, err)
+	}
+}
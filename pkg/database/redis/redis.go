@@ -2,12 +2,16 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrKeyNotFound is returned by Get when the key does not exist
+var ErrKeyNotFound = errors.New("key not found")
+
 type Client struct {
 	client *redis.Client
 }
@@ -35,7 +39,7 @@ func NewClient(addr string) (*Client, error) {
 func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	val, err := c.client.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("key not found")
+		return "", ErrKeyNotFound
 	} else if err != nil {
 		return "", fmt.Errorf("failed to get key: %w", err)
 	}
@@ -51,6 +55,15 @@ func (c *Client) Set(ctx context.Context, key string, value interface{}, expirat
 	return nil
 }
 
+// SetNX sets a key only if it does not already exist and reports whether it was set
+func (c *Client) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	ok, err := c.client.SetNX(ctx, key, value, expiration).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to set key: %w", err)
+	}
+	return ok, nil
+}
+
 // Delete removes a key from Redis
 func (c *Client) Delete(ctx context.Context, key string) error {
 	err := c.client.Del(ctx, key).Err()
@@ -60,6 +73,67 @@ func (c *Client) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// DeleteIfEqualScript deletes a key only while it holds the given value
+const DeleteIfEqualScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+var deleteIfEqual = redis.NewScript(DeleteIfEqualScript)
+
+// DeleteIfEqual removes key only if it still holds value and reports whether
+// it did. Locks are released with it so that a holder whose lock expired
+// cannot release the lock another holder took since.
+func (c *Client) DeleteIfEqual(ctx context.Context, key, value string) (bool, error) {
+	deleted, err := deleteIfEqual.Run(ctx, c.client, []string{key}, value).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete key: %w", err)
+	}
+	return deleted == 1, nil
+}
+
+// Publish sends a message to a pub/sub channel
+func (c *Client) Publish(ctx context.Context, channel string, message interface{}) error {
+	if err := c.client.Publish(ctx, channel, message).Err(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", channel, err)
+	}
+	return nil
+}
+
+// Subscribe delivers the payloads published to channel until ctx is cancelled,
+// then closes the returned channel. Dropped connections are re-established
+// automatically.
+func (c *Client) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	pubsub := c.client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case out <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
 // Close closes the Redis connection
 func (c *Client) Close() error {
 	return c.client.Close()
@@ -0,0 +1,278 @@
+// Package redistest runs an in-process stand-in for Redis that understands
+// the commands the services use, so tests need no Redis server.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	redisclient "image-processor/pkg/database/redis"
+)
+
+// Server speaks enough RESP2 for GET, SET with EX, PX and NX, DEL, PUBLISH
+// and EVAL of the scripts the client runs. Other commands are answered with
+// an error. Published messages are recorded rather than delivered.
+type Server struct {
+	listener net.Listener
+
+	mu        sync.Mutex
+	values    map[string]string
+	expires   map[string]time.Time
+	published map[string][]string
+}
+
+// NewServer starts a server that is stopped when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &Server{
+		listener:  listener,
+		values:    make(map[string]string),
+		expires:   make(map[string]time.Time),
+		published: make(map[string][]string),
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// NewClient starts a server and returns a client connected to it
+func NewClient(t testing.TB) (*redisclient.Client, *Server) {
+	t.Helper()
+	s := NewServer(t)
+	client, err := redisclient.NewClient(s.Addr())
+	if err != nil {
+		t.Fatalf("failed to connect to test redis: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, s
+}
+
+// Addr returns the address clients connect to
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Published returns the messages published to channel so far
+func (s *Server) Published(channel string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.published[channel]...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.execute(w, args)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("unexpected argument %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (s *Server) execute(w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(w, "-ERR empty command\r\n")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		fmt.Fprint(w, "+PONG\r\n")
+	case "GET":
+		if len(args) != 2 {
+			fmt.Fprint(w, "-ERR wrong number of arguments\r\n")
+			return
+		}
+		value, ok := s.get(args[1])
+		if !ok {
+			fmt.Fprint(w, "$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		s.set(w, args[1:])
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := s.get(key); ok {
+				deleted++
+			}
+			delete(s.values, key)
+			delete(s.expires, key)
+		}
+		fmt.Fprintf(w, ":%d\r\n", deleted)
+	case "EVALSHA":
+		// Scripts are not cached, so clients fall back to EVAL
+		fmt.Fprint(w, "-NOSCRIPT No matching script\r\n")
+	case "EVAL":
+		s.eval(w, args[1:])
+	case "PUBLISH":
+		if len(args) != 3 {
+			fmt.Fprint(w, "-ERR wrong number of arguments\r\n")
+			return
+		}
+		s.published[args[1]] = append(s.published[args[1]], args[2])
+		fmt.Fprint(w, ":0\r\n")
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// eval handles EVAL script numkeys key... arg... for the scripts of the
+// client. The caller must hold mu.
+func (s *Server) eval(w *bufio.Writer, args []string) {
+	if len(args) < 2 {
+		fmt.Fprint(w, "-ERR wrong number of arguments\r\n")
+		return
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		fmt.Fprint(w, "-ERR invalid number of keys\r\n")
+		return
+	}
+	keys, argv := args[2:2+numKeys], args[2+numKeys:]
+
+	switch args[0] {
+	case redisclient.DeleteIfEqualScript:
+		if len(keys) != 1 || len(argv) != 1 {
+			fmt.Fprint(w, "-ERR wrong number of arguments\r\n")
+			return
+		}
+		if value, ok := s.get(keys[0]); !ok || value != argv[0] {
+			fmt.Fprint(w, ":0\r\n")
+			return
+		}
+		delete(s.values, keys[0])
+		delete(s.expires, keys[0])
+		fmt.Fprint(w, ":1\r\n")
+	default:
+		fmt.Fprint(w, "-ERR unknown script\r\n")
+	}
+}
+
+// get returns a value that has not expired. The caller must hold mu.
+func (s *Server) get(key string) (string, bool) {
+	if expires, ok := s.expires[key]; ok && !time.Now().Before(expires) {
+		delete(s.values, key)
+		delete(s.expires, key)
+	}
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// set handles SET key value [EX seconds | PX milliseconds] [NX]. The caller
+// must hold mu.
+func (s *Server) set(w *bufio.Writer, args []string) {
+	if len(args) < 2 {
+		fmt.Fprint(w, "-ERR wrong number of arguments\r\n")
+		return
+	}
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	nx := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "EX", "PX":
+			if i+1 == len(args) {
+				fmt.Fprint(w, "-ERR syntax error\r\n")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				fmt.Fprint(w, "-ERR value is not an integer\r\n")
+				return
+			}
+			unit := time.Second
+			if strings.ToUpper(args[i]) == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			fmt.Fprint(w, "-ERR syntax error\r\n")
+			return
+		}
+	}
+
+	if _, exists := s.get(key); nx && exists {
+		fmt.Fprint(w, "$-1\r\n")
+		return
+	}
+	s.values[key] = value
+	delete(s.expires, key)
+	if ttl > 0 {
+		s.expires[key] = time.Now().Add(ttl)
+	}
+	fmt.Fprint(w, "+OK\r\n")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -102,3 +103,24 @@ func AuthMiddleware(jwksURL, clientID string) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// HasRole reports whether the user authenticated by AuthMiddleware holds a
+// Keycloak realm role
+func HasRole(c *gin.Context, role string) bool {
+	value, _ := c.Get("claims")
+	claims, ok := value.(*KeycloakClaims)
+	return ok && slices.Contains(claims.RealmAccess.Roles, role)
+}
+
+// RequireRole creates a Gin middleware that only lets through users holding
+// a Keycloak realm role. It must run after AuthMiddleware.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasRole(c, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("The %s role is required", role)})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
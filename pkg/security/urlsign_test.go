@@ -0,0 +1,100 @@
+package security
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T, specs ...string) *URLSigner {
+	t.Helper()
+	signer, err := NewURLSigner(specs)
+	if err != nil {
+		t.Fatalf("NewURLSigner: %v", err)
+	}
+	return signer
+}
+
+// flipFirst changes the first character of a signature
+func flipFirst(sig string) string {
+	if sig[0] == 'A' {
+		return "B" + sig[1:]
+	}
+	return "A" + sig[1:]
+}
+
+func TestVerifyAcceptsSignedURLs(t *testing.T) {
+	signer := newTestSigner(t, "k1:"+strings.Repeat("a", 32))
+	now := time.Now()
+	path := "/public/images/123/render"
+	signed := signer.Sign(path, url.Values{"w": {"400"}}, now.Add(time.Hour))
+
+	if err := signer.Verify(path, signed, now); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	// Encoding the query and parsing it back must not change the signature
+	parsed, err := url.ParseQuery(signed.Encode())
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	if err := signer.Verify(path, parsed, now); err != nil {
+		t.Errorf("Verify after a round trip: %v", err)
+	}
+}
+
+func TestVerifyRejectsExpiredAndTamperedURLs(t *testing.T) {
+	signer := newTestSigner(t, "k1:"+strings.Repeat("a", 32))
+	now := time.Now()
+	path := "/public/images/123/render"
+	signed := signer.Sign(path, url.Values{"w": {"400"}}, now.Add(time.Hour))
+
+	tamper := func(change func(url.Values)) url.Values {
+		params := url.Values{}
+		for k, v := range signed {
+			params[k] = append([]string(nil), v...)
+		}
+		change(params)
+		return params
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		params url.Values
+		now    time.Time
+		want   error
+	}{
+		{"expired", path, signed, now.Add(2 * time.Hour), ErrExpiredSignature},
+		{"other path", "/public/images/456/render", signed, now, ErrInvalidSignature},
+		{"changed parameter", path, tamper(func(p url.Values) { p.Set("w", "4000") }), now, ErrInvalidSignature},
+		{"added parameter", path, tamper(func(p url.Values) { p.Set("h", "10") }), now, ErrInvalidSignature},
+		{"extended expiry", path, tamper(func(p url.Values) { p.Set(ExpiresParam, "99999999999") }), now, ErrInvalidSignature},
+		{"changed signature", path, tamper(func(p url.Values) { p.Set(SignatureParam, flipFirst(p.Get(SignatureParam))) }), now, ErrInvalidSignature},
+		{"missing signature", path, tamper(func(p url.Values) { p.Del(SignatureParam) }), now, ErrMissingSignature},
+		{"unknown key", path, tamper(func(p url.Values) { p.Set(KeyIDParam, "k2") }), now, ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := signer.Verify(tt.path, tt.params, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatedKeysStillVerify(t *testing.T) {
+	old := newTestSigner(t, "k1:"+strings.Repeat("a", 32))
+	rotated := newTestSigner(t, "k2:"+strings.Repeat("b", 32), "k1:"+strings.Repeat("a", 32))
+	dropped := newTestSigner(t, "k2:"+strings.Repeat("b", 32))
+	now := time.Now()
+	signed := old.Sign("/public/images/123/content", url.Values{}, now.Add(time.Hour))
+
+	if err := rotated.Verify("/public/images/123/content", signed, now); err != nil {
+		t.Errorf("Verify with the old key still listed: %v", err)
+	}
+	if err := dropped.Verify("/public/images/123/content", signed, now); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify with the old key dropped returned %v, want ErrUnknownKey", err)
+	}
+}